@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessCodeSpec 签发访问码的参数，ClientId和UserId至少提供一个，TTL为0表示不过期，MaxUses为0表示不限次数，为1表示一次性访问码
+type AccessCodeSpec struct {
+	ClientId string
+	UserId   string
+	TTL      time.Duration
+	MaxUses  int
+}
+
+// AccessCodeRecord 已签发的访问码
+type AccessCodeRecord struct {
+	Code      string    `json:"code"`
+	ClientId  string    `json:"clientId,omitempty"`
+	UserId    string    `json:"userId,omitempty"`
+	MaxUses   int       `json:"maxUses"`
+	Uses      int       `json:"uses"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpireAt  time.Time `json:"expireAt,omitempty"` // 零值表示不过期
+}
+
+// AccessCodeFilter 按绑定的客户端或用户筛选访问码
+type AccessCodeFilter struct {
+	ClientId string
+	UserId   string
+}
+
+// AccessCodeStore 访问码的签发、使用、撤销和查询，ConsumeAccessCode在访问码无效、过期或次数用尽时返回nil
+type AccessCodeStore interface {
+	IssueAccessCode(ctx context.Context, spec AccessCodeSpec) (*AccessCodeRecord, error)
+	ConsumeAccessCode(ctx context.Context, code string) (*AccessCodeRecord, error)
+	RevokeAccessCode(ctx context.Context, code string) error
+	ListAccessCodes(ctx context.Context, filter AccessCodeFilter) ([]*AccessCodeRecord, error)
+}
+
+var (
+	_ AccessCodeStore    = (*RedisJwtUtil)(nil)
+	_ AccessCodeStore    = (*MemoryAccessCodeStore)(nil)
+	_ AccessCodeProvider = (*AccessCodeValidator)(nil)
+)
+
+// AccessCodeValidator 基于AccessCodeStore实现AccessCodeProvider，每次校验计为一次使用
+type AccessCodeValidator struct {
+	store AccessCodeStore
+}
+
+func NewAccessCodeValidator(store AccessCodeStore) *AccessCodeValidator {
+	return &AccessCodeValidator{store: store}
+}
+
+func (v *AccessCodeValidator) IsAccessCodeOk(ctx context.Context, code string) (bool, error) {
+	if len(code) == 0 {
+		return false, ErrAccessCodeEmpty
+	}
+	record, err := v.store.ConsumeAccessCode(ctx, code)
+	return record != nil, err
+}
+
+func newAccessCodeRecord(spec AccessCodeSpec) (*AccessCodeRecord, error) {
+	if len(spec.ClientId) == 0 && len(spec.UserId) == 0 {
+		return nil, ErrAccessCodeOwnerEmpty
+	}
+	record := &AccessCodeRecord{
+		Code:      GenerateRandomKey(),
+		ClientId:  spec.ClientId,
+		UserId:    spec.UserId,
+		MaxUses:   spec.MaxUses,
+		CreatedAt: time.Now(),
+	}
+	if spec.TTL > 0 {
+		record.ExpireAt = record.CreatedAt.Add(spec.TTL)
+	}
+	return record, nil
+}
+
+func (r *AccessCodeRecord) match(filter AccessCodeFilter) bool {
+	return (len(filter.ClientId) == 0 || r.ClientId == filter.ClientId) &&
+		(len(filter.UserId) == 0 || r.UserId == filter.UserId)
+}
+
+func sortAccessCodes(records []*AccessCodeRecord) {
+	sort.Slice(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+}
+
+// MemoryAccessCodeStore 进程内的访问码存储，仅适用于单实例部署和测试
+type MemoryAccessCodeStore struct {
+	mu      sync.Mutex
+	records map[string]*AccessCodeRecord
+}
+
+func NewMemoryAccessCodeStore() *MemoryAccessCodeStore {
+	return &MemoryAccessCodeStore{records: make(map[string]*AccessCodeRecord)}
+}
+
+func (s *MemoryAccessCodeStore) IssueAccessCode(_ context.Context, spec AccessCodeSpec) (*AccessCodeRecord, error) {
+	record, err := newAccessCodeRecord(spec)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.Code] = record
+	copied := *record
+	return &copied, nil
+}
+
+func (s *MemoryAccessCodeStore) ConsumeAccessCode(_ context.Context, code string) (*AccessCodeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[code]
+	if !ok {
+		return nil, nil
+	}
+	if !record.ExpireAt.IsZero() && time.Now().After(record.ExpireAt) {
+		delete(s.records, code)
+		return nil, nil
+	}
+	record.Uses++
+	if record.MaxUses > 0 && record.Uses >= record.MaxUses {
+		delete(s.records, code)
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (s *MemoryAccessCodeStore) RevokeAccessCode(_ context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, code)
+	return nil
+}
+
+func (s *MemoryAccessCodeStore) ListAccessCodes(_ context.Context, filter AccessCodeFilter) ([]*AccessCodeRecord, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]*AccessCodeRecord, 0)
+	for code, record := range s.records {
+		if !record.ExpireAt.IsZero() && now.After(record.ExpireAt) {
+			delete(s.records, code)
+			continue
+		}
+		if record.match(filter) {
+			copied := *record
+			records = append(records, &copied)
+		}
+	}
+	sortAccessCodes(records)
+	return records, nil
+}
+
+// consumeAccessCodeScript 使用次数加一，次数用尽时删除访问码，访问码不存在时返回false
+var consumeAccessCodeScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return false
+end
+local record = cjson.decode(data)
+record['uses'] = record['uses'] + 1
+data = cjson.encode(record)
+if record['maxUses'] > 0 and record['uses'] >= record['maxUses'] then
+	redis.call('DEL', KEYS[1])
+else
+	local ttl = redis.call('PTTL', KEYS[1])
+	if ttl > 0 then
+		redis.call('SET', KEYS[1], data, 'PX', ttl)
+	else
+		redis.call('SET', KEYS[1], data)
+	end
+end
+return data
+`)
+
+// addAccessCodeIndexScript 将ARGV[1]加入索引，索引的有效期不短于访问码的有效期ARGV[2]（毫秒），
+// ARGV[2]为0表示访问码不过期，索引也不再过期
+var addAccessCodeIndexScript = redis.NewScript(`
+local existed = redis.call('EXISTS', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl == 0 then
+	redis.call('PERSIST', KEYS[1])
+	return 1
+end
+local current = redis.call('PTTL', KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// IssueAccessCode 访问码保存在Prefix::AccessCode::访问码，并按客户端和用户建立索引，访问码和索引在同一个事务中写入
+func (j *RedisJwtUtil) IssueAccessCode(ctx context.Context, spec AccessCodeSpec) (*AccessCodeRecord, error) {
+	record, err := newAccessCodeRecord(spec)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	_, err = j.UniversalClient().TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, j.GetAccessCodeCacheKey(record.Code), data, spec.TTL)
+		for _, index := range j.accessCodeIndexKeys(AccessCodeFilter{ClientId: record.ClientId, UserId: record.UserId}) {
+			addAccessCodeIndexScript.Eval(ctx, pipe, []string{index}, record.Code, spec.TTL.Milliseconds())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (j *RedisJwtUtil) ConsumeAccessCode(ctx context.Context, code string) (*AccessCodeRecord, error) {
+	data, err := consumeAccessCodeScript.Run(ctx, j.UniversalClient(), []string{j.GetAccessCodeCacheKey(code)}).Text()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &AccessCodeRecord{}
+	if err = json.Unmarshal([]byte(data), record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// RevokeAccessCode 删除访问码并从索引中移除，访问码不存在时不做任何操作
+func (j *RedisJwtUtil) RevokeAccessCode(ctx context.Context, code string) error {
+	client := j.UniversalClient()
+	key := j.GetAccessCodeCacheKey(code)
+	data, err := client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	record := &AccessCodeRecord{}
+	if err = json.Unmarshal(data, record); err != nil {
+		return err
+	}
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		for _, index := range j.accessCodeIndexKeys(AccessCodeFilter{ClientId: record.ClientId, UserId: record.UserId}) {
+			pipe.SRem(ctx, index, code)
+		}
+		return nil
+	})
+	return err
+}
+
+// ListAccessCodes 需要提供ClientId或UserId，同时清理索引中已过期或已撤销的访问码
+func (j *RedisJwtUtil) ListAccessCodes(ctx context.Context, filter AccessCodeFilter) ([]*AccessCodeRecord, error) {
+	indexes := j.accessCodeIndexKeys(filter)
+	if len(indexes) == 0 {
+		return nil, ErrAccessCodeOwnerEmpty
+	}
+	client := j.UniversalClient()
+	codes, err := client.SMembers(ctx, indexes[0]).Result()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]*AccessCodeRecord, 0, len(codes))
+	for _, code := range codes {
+		data, err := client.Get(ctx, j.GetAccessCodeCacheKey(code)).Bytes()
+		if err == redis.Nil {
+			for _, index := range indexes {
+				if err = client.SRem(ctx, index, code).Err(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		record := &AccessCodeRecord{}
+		if err = json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+		if record.match(filter) {
+			records = append(records, record)
+		}
+	}
+	sortAccessCodes(records)
+	return records, nil
+}
+
+func (j *RedisJwtUtil) GetAccessCodeCacheKey(code string) string {
+	return strings.Join([]string{j.Config.Prefix, AccessCodeCachePrefix, code}, j.Config.CacheSplitter)
+}
+
+func (j *RedisJwtUtil) accessCodeIndexKeys(filter AccessCodeFilter) []string {
+	var keys []string
+	if len(filter.ClientId) > 0 {
+		keys = append(keys, strings.Join([]string{j.Config.Prefix, AccessCodeCachePrefix, "client", filter.ClientId}, j.Config.CacheSplitter))
+	}
+	if len(filter.UserId) > 0 {
+		keys = append(keys, strings.Join([]string{j.Config.Prefix, AccessCodeCachePrefix, "user", filter.UserId}, j.Config.CacheSplitter))
+	}
+	return keys
+}
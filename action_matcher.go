@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	ActionWildcard         = "*"  // 匹配任意服务、任意方法，或路径中的任意一段
+	ActionCatchAll         = "**" // 匹配路径剩余的零段或多段，只能位于末尾
+	ActionParamPrefix      = ":"  // 命名参数，匹配路径中的任意一段
+	actionPathSeparator    = "/"
+	actionMatcherMaxParams = 16
+)
+
+var ErrInvalidActionRoute = errors.New("操作路由配置错误")
+
+// ActionRoute 操作到权限码的映射，Service和Method为空或*时匹配任意值，
+// Path支持/orders/:id、/files/*和/admin/**等形式，Codes需全部满足，为空表示登录即可访问
+type ActionRoute struct {
+	Service string   `json:"service,omitempty" yaml:"service,omitempty"`
+	Method  string   `json:"method,omitempty" yaml:"method,omitempty"`
+	Path    string   `json:"path" yaml:"path"`
+	Codes   []string `json:"codes,omitempty" yaml:"codes,omitempty"`
+}
+
+// ActionMatch 匹配结果，Params为路径中的命名参数
+type ActionMatch struct {
+	Route  *ActionRoute
+	Params map[string]string
+}
+
+// ActionMatcher 按路径段构建的前缀树，匹配优先级依次为：指定服务优先于通配服务，
+// 同一服务内静态段优先于参数段和*，再优先于**，路径相同时指定方法优先于通配方法
+type ActionMatcher struct {
+	services map[string]*actionNode
+}
+
+type actionNode struct {
+	static   map[string]*actionNode
+	param    *actionNode
+	catchAll *actionNode
+	routes   map[string]*actionEntry // 方法 -> 路由
+}
+
+type actionEntry struct {
+	route *ActionRoute
+	names []string // 各参数段的名称，*为空字符串
+}
+
+func NewActionMatcher(routes []ActionRoute) (*ActionMatcher, error) {
+	m := &ActionMatcher{services: make(map[string]*actionNode)}
+	for i := range routes {
+		if err := m.add(routes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *ActionMatcher) add(route ActionRoute) error {
+	if !strings.HasPrefix(route.Path, actionPathSeparator) {
+		return fmt.Errorf("%w: 路径必须以/开头: %s", ErrInvalidActionRoute, route.Path)
+	}
+	service := normalizeActionWildcard(route.Service)
+	method := strings.ToUpper(normalizeActionWildcard(route.Method))
+	root, ok := m.services[service]
+	if !ok {
+		root = &actionNode{}
+		m.services[service] = root
+	}
+	node := root
+	var names []string
+	segments := splitActionPath(route.Path)
+	for i, seg := range segments {
+		switch {
+		case seg == ActionCatchAll:
+			if i != len(segments)-1 {
+				return fmt.Errorf("%w: **只能位于路径末尾: %s", ErrInvalidActionRoute, route.Path)
+			}
+			if node.catchAll == nil {
+				node.catchAll = &actionNode{}
+			}
+			node = node.catchAll
+		case seg == ActionWildcard || strings.HasPrefix(seg, ActionParamPrefix):
+			if len(names) >= actionMatcherMaxParams {
+				return fmt.Errorf("%w: 参数过多: %s", ErrInvalidActionRoute, route.Path)
+			}
+			names = append(names, strings.TrimPrefix(strings.TrimPrefix(seg, ActionParamPrefix), ActionWildcard))
+			if node.param == nil {
+				node.param = &actionNode{}
+			}
+			node = node.param
+		default:
+			if node.static == nil {
+				node.static = make(map[string]*actionNode)
+			}
+			child, ok := node.static[seg]
+			if !ok {
+				child = &actionNode{}
+				node.static[seg] = child
+			}
+			node = child
+		}
+	}
+	if node.routes == nil {
+		node.routes = make(map[string]*actionEntry)
+	}
+	if _, ok := node.routes[method]; ok {
+		return fmt.Errorf("%w: 路由重复: %s %s %s", ErrInvalidActionRoute, service, method, route.Path)
+	}
+	route.Service = service
+	route.Method = method
+	node.routes[method] = &actionEntry{route: &route, names: names}
+	return nil
+}
+
+// Match 查找操作对应的路由，未找到时ok为false
+func (m *ActionMatcher) Match(service string, method string, path string) (match *ActionMatch, ok bool) {
+	if m == nil {
+		return nil, false
+	}
+	segments := splitActionPath(path)
+	method = strings.ToUpper(method)
+	values := make([]string, 0, actionMatcherMaxParams)
+	for _, s := range []string{service, ActionWildcard} {
+		root, exists := m.services[s]
+		if !exists {
+			continue
+		}
+		if entry, values := root.lookup(segments, method, values[:0]); entry != nil {
+			match = &ActionMatch{Route: entry.route}
+			for i, name := range entry.names {
+				if len(name) == 0 {
+					continue
+				}
+				if match.Params == nil {
+					match.Params = make(map[string]string, len(entry.names))
+				}
+				match.Params[name] = values[i]
+			}
+			return match, true
+		}
+		if s == ActionWildcard {
+			break
+		}
+	}
+	return nil, false
+}
+
+func (n *actionNode) lookup(segments []string, method string, values []string) (*actionEntry, []string) {
+	if len(segments) == 0 {
+		if entry := n.route(method); entry != nil {
+			return entry, values
+		}
+	} else {
+		if child, ok := n.static[segments[0]]; ok {
+			if entry, v := child.lookup(segments[1:], method, values); entry != nil {
+				return entry, v
+			}
+		}
+		if n.param != nil && len(values) < actionMatcherMaxParams {
+			if entry, v := n.param.lookup(segments[1:], method, append(values, segments[0])); entry != nil {
+				return entry, v
+			}
+		}
+	}
+	if n.catchAll != nil {
+		if entry := n.catchAll.route(method); entry != nil {
+			return entry, values
+		}
+	}
+	return nil, values
+}
+
+func (n *actionNode) route(method string) *actionEntry {
+	if route, ok := n.routes[method]; ok {
+		return route
+	}
+	return n.routes[ActionWildcard]
+}
+
+func normalizeActionWildcard(val string) string {
+	if len(val) == 0 {
+		return ActionWildcard
+	}
+	return val
+}
+
+func splitActionPath(path string) []string {
+	parts := strings.Split(path, actionPathSeparator)
+	segments := parts[:0]
+	for _, p := range parts {
+		if len(p) > 0 {
+			segments = append(segments, p)
+		}
+	}
+	return segments
+}
@@ -2,31 +2,32 @@ package auth
 
 import (
 	"crypto/aes"
-	"errors"
-)
-
-var (
-	aesUtil *AesUtil
 )
 
+// NewAesUtil 每次调用创建新的AesUtil，key为空时返回nil，key长度错误时panic
 func NewAesUtil(key string) *AesUtil {
-	if len(key) == 0 {
-		return nil
+	util, err := NewAesUtilE(key)
+	if err != nil {
+		panic(err)
 	}
-	if aesUtil != nil {
-		return aesUtil
+	return util
+}
+
+// NewAesUtilE key长度错误时返回ErrAESKeyFail
+func NewAesUtilE(key string) (*AesUtil, error) {
+	if len(key) == 0 {
+		return nil, nil
 	}
 	if len(key) != 16 {
-		panic(errors.New("AES加密key长度必须是16位"))
+		return nil, ErrAESKeyFail
 	}
 	block, err := aes.NewCipher([]byte(key))
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	aesUtil = &AesUtil{
+	return &AesUtil{
 		block:        block,
 		encryptBlock: newECBEncrypt(block),
 		decryptBlock: newECBDecrypt(block),
-	}
-	return aesUtil
+	}, nil
 }
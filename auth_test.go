@@ -1,7 +1,30 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAES(t *testing.T) {
@@ -26,6 +49,15 @@ func TestAES(t *testing.T) {
 		t.Fatal()
 	}
 
+	// 不再缓存AesUtil，不同实例使用相同的key仍可互相解密
+	other := NewAesUtil(key)
+	if other == util {
+		t.Fatal("AesUtil should not be cached by key")
+	}
+	if decrypt, err = other.decrypt(encrypt); err != nil || decrypt != content {
+		t.Fatal(decrypt, err)
+	}
+
 	_, err = util.decrypt("ok")
 	if err != nil {
 		t.Log(err)
@@ -36,3 +68,1873 @@ func TestAES(t *testing.T) {
 		t.Log(err)
 	}
 }
+
+func TestHttpClientCtxDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":null}}`))
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(server.URL, "test", "")
+	header := func(key string) string {
+		if key == DefaultHeaderUserToken {
+			return DefaultHeaderSchema + " token"
+		}
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := client.CheckAuthCtx(ctx, header, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+
+	client = NewHttpClient(server.URL, "test", "", WithRequestTimeout(20*time.Millisecond))
+	_, err = client.CheckAuth(header, false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = client.CheckAuthCtx(ctx, header, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+}
+
+func TestHttpClientDecisionCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.FormValue("code") == "denied" {
+			_, _ = w.Write([]byte(`{"code":1,"message":"` + MsgPermFail + `","success":false}`))
+			return
+		}
+		if r.FormValue("code") == "unavailable" {
+			_, _ = w.Write([]byte(`{"code":503,"message":"` + MsgAuthServiceUnavailable + `","success":false}`))
+			return
+		}
+		exp := time.Now().Add(time.Hour).Unix()
+		_, _ = fmt.Fprintf(w, `{"code":0,"success":true,"result":{"user":{"id":"1","exp":%d}}}`, exp)
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(server.URL, "test", "", WithDecisionCacheConfig(DecisionCache{Enable: true}))
+	header := func(key string) string {
+		if key == DefaultHeaderUserToken {
+			return DefaultHeaderSchema + " token"
+		}
+		return ""
+	}
+
+	for i := 0; i < 3; i++ {
+		res, err := client.CheckPermByCode(header, "allowed", true, false, false)
+		if err != nil || res.User == nil || res.User.Token != "token" {
+			t.Fatal(res, err)
+		}
+		res.User.Id = "changed"
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := client.CheckPermByCode(header, "denied", true, false, false); err == nil {
+			t.Fatal("denied permission passed")
+		}
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Fatal(calls)
+	}
+	stats := client.DecisionCacheStats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Size != 2 {
+		t.Fatal(stats)
+	}
+
+	client.InvalidateUser("token")
+	res, err := client.CheckPermByCode(header, "allowed", true, false, false)
+	if err != nil || res.User.Id != "1" || atomic.LoadInt32(&calls) != 3 {
+		t.Fatal(res, err, calls)
+	}
+
+	// 服务故障不进入负缓存
+	for i := 0; i < 2; i++ {
+		if _, err = client.CheckPermByCode(header, "unavailable", true, false, false); !errors.Is(err, ErrAuthServiceUnavailable) {
+			t.Fatal(err)
+		}
+	}
+	if atomic.LoadInt32(&calls) != 5 {
+		t.Fatal(calls)
+	}
+
+	// 启用访问码或随机码时每次都由鉴权服务校验
+	header = func(key string) string {
+		switch key {
+		case DefaultHeaderUserToken:
+			return DefaultHeaderSchema + " token"
+		case DefaultHeaderAccessCode, DefaultHeaderRandomKey:
+			return "code"
+		}
+		return ""
+	}
+	for _, option := range []ClientOption{
+		WithAccessCodeConfig(AccessCode{Enable: true}),
+		WithRandomKeyConfig(RandomKey{Enable: true}),
+	} {
+		client = NewHttpClient(server.URL, "test", "", WithDecisionCacheConfig(DecisionCache{Enable: true}), option)
+		atomic.StoreInt32(&calls, 0)
+		for i := 0; i < 2; i++ {
+			if _, err = client.CheckPermByCode(header, "allowed", true, false, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if atomic.LoadInt32(&calls) != 2 {
+			t.Fatal(calls)
+		}
+	}
+}
+
+func TestHttpClientRetryAndCircuitBreaker(t *testing.T) {
+	var calls, failures int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"clientAuthOk":true}}`))
+	}))
+	defer server.Close()
+
+	var transitions []string
+	var client *HttpClient
+	client = NewHttpClient(server.URL, "test", "",
+		WithClientConfig(Client{Id: "id", Secret: "secret", EnableIdAndSecret: true}),
+		WithRetryConfig(Retry{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}),
+		WithCircuitBreakerConfig(CircuitBreaker{
+			Enable:           true,
+			FailureThreshold: 1,
+			OpenTimeout:      50 * time.Millisecond,
+			OnStateChange: func(from, to CircuitState) {
+				// 回调在锁外执行，读取熔断状态不会死锁
+				if state := client.CircuitState(); state != to {
+					t.Error("state in callback", state, to)
+				}
+				transitions = append(transitions, from.String()+"->"+to.String())
+			},
+		}),
+	)
+
+	atomic.StoreInt32(&failures, 2)
+	res, err := client.CheckClientAuth(nil)
+	if err != nil || !res.ClientAuthOk || atomic.LoadInt32(&calls) != 3 {
+		t.Fatal(res, err, calls)
+	}
+
+	atomic.StoreInt32(&failures, 3)
+	if _, err = client.CheckClientAuth(nil); !errors.Is(err, ErrAuthServerFail) {
+		t.Fatal(err)
+	}
+	if client.CircuitState() != CircuitOpen {
+		t.Fatal(client.CircuitState())
+	}
+	if _, err = client.CheckClientAuth(nil); !errors.Is(err, ErrAuthServiceUnavailable) || atomic.LoadInt32(&calls) != 6 {
+		t.Fatal(err, calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if res, err = client.CheckClientAuth(nil); err != nil || !res.ClientAuthOk {
+		t.Fatal(err)
+	}
+	if strings.Join(transitions, ",") != "closed->open,open->half-open,half-open->closed" {
+		t.Fatal(transitions)
+	}
+
+	// 启用随机码时不重试，避免同一个随机码被发送两次
+	randomKeyClient := NewHttpClient(server.URL, "test", "",
+		WithClientConfig(Client{Id: "id", Secret: "secret", EnableIdAndSecret: true}),
+		WithRandomKeyConfig(RandomKey{Enable: true}),
+		WithRetryConfig(Retry{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}),
+	)
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 1)
+	if _, err = randomKeyClient.CheckClientAuth(nil); !errors.Is(err, ErrAuthServerFail) || atomic.LoadInt32(&calls) != 1 {
+		t.Fatal(err, calls)
+	}
+
+	// 过小的退避间隔按MinRetryBackoff处理，重试时不会panic
+	tinyBackoffClient := NewHttpClient(server.URL, "test", "",
+		WithClientConfig(Client{Id: "id", Secret: "secret", EnableIdAndSecret: true}),
+		WithRetryConfig(Retry{MaxRetries: 2, MinBackoff: time.Nanosecond, MaxBackoff: time.Nanosecond}),
+	)
+	atomic.StoreInt32(&failures, 1)
+	if res, err = tinyBackoffClient.CheckClientAuth(nil); err != nil || !res.ClientAuthOk {
+		t.Fatal(res, err)
+	}
+
+	config := defaultHttpClientConfig(server.URL, "test", "")
+	config.Retry.MinBackoff = time.Nanosecond
+	if err = config.Validate(); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "retry.minBackoff") {
+		t.Fatal(err)
+	}
+}
+
+func TestHttpClientCheckPermsByCodes(t *testing.T) {
+	var batchCalls, singleCalls int32
+	batchSupported := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case UrlPostCheckPermsByCodes:
+			atomic.AddInt32(&batchCalls, 1)
+			if !batchSupported {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"1"},"perms":{"a":true,"b":false}}}`))
+		case UrlPostCheckAuth:
+			_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"1"}}}`))
+		case UrlPostCheckPermByCode:
+			atomic.AddInt32(&singleCalls, 1)
+			switch r.FormValue("code") {
+			case "b":
+				_, _ = w.Write([]byte(`{"code":1,"message":"` + MsgPermFail + `","success":false}`))
+				return
+			case "limited":
+				_, _ = w.Write([]byte(`{"code":429,"message":"` + MsgRateLimit + `","success":false}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{}}`))
+		}
+	}))
+	defer server.Close()
+
+	header := func(key string) string {
+		if key == DefaultHeaderUserToken {
+			return DefaultHeaderSchema + " token"
+		}
+		return ""
+	}
+	for _, supported := range []bool{true, false} {
+		batchSupported = supported
+		client := NewHttpClient(server.URL, "test", "", WithBatchConfig(Batch{Concurrency: 2}))
+		for i := 0; i < 2; i++ {
+			res, err := client.CheckPermsByCodes(header, []string{"a", "b", "a"}, true, false)
+			if err != nil || res.User == nil || res.User.Token != "token" || len(res.Perms) != 2 || !res.Perms["a"] || res.Perms["b"] {
+				t.Fatal(res, err)
+			}
+		}
+	}
+	if atomic.LoadInt32(&batchCalls) != 3 || atomic.LoadInt32(&singleCalls) != 4 {
+		t.Fatal(batchCalls, singleCalls)
+	}
+
+	// 经过ProbeInterval后重新尝试批量接口
+	client := NewHttpClient(server.URL, "test", "", WithBatchConfig(Batch{ProbeInterval: time.Hour}))
+	if _, err := client.CheckPermsByCodes(header, []string{"a", "b"}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	batchSupported = true
+	if _, err := client.CheckPermsByCodes(header, []string{"a", "b"}, false, false); err != nil || atomic.LoadInt32(&batchCalls) != 4 {
+		t.Fatal(batchCalls, err)
+	}
+	atomic.StoreInt64(&client.batchRouteMissingUntil, time.Now().UnixNano())
+	if _, err := client.CheckPermsByCodes(header, []string{"a", "b"}, false, false); err != nil || atomic.LoadInt32(&batchCalls) != 5 {
+		t.Fatal(batchCalls, err)
+	}
+
+	// 逐个检查时只有权限不足记为false，其他错误直接返回
+	batchSupported = false
+	client = NewHttpClient(server.URL, "test", "")
+	if _, err := client.CheckPermsByCodes(header, []string{"a", "limited"}, false, false); !errors.Is(err, ErrRateLimit) {
+		t.Fatal(err)
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("code") {
+		case "expired":
+			_, _ = w.Write([]byte(`{"code":401,"message":"令牌已过期","success":false}`))
+		case "unknown":
+			_, _ = w.Write([]byte(`{"code":9,"message":"` + MsgPermFail + `","success":false}`))
+		case "internal":
+			_, _ = w.Write([]byte(`{"code":500,"message":"` + MsgInternalError + `","success":false}`))
+		case "unavailable":
+			_, _ = w.Write([]byte(`{"code":503,"message":"` + MsgPermFail + `","success":false}`))
+		case "unknown internal":
+			_, _ = w.Write([]byte(`{"code":9,"message":"` + MsgAuthServiceUnavailable + `","success":false}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(server.URL, "test", "")
+	header := func(key string) string {
+		switch key {
+		case DefaultHeaderUserToken:
+			return DefaultHeaderSchema + " token"
+		case TraceId:
+			return "trace"
+		}
+		return ""
+	}
+
+	var authErr *AuthError
+	_, err := client.CheckPermByCode(header, "expired", false, false, false)
+	if !errors.Is(err, ErrAuthFail) || !errors.As(err, &authErr) || authErr.Code != CodeAuthFail || authErr.TraceId != "trace" || !IsDeniedError(err) {
+		t.Fatal(err)
+	}
+	_, err = client.CheckPermByCode(header, "unknown", false, false, false)
+	if !errors.Is(err, ErrPermFail) || !IsDeniedError(err) {
+		t.Fatal(err)
+	}
+	_, err = client.CheckPermByCode(header, "", false, false, false)
+	if !errors.Is(err, ErrAuthServerFail) || !errors.As(err, &authErr) || authErr.HttpStatus != http.StatusBadGateway || IsDeniedError(err) {
+		t.Fatal(err)
+	}
+
+	// 服务故障不是明确拒绝，不能被负缓存或当作权限不足
+	for code, want := range map[string]error{"internal": ErrInternalError, "unavailable": ErrAuthServiceUnavailable, "unknown internal": ErrAuthServiceUnavailable} {
+		_, err = client.CheckPermByCode(header, code, false, false, false)
+		if !errors.Is(err, want) || IsDeniedError(err) {
+			t.Fatal(code, err)
+		}
+	}
+}
+
+func TestClientDo(t *testing.T) {
+	type order struct {
+		Id   string `json:"id"`
+		Name string `json:"name"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(DefaultHeaderClientToken) == "" || r.Header.Get("X-Tenant") != "t1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/orders/42":
+			var body order
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"code":0,"success":true,"result":{"id":"42","name":%q}}`, body.Name)
+		case r.Method == http.MethodGet && r.URL.Path == "/orders":
+			page, _ := strconv.Atoi(r.URL.Query().Get(QueryPage))
+			_, _ = fmt.Fprintf(w, `{"code":0,"success":true,"result":{"items":[{"id":"%d"}],"total":3,"page":%d,"pageSize":1}}`, page, page)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHttpClient(server.URL, "test", "", WithClientConfig(Client{Id: "id", Secret: "secret", EnableIdAndSecret: true}))
+	headers := map[string]string{"X-Tenant": "t1"}
+
+	created, err := ClientDo[order](context.Background(), client, RequestSpec{
+		Method:     http.MethodPost,
+		Path:       "/orders/{id}",
+		PathParams: map[string]string{"id": "42"},
+		Headers:    headers,
+		JSON:       order{Name: "book"},
+	})
+	if err != nil || created.Id != "42" || created.Name != "book" {
+		t.Fatal(created, err)
+	}
+
+	orders, err := ClientDoAllPages[order](context.Background(), client, RequestSpec{Path: "/orders", Headers: headers}, 1)
+	if err != nil || len(orders) != 3 || orders[2].Id != "3" {
+		t.Fatal(orders, err)
+	}
+
+	_, err = ClientDo[order](context.Background(), client, RequestSpec{Path: "/orders", JSON: order{}, Form: map[string]any{"a": 1}})
+	if !errors.Is(err, ErrRequestBodyConflict) {
+		t.Fatal(err)
+	}
+}
+
+func TestHttpClientJwtPreValidation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	var checks, downloads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case UrlGetJwtPublicKey:
+			atomic.AddInt32(&downloads, 1)
+			_ = json.NewEncoder(w).Encode(HttpResponse[string]{Code: CodeSuccess, Success: true, Result: &publicKey})
+		case UrlPostCheckAuth:
+			atomic.AddInt32(&checks, 1)
+			_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"1"}}}`))
+		}
+	}))
+	defer server.Close()
+
+	now := float64(time.Now().Unix())
+	signer := &RedisJwtUtil{PrivateKey: key}
+	valid, err := signer.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := signer.GenerateJwt("1", "user", "user", "d1", now-120, now-60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged, err := (&RedisJwtUtil{PrivateKey: otherKey}).GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []JwtPreValidation{{Enable: true, PublicKey: publicKey}, {Enable: true}} {
+		atomic.StoreInt32(&checks, 0)
+		client := NewHttpClient(server.URL, "test", "", WithJwtPreValidationConfig(config))
+		for _, token := range []string{"malformed", expired.Token, forged.Token} {
+			header := func(string) string { return DefaultHeaderSchema + " " + token }
+			if _, err = client.CheckAuth(header, false); !errors.Is(err, ErrJwtErrFormat) {
+				t.Fatal(err)
+			}
+		}
+		if atomic.LoadInt32(&checks) != 0 {
+			t.Fatal("invalid tokens should not reach auth service")
+		}
+		result, err := client.CheckAuth(func(string) string { return DefaultHeaderSchema + " " + valid.Token }, false)
+		if err != nil || result.User.Token != valid.Token || atomic.LoadInt32(&checks) != 1 {
+			t.Fatal(result, err)
+		}
+	}
+	if atomic.LoadInt32(&downloads) != 1 {
+		t.Fatal("public key should be downloaded once", downloads)
+	}
+
+	// 并发的首次请求共用一次下载
+	atomic.StoreInt32(&downloads, 0)
+	client := NewHttpClient(server.URL, "test", "", WithJwtPreValidationConfig(JwtPreValidation{Enable: true}))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.CheckAuth(func(string) string { return DefaultHeaderSchema + " " + valid.Token }, false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&downloads) != 1 {
+		t.Fatal("concurrent requests should share one download", downloads)
+	}
+
+	// 秘钥轮换后，新秘钥签发的令牌触发重新下载，不会被本地拒绝
+	rotated, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rotatedDer, _ := x509.MarshalPKIXPublicKey(&rotated.PublicKey)
+	var keyring atomic.Pointer[JwtKeyring]
+	first, _ := NewJwtKeyringWithEntries(&JwtKeyringEntry{Kid: "1", PublicKey: &key.PublicKey, PrivateKey: key})
+	keyring.Store(first)
+	rotatedServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case UrlGetJwtPublicKey:
+			atomic.AddInt32(&downloads, 1)
+			current := publicKey
+			if keyring.Load() != first {
+				current = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rotatedDer}))
+			}
+			_ = json.NewEncoder(w).Encode(HttpResponse[string]{Code: CodeSuccess, Success: true, Result: &current})
+		case "/jwks":
+			atomic.AddInt32(&downloads, 1)
+			NewJWKSHandler(&RedisJwtUtil{Keyring: keyring.Load()}).ServeHTTP(w, r)
+		case UrlPostCheckAuth:
+			atomic.AddInt32(&checks, 1)
+			_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"1"}}}`))
+		}
+	}))
+	defer rotatedServer.Close()
+	for _, config := range []JwtPreValidation{{Enable: true}, {Enable: true, JwksUrl: "/jwks"}} {
+		keyring.Store(first)
+		client = NewHttpClient(rotatedServer.URL, "test", "", WithJwtPreValidationConfig(config))
+		if config.JwksUrl == "" {
+			client.jwtPreValidator.refreshInterval = 0
+		} else {
+			client.jwtPreValidator.jwks.minRefreshInterval = 0
+		}
+		oldToken, _ := (&RedisJwtUtil{Keyring: first}).GenerateJwt("1", "user", "user", "d1", now, now+60)
+		if _, err = client.CheckAuth(func(string) string { return DefaultHeaderSchema + " " + oldToken.Token }, false); err != nil {
+			t.Fatal(config, err)
+		}
+		second, _ := NewJwtKeyringWithEntries(
+			&JwtKeyringEntry{Kid: "1", PublicKey: &key.PublicKey, PrivateKey: key, NotAfter: time.Now().Add(-time.Second)},
+			&JwtKeyringEntry{Kid: "2", PublicKey: &rotated.PublicKey, PrivateKey: rotated},
+		)
+		keyring.Store(second)
+		newToken, _ := (&RedisJwtUtil{Keyring: second}).GenerateJwt("1", "user", "user", "d1", now, now+60)
+		atomic.StoreInt32(&checks, 0)
+		if _, err = client.CheckAuth(func(string) string { return DefaultHeaderSchema + " " + newToken.Token }, false); err != nil || atomic.LoadInt32(&checks) != 1 {
+			t.Fatal(config, err)
+		}
+		if _, err = client.CheckAuth(func(string) string { return DefaultHeaderSchema + " " + forged.Token }, false); !errors.Is(err, ErrJwtErrFormat) {
+			t.Fatal(config, err)
+		}
+	}
+}
+
+type fakeSessions struct {
+	*JwtVerifier
+	sessions map[string]bool
+}
+
+func (s *fakeSessions) IsJwtInCache(_ context.Context, jwtUser *JwtUser) (bool, error) {
+	return s.sessions[jwtUser.Id], nil
+}
+
+type fakePerms map[string][]string
+
+func (p fakePerms) HasPermByCode(_ context.Context, user *JwtUser, code string) (bool, error) {
+	for _, c := range p[user.Id] {
+		if c == code {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p fakePerms) HasPermByAction(ctx context.Context, user *JwtUser, service string, method string, path string) (bool, error) {
+	return p.HasPermByCode(ctx, user, Action{Service: service, Method: method, Path: path}.Key())
+}
+
+func TestLocalAuthChecker(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := float64(time.Now().Unix())
+	signer := &RedisJwtUtil{PrivateKey: key}
+	alice, _ := signer.GenerateJwt("alice", "alice", "user", "d1", now, now+60)
+	bob, _ := signer.GenerateJwt("bob", "bob", "user", "d1", now, now+60)
+
+	ctx := context.Background()
+	checker := NewLocalAuthChecker("",
+		WithLocalAccessCodeConfig(LocalAccessCode{Enable: true}),
+		WithJwtSessionValidator(&fakeSessions{JwtVerifier: &JwtVerifier{PublicKey: &key.PublicKey}, sessions: map[string]bool{"alice": true}}),
+		WithPermProvider(fakePerms{"alice": {"order:read", Action{Service: "shop", Method: "GET", Path: "/orders"}.Key()}}),
+		WithAccessCodeProvider(AccessCodeProviderFunc(func(_ context.Context, code string) (bool, error) { return code == "open", nil })),
+		WithClientProvider(ClientProviderFunc(func(_ context.Context, id string, secret string) (bool, error) {
+			return id == "svc" && secret == "s3cret", nil
+		})),
+		WithClientPermProvider(ClientPermProviderFunc(func(_ context.Context, id string, code string) (bool, error) { return code == "order:read", nil })),
+	)
+
+	if ok, err := checker.IsAccessCodeOk(ctx, "open"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if _, err = checker.IsAccessCodeOk(ctx, ""); !errors.Is(err, ErrAccessCodeEmpty) {
+		t.Fatal(err)
+	}
+	if ok, err := checker.IsRandomKeyOk(ctx, ""); !ok || err != nil {
+		t.Fatal("random key check is disabled", ok, err)
+	}
+
+	authResult, err := checker.CheckAuth(ctx, alice.Token, false)
+	if err != nil || authResult.User.Id != "alice" {
+		t.Fatal(authResult, err)
+	}
+	if _, err = checker.CheckAuth(ctx, bob.Token, false); !errors.Is(err, ErrAuthFail) || !IsDeniedError(err) {
+		t.Fatal("bob has no session", err)
+	}
+	if _, err = checker.CheckAuth(ctx, "malformed", false); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+
+	permResult, err := checker.CheckPermByCode(ctx, alice.Token, "order:read", true, false, false)
+	if err != nil || permResult.User.Id != "alice" {
+		t.Fatal(permResult, err)
+	}
+	if _, err = checker.CheckPermByCode(ctx, alice.Token, "order:write", true, false, false); !errors.Is(err, ErrPermFail) {
+		t.Fatal(err)
+	}
+	if _, err = checker.CheckPermByAction(ctx, alice.Token, "shop", "GET", "/orders", false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = checker.CheckPermByAction(ctx, alice.Token, "shop", "DELETE", "/orders", false, false, false); !errors.Is(err, ErrPermFail) {
+		t.Fatal(err)
+	}
+
+	clientResult, err := checker.CheckClientAuth(ctx, "svc", "wrong")
+	if err != nil || clientResult.ClientAuthOk {
+		t.Fatal(clientResult, err)
+	}
+	clientPermResult, err := checker.CheckClientPermByCode(ctx, "svc", "s3cret", "order:read")
+	if err != nil || !clientPermResult.ClientPermOk {
+		t.Fatal(clientPermResult, err)
+	}
+	if _, err = NewLocalAuthChecker("").CheckAuth(ctx, alice.Token, false); !errors.Is(err, ErrProviderNotConfigured) {
+		t.Fatal(err)
+	}
+}
+
+func TestActionMatcher(t *testing.T) {
+	matcher, err := NewActionMatcher([]ActionRoute{
+		{Service: "shop", Method: "GET", Path: "/orders/:id", Codes: []string{"order:read"}},
+		{Service: "shop", Method: "GET", Path: "/orders/export", Codes: []string{"order:export"}},
+		{Service: "shop", Method: "*", Path: "/orders/:orderId", Codes: []string{"order:write"}},
+		{Service: "shop", Path: "/admin/**", Codes: []string{"admin"}},
+		{Service: "shop", Method: "GET", Path: "/files/*/raw", Codes: []string{"file:read"}},
+		{Path: "/health"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		service, method, path string
+		code                  string
+		params                map[string]string
+	}{
+		{"shop", "GET", "/orders/42", "order:read", map[string]string{"id": "42"}},
+		{"shop", "get", "/orders/export/", "order:export", nil},
+		{"shop", "DELETE", "/orders/42", "order:write", map[string]string{"orderId": "42"}},
+		{"shop", "POST", "/admin", "admin", nil},
+		{"shop", "POST", "/admin/users/1", "admin", nil},
+		{"shop", "GET", "/files/a.txt/raw", "file:read", nil},
+		{"other", "GET", "/health", "", nil},
+	} {
+		match, ok := matcher.Match(c.service, c.method, c.path)
+		if !ok {
+			t.Fatal("no match", c)
+		}
+		if code := strings.Join(match.Route.Codes, ","); code != c.code || fmt.Sprint(match.Params) != fmt.Sprint(c.params) {
+			t.Fatal(c, code, match.Params)
+		}
+	}
+	if _, ok := matcher.Match("other", "GET", "/orders/42"); ok {
+		t.Fatal("route of shop should not match other service")
+	}
+	if _, ok := matcher.Match("shop", "GET", "/files/a.txt"); ok {
+		t.Fatal("* should match exactly one segment")
+	}
+
+	for _, routes := range [][]ActionRoute{
+		{{Path: "orders"}},
+		{{Path: "/admin/**/users"}},
+		{{Method: "GET", Path: "/orders/:id"}, {Method: "get", Path: "/orders/:orderId"}},
+	} {
+		if _, err = NewActionMatcher(routes); !errors.Is(err, ErrInvalidActionRoute) {
+			t.Fatal(routes, err)
+		}
+	}
+}
+
+func TestRandomKeyValidator(t *testing.T) {
+	ctx := context.Background()
+	key := GenerateRandomKey()
+	if len(key) != RandomKeyBytes*2 || key == GenerateRandomKey() {
+		t.Fatal(key)
+	}
+
+	validator := NewRandomKeyValidator(NewMemoryRandomKeyStore(), time.Minute, false)
+	if ok, err := validator.IsRandomKeyOk(ctx, key); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, _ := validator.IsRandomKeyOk(ctx, key); ok {
+		t.Fatal("random key should not be reused")
+	}
+	if _, err := validator.IsRandomKeyOk(ctx, ""); !errors.Is(err, ErrRandomKeyEmpty) {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	validator = NewRandomKeyValidator(NewMemoryRandomKeyStore(), time.Minute, true)
+	validator.now = func() time.Time { return now }
+	for _, c := range []struct {
+		key  string
+		want bool
+	}{
+		{GenerateTimestampedRandomKey(), true},
+		{strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10) + RandomKeyTimestampJoiner + key, false},
+		{strconv.FormatInt(now.Add(2*time.Minute).Unix(), 10) + RandomKeyTimestampJoiner + key, false},
+		{key, false},
+	} {
+		if ok, err := validator.IsRandomKeyOk(ctx, c.key); ok != c.want || err != nil {
+			t.Fatal(c.key, ok, err)
+		}
+	}
+
+	checker := NewLocalAuthChecker("", WithLocalRandomKeyConfig(LocalRandomKey{Enable: true}), WithRandomKeyStore(NewMemoryRandomKeyStore()))
+	if ok, _ := checker.IsRandomKeyOk(ctx, key); !ok {
+		t.Fatal("first use should pass")
+	}
+	if ok, _ := checker.IsRandomKeyOk(ctx, key); ok {
+		t.Fatal("replay should be rejected")
+	}
+}
+
+func TestAccessCodeStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryAccessCodeStore()
+	if _, err := store.IssueAccessCode(ctx, AccessCodeSpec{}); !errors.Is(err, ErrAccessCodeOwnerEmpty) {
+		t.Fatal(err)
+	}
+	once, _ := store.IssueAccessCode(ctx, AccessCodeSpec{ClientId: "web", MaxUses: 1})
+	twice, _ := store.IssueAccessCode(ctx, AccessCodeSpec{ClientId: "web", UserId: "alice", MaxUses: 2})
+	expired, _ := store.IssueAccessCode(ctx, AccessCodeSpec{UserId: "alice", TTL: time.Nanosecond})
+	revoked, _ := store.IssueAccessCode(ctx, AccessCodeSpec{UserId: "bob"})
+	time.Sleep(time.Millisecond)
+
+	if records, _ := store.ListAccessCodes(ctx, AccessCodeFilter{ClientId: "web"}); len(records) != 2 || records[0].Code != once.Code {
+		t.Fatal(records)
+	}
+	if records, _ := store.ListAccessCodes(ctx, AccessCodeFilter{UserId: "alice"}); len(records) != 1 || records[0].Code != twice.Code {
+		t.Fatal("expired code should not be listed", records)
+	}
+	if err := store.RevokeAccessCode(ctx, revoked.Code); err != nil {
+		t.Fatal(err)
+	}
+
+	validator := NewAccessCodeValidator(store)
+	for _, c := range []struct {
+		code string
+		want bool
+	}{
+		{once.Code, true},
+		{once.Code, false},
+		{twice.Code, true},
+		{twice.Code, true},
+		{twice.Code, false},
+		{expired.Code, false},
+		{revoked.Code, false},
+		{"unknown", false},
+	} {
+		if ok, err := validator.IsAccessCodeOk(ctx, c.code); ok != c.want || err != nil {
+			t.Fatal(c.code, ok, err)
+		}
+	}
+}
+
+func TestRedisAccessCodeStore(t *testing.T) {
+	mr := miniredis.RunT(t)
+	util := newTestRedisJwtUtil(t, mr)
+	ctx := context.Background()
+	clientIndex, userIndex := util.accessCodeIndexKeys(AccessCodeFilter{ClientId: "web"})[0], util.accessCodeIndexKeys(AccessCodeFilter{UserId: "alice"})[0]
+
+	// 访问码和索引一起写入，索引的有效期不短于其中的访问码
+	short, err := util.IssueAccessCode(ctx, AccessCodeSpec{ClientId: "web", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, _ := util.IssueAccessCode(ctx, AccessCodeSpec{ClientId: "web", UserId: "alice", TTL: time.Hour, MaxUses: 2})
+	if !mr.Exists(util.GetAccessCodeCacheKey(short.Code)) || mr.TTL(clientIndex) != time.Hour || mr.TTL(userIndex) != time.Hour {
+		t.Fatal(mr.TTL(clientIndex), mr.TTL(userIndex))
+	}
+	_, _ = util.IssueAccessCode(ctx, AccessCodeSpec{ClientId: "web", TTL: time.Second})
+	if mr.TTL(clientIndex) != time.Hour {
+		t.Fatal("index ttl shortened", mr.TTL(clientIndex))
+	}
+	forever, _ := util.IssueAccessCode(ctx, AccessCodeSpec{UserId: "alice", MaxUses: 1})
+	if mr.TTL(userIndex) != 0 {
+		t.Fatal("index of a code without ttl should not expire", mr.TTL(userIndex))
+	}
+
+	// 过期的访问码在查询时从索引中清理
+	mr.FastForward(2 * time.Minute)
+	records, err := util.ListAccessCodes(ctx, AccessCodeFilter{ClientId: "web"})
+	if err != nil || len(records) != 1 || records[0].Code != long.Code {
+		t.Fatal(records, err)
+	}
+	if members, _ := mr.Members(clientIndex); len(members) != 1 {
+		t.Fatal(members)
+	}
+	if _, err = util.ListAccessCodes(ctx, AccessCodeFilter{}); !errors.Is(err, ErrAccessCodeOwnerEmpty) {
+		t.Fatal(err)
+	}
+
+	// consumeAccessCodeScript累加次数并保留有效期，次数用尽时删除
+	for i, want := range []bool{true, true, false} {
+		record, err := util.ConsumeAccessCode(ctx, long.Code)
+		if err != nil || (record != nil) != want || (record != nil && record.Uses != i+1) {
+			t.Fatal(i, record, err)
+		}
+		if i == 0 && mr.TTL(util.GetAccessCodeCacheKey(long.Code)) != time.Hour-2*time.Minute {
+			t.Fatal(mr.TTL(util.GetAccessCodeCacheKey(long.Code)))
+		}
+	}
+	if record, err := util.ConsumeAccessCode(ctx, "unknown"); record != nil || err != nil {
+		t.Fatal(record, err)
+	}
+
+	// 撤销时同时从索引中移除
+	if err = util.RevokeAccessCode(ctx, forever.Code); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(util.GetAccessCodeCacheKey(forever.Code)) {
+		t.Fatal("revoked code still exists")
+	}
+	if members, _ := mr.Members(userIndex); len(members) != 1 || members[0] != long.Code {
+		t.Fatal(members)
+	}
+	if err = util.RevokeAccessCode(ctx, forever.Code); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHybridAuthChecker(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := float64(time.Now().Unix())
+	alice, _ := (&RedisJwtUtil{PrivateKey: key}).GenerateJwt("alice", "alice", "user", "d1", now, now+60)
+
+	var remoteCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&remoteCalls, 1)
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"alice"},"customAuth":"remote"}}`))
+	}))
+	defer server.Close()
+
+	local := NewLocalAuthChecker("",
+		WithJwtSessionValidator(&fakeSessions{JwtVerifier: &JwtVerifier{PublicKey: &key.PublicKey}, sessions: map[string]bool{"alice": true}}),
+		WithPermProvider(fakePerms{"alice": {"order:read"}}),
+	)
+	hybrid := NewHybridAuthChecker(local, NewHttpClient(server.URL, "shop", ""), WithHybridModes(HybridModes{CheckPermByAction: CheckModeRemoteOnly}))
+	header := func(token string) GetHeaderFun {
+		return func(key string) string {
+			if key == DefaultHeaderUserToken {
+				return DefaultHeaderSchema + " " + token
+			}
+			return ""
+		}
+	}
+
+	for _, c := range []struct {
+		name  string
+		check func() error
+		err   error
+		calls int32
+	}{
+		{"local auth", func() error { _, err := hybrid.CheckAuth(header(alice.Token), false); return err }, nil, 0},
+		{"custom auth needs remote", func() error {
+			result, err := hybrid.CheckAuth(header(alice.Token), true)
+			if err == nil && result.CustomAuth != "remote" {
+				return fmt.Errorf("unexpected custom auth %v", result.CustomAuth)
+			}
+			return err
+		}, nil, 1},
+		{"malformed token", func() error { _, err := hybrid.CheckAuth(header("malformed"), true); return err }, ErrJwtErrFormat, 0},
+		{"local perm", func() error {
+			_, err := hybrid.CheckPermByCode(header(alice.Token), "order:read", true, false, false)
+			return err
+		}, nil, 0},
+		{"local perm denied", func() error {
+			_, err := hybrid.CheckPermByCode(header(alice.Token), "order:write", true, false, false)
+			return err
+		}, ErrPermFail, 0},
+		{"remote only action", func() error {
+			_, err := hybrid.CheckPermByAction(header(alice.Token), "shop", "GET", "/orders", true, false, false)
+			return err
+		}, nil, 1},
+		{"token view", func() error {
+			_, err := hybrid.AuthCheck().CheckAuth(context.Background(), alice.Token, true)
+			return err
+		}, nil, 1},
+		{"client without local provider", func() error {
+			_, err := hybrid.AuthCheck().CheckClientAuth(context.Background(), "svc", "secret")
+			return err
+		}, nil, 1},
+	} {
+		atomic.StoreInt32(&remoteCalls, 0)
+		if err := c.check(); !errors.Is(err, c.err) {
+			t.Fatal(c.name, err)
+		}
+		if calls := atomic.LoadInt32(&remoteCalls); calls != c.calls {
+			t.Fatal(c.name, "remote calls", calls)
+		}
+	}
+}
+
+type errPerms struct{}
+
+func (errPerms) HasPermByCode(context.Context, *JwtUser, string) (bool, error) {
+	return false, errors.New("perm store down")
+}
+
+func (errPerms) HasPermByAction(context.Context, *JwtUser, string, string, string) (bool, error) {
+	return false, errors.New("perm store down")
+}
+
+func TestHybridAuthCheckerRandomKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := float64(time.Now().Unix())
+	alice, _ := (&RedisJwtUtil{PrivateKey: key}).GenerateJwt("alice", "alice", "user", "d1", now, now+60)
+
+	var remoteCalls int32
+	var remoteKey atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&remoteCalls, 1)
+		remoteKey.Store(r.Header.Get(DefaultHeaderRandomKey))
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"alice"},"customAuth":"remote"}}`))
+	}))
+	defer server.Close()
+
+	local := NewLocalAuthChecker("",
+		WithJwtSessionValidator(&fakeSessions{JwtVerifier: &JwtVerifier{PublicKey: &key.PublicKey}, sessions: map[string]bool{"alice": true}}),
+		WithPermProvider(errPerms{}),
+		WithLocalRandomKeyConfig(LocalRandomKey{Enable: true}),
+		WithRandomKeyStore(NewMemoryRandomKeyStore()),
+	)
+	hybrid := NewHybridAuthChecker(local, NewHttpClient(server.URL, "shop", "", WithRandomKeyConfig(RandomKey{Enable: true})))
+	randomKey := GenerateRandomKey()
+	header := func(key string) string {
+		switch key {
+		case DefaultHeaderUserToken:
+			return DefaultHeaderSchema + " " + alice.Token
+		case DefaultHeaderRandomKey:
+			return randomKey
+		}
+		return ""
+	}
+
+	// 本地没有CustomProvider，不检查随机码直接访问鉴权服务，随机码原样转发
+	if result, err := hybrid.CheckAuth(header, true); err != nil || result.CustomAuth != "remote" {
+		t.Fatal(result, err)
+	}
+	if calls := atomic.LoadInt32(&remoteCalls); calls != 1 || remoteKey.Load() != randomKey {
+		t.Fatal(calls, remoteKey.Load())
+	}
+	// 随机码未被本地消耗，本地检查仍然通过
+	if _, err = hybrid.CheckAuth(header, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hybrid.CheckAuth(header, false); !errors.Is(err, ErrRandomKeyFail) {
+		t.Fatal(err)
+	}
+	// 本地消耗随机码后数据源出错，不再以同一随机码访问鉴权服务
+	randomKey = GenerateRandomKey()
+	if _, err = hybrid.CheckPermByCode(header, "order:read", true, false, false); err == nil || isLocalRejection(err) {
+		t.Fatal(err)
+	}
+	if calls := atomic.LoadInt32(&remoteCalls); calls != 1 {
+		t.Fatal(calls)
+	}
+}
+
+func TestPublicRoutes(t *testing.T) {
+	routes, err := ParsePublicRoutes([]byte(`
+- methods: [GET]
+  path: /health
+- path: /docs/**
+- path: /metrics
+  cidrs: [10.0.0.0/8]
+- methods: [POST]
+  path: /hooks/:name
+  headers: {X-Hook-Signature: "*"}
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParsePublicRoutes([]byte(`[{"path":"/metrics","cidrs":["bad"]}]`), "json"); !errors.Is(err, ErrInvalidActionRoute) {
+		t.Fatal(err)
+	}
+
+	var remoteCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&remoteCalls, 1)
+		_, _ = w.Write([]byte(`{"code":401,"message":"身份验证失败","success":false}`))
+	}))
+	defer server.Close()
+	client := NewHttpClient(server.URL, "shop", "", WithPublicConfig(Public{Routes: routes}))
+	local := NewLocalAuthChecker("", WithLocalPublicConfig(LocalPublic{Routes: routes}), WithLocalAccessCodeConfig(LocalAccessCode{Enable: true}))
+
+	request := func(method string, path string, remoteAddr string, headers map[string]string) GetHeaderFun {
+		return func(key string) string {
+			switch key {
+			case PseudoHeaderMethod:
+				return method
+			case PseudoHeaderPath:
+				return path
+			case PseudoHeaderRemoteAddr:
+				return remoteAddr
+			default:
+				return headers[key]
+			}
+		}
+	}
+	for _, c := range []struct {
+		name   string
+		f      GetHeaderFun
+		public bool
+	}{
+		{"health", request("GET", "/health", "1.2.3.4:80", nil), true},
+		{"health wrong method", request("POST", "/health", "1.2.3.4:80", nil), false},
+		{"docs", request("DELETE", "/docs/a/b", "", nil), true},
+		{"metrics inside cidr", request("GET", "/metrics", "10.1.2.3:5000", nil), true},
+		{"metrics outside cidr", request("GET", "/metrics", "192.168.0.1:5000", nil), false},
+		{"hook signed", request("POST", "/hooks/github", "", map[string]string{"X-Hook-Signature": "sig"}), true},
+		{"hook unsigned", request("POST", "/hooks/github", "", nil), false},
+		{"no pseudo headers", func(string) string { return "" }, false},
+		{"dot dot segment", request("GET", "/docs/../admin/users", "", nil), false},
+		{"dot dot at end", request("GET", "/docs/a/..", "", nil), false},
+		{"double slash", request("GET", "//docs/a", "", nil), false},
+		{"inner double slash", request("GET", "/docs//a", "", nil), false},
+		{"relative path", request("GET", "docs/a", "", nil), false},
+		{"dot segment", request("GET", "/docs/./a", "", nil), true},
+	} {
+		atomic.StoreInt32(&remoteCalls, 0)
+		authResult, err := client.CheckAuth(c.f, false)
+		if c.public != (err == nil && authResult.SkippedAuthCheck) {
+			t.Fatal(c.name, "http client", err)
+		}
+		if calls := atomic.LoadInt32(&remoteCalls); c.public && calls != 0 {
+			t.Fatal(c.name, "remote calls", calls)
+		}
+		permResult, err := local.CheckPermByCodeByHeader(context.Background(), c.f, "order:read", true, false, false)
+		if c.public != (err == nil && permResult.SkippedAuthCheck) {
+			t.Fatal(c.name, "local checker", err)
+		}
+		if !c.public && !errors.Is(err, ErrAccessCodeEmpty) {
+			t.Fatal(c.name, "local checker", err)
+		}
+	}
+
+	result, err := client.CheckPermByAction(request("POST", "/orders", "", nil), "shop", "GET", "/health", true, false, false)
+	if err != nil || !result.SkippedAuthCheck {
+		t.Fatal("action", err)
+	}
+	batch, err := client.CheckPermsByCodes(request("GET", "/health", "", nil), []string{"a", "b"}, true, false)
+	if err != nil || !batch.SkippedAuthCheck || len(batch.Perms) != 0 || len(batch.Skipped) != 2 {
+		t.Fatal("batch", batch, err)
+	}
+	// 批量检查操作时按每个操作的method和path匹配公开路由，而不是请求本身
+	atomic.StoreInt32(&remoteCalls, 0)
+	health, docs := Action{Service: "shop", Method: "GET", Path: "/health"}, Action{Service: "shop", Method: "GET", Path: "/docs/a"}
+	batch, err = client.CheckPermsByActions(request("POST", "/orders", "", nil), []Action{health, docs}, true, false)
+	if err != nil || !batch.SkippedAuthCheck || len(batch.Perms) != 0 || len(batch.Skipped) != 2 || atomic.LoadInt32(&remoteCalls) != 0 {
+		t.Fatal("batch actions", batch, err)
+	}
+	orders := Action{Service: "shop", Method: "DELETE", Path: "/orders/1"}
+	if _, err = client.CheckPermsByActions(request("GET", "/health", "", nil), []Action{health, orders}, true, false); !errors.Is(err, ErrAuthFail) {
+		t.Fatal("batch actions", err)
+	}
+}
+
+func TestConfigReloader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token-2") == "" {
+			_, _ = w.Write([]byte(`{"code":401,"message":"身份验证失败","success":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"alice"}}}`))
+	}))
+	defer server.Close()
+	path := t.TempDir() + "/auth.yaml"
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+service: {authServiceBaseUrl: "http://127.0.0.1:1", currentServiceName: shop, encryptKey: "0123456789abcdef", timeout: 3s}
+user: {header: X-Token}
+`)
+	reloaded := make(chan error, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloader, err := NewReloader(ctx, &FileConfigSource{Path: path}, HttpClientBuilder(), WithReloadCallback(func(err error) { reloaded <- err }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-reloaded
+	first := reloader.Current()
+	if first.Config.User.Header != "X-Token" || first.Config.User.HeaderSchema != DefaultHeaderSchema || !first.Config.EnableTraceLog || first.Config.Timeout != 3*time.Second {
+		t.Fatalf("unexpected config %+v", first.Config)
+	}
+	go func() { _ = reloader.Watch(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+
+	write(`
+service: {authServiceBaseUrl: "` + server.URL + `", currentServiceName: shop, encryptKey: "fedcba9876543210"}
+user: {header: X-Token-2}
+`)
+	if err = waitReload(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	second := reloader.Current()
+	if second.Config.User.Header != "X-Token-2" || second.AesUtil == first.AesUtil {
+		t.Fatal("config not swapped")
+	}
+
+	write(`service: {encryptKey: "short"}`)
+	if err = waitReload(reloaded); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal(err)
+	}
+	if reloader.Current() != second {
+		t.Fatal("invalid config should keep current client")
+	}
+	result, err := (ReloadingHttpClient{reloader}).CheckAuth(func(key string) string {
+		if key == "X-Token-2" {
+			return DefaultHeaderSchema + " token"
+		}
+		return ""
+	}, false)
+	if err != nil || result.User.Id != "alice" {
+		t.Fatal(err)
+	}
+
+	config := JwtUtilConfig{}
+	if err = DecodeConfig([]byte("jwt:\n  publicKey: |\n    -----BEGIN PUBLIC KEY-----\n"), ConfigFormatYaml, &config); err != nil || !strings.HasPrefix(string(config.PublicKey), "-----BEGIN") {
+		t.Fatal(config, err)
+	}
+}
+
+func TestHttpClientBuilderKeepsState(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	path := t.TempDir() + "/auth.yaml"
+	write := func(header string) {
+		content := `
+service: {authServiceBaseUrl: "` + server.URL + `", currentServiceName: shop}
+user: {header: ` + header + `}
+circuitBreaker: {enable: true, failureThreshold: 1, openTimeout: 1m}
+decisionCache: {enable: true}
+`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("X-Token")
+	ctx := context.Background()
+	reloader, err := NewReloader(ctx, &FileConfigSource{Path: path}, HttpClientBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := reloader.Current()
+	header := func(string) string { return DefaultHeaderSchema + " token" }
+	if _, err = first.CheckAuth(header, false); err == nil || first.CircuitState() != CircuitOpen {
+		t.Fatal(err, first.CircuitState())
+	}
+	atomic.StoreInt64(&first.batchRouteMissingUntil, time.Now().Add(time.Hour).UnixNano())
+
+	// 故障期间修改配置，熔断、缓存和批量接口探测状态保持不变
+	write("X-Token-2")
+	if err = reloader.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second := reloader.Current()
+	if second == first || second.Config.User.Header != "X-Token-2" {
+		t.Fatal("config not swapped")
+	}
+	if second.CircuitState() != CircuitOpen || second.decisionCache != first.decisionCache || second.batchRouteAvailable() {
+		t.Fatal(second.CircuitState(), second.batchRouteAvailable())
+	}
+	if _, err = second.CheckAuth(header, false); !errors.Is(err, ErrAuthServiceUnavailable) {
+		t.Fatal(err)
+	}
+}
+
+func waitReload(reloaded <-chan error) error {
+	select {
+	case err := <-reloaded:
+		return err
+	case <-time.After(5 * time.Second):
+		return errors.New("reload timeout")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/client.yaml"
+	if err := os.WriteFile(path, []byte(`
+service: {authServiceBaseUrl: "http://auth.local", currentServiceName: shop}
+retry: {maxRetries: 2}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AUTH_USER_HEADER", "X-Token")
+	t.Setenv("AUTH_DECISION_CACHE_TTL", "30s")
+	t.Setenv("AUTH_PUBLIC_ROUTES", `[{"path": "/health"}]`)
+	config, err := LoadHttpClientConfig(path, "AUTH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentServiceName != "shop" || config.MaxRetries != 2 || config.User.Header != "X-Token" || config.DecisionCache.TTL != 30*time.Second ||
+		len(config.Public.Routes) != 1 || config.Client.Header != DefaultHeaderClientToken || !config.EnableTraceLog {
+		t.Fatalf("unexpected config %+v", config)
+	}
+	client, err := NewHttpClientWithConfig(config)
+	if err != nil || client.Config.User.Header != "X-Token" || !client.IsPublicRoute(func(key string) string {
+		return map[string]string{PseudoHeaderMethod: "GET", PseudoHeaderPath: "/health"}[key]
+	}) {
+		t.Fatal(err)
+	}
+
+	// JSON中的时间与YAML一样可以使用字符串，也兼容纳秒数
+	jsonPath := dir + "/client.json"
+	if err = os.WriteFile(jsonPath, []byte(`{
+"service": {"authServiceBaseUrl": "http://auth.local", "currentServiceName": "shop", "timeout": "3s"},
+"retry": {"maxRetries": 2, "minBackoff": 1000000, "maxBackoff": "1s"},
+"decisionCache": {"enable": true, "ttl": "1m"}
+}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadHttpClientConfig(jsonPath, "")
+	if err != nil || config.Timeout != 3*time.Second || config.Retry.MinBackoff != time.Millisecond || config.Retry.MaxBackoff != time.Second || config.DecisionCache.TTL != time.Minute {
+		t.Fatal(config, err)
+	}
+	if err = DecodeConfig([]byte(`{"retry": {"minBackoff": "soon"}}`), ConfigFormatJson, defaultHttpClientConfig("", "", "")); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "retry.minBackoff") {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AUTH_RETRY_MAX_RETRIES", "many")
+	if _, err = LoadHttpClientConfig(path, "AUTH"); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "AUTH_RETRY_MAX_RETRIES") {
+		t.Fatal(err)
+	}
+
+	invalid := defaultHttpClientConfig("ftp://auth.local", "shop", "short")
+	invalid.Client.Id = "a@b"
+	invalid.Retry.MaxRetries = -1
+	var problems ConfigErrors
+	if err = invalid.Validate(); !errors.As(err, &problems) || len(problems) != 5 {
+		t.Fatal(err)
+	}
+	if _, err = NewHttpClientE("http://auth.local", "shop", "short"); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal(err)
+	}
+	if _, err = NewHttpClientE("http://auth.local", "shop", "", WithPublicConfig(Public{Routes: []PublicRoute{{Path: "health"}}})); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "public.routes") {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrInvalidConfig) {
+				t.Fatal(err)
+			}
+		}()
+		NewLocalAuthChecker("", WithLocalPublicConfig(LocalPublic{Routes: []PublicRoute{{Path: "health"}}}))
+	}()
+	// 不带E的构造函数同样校验配置，而不是等到请求时才出错
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "service.encryptKey") {
+				t.Fatal(err)
+			}
+		}()
+		NewHttpClient("http://auth.local", "shop", "short")
+	}()
+	func() {
+		defer func() {
+			if err, _ := recover().(error); !errors.Is(err, ErrInvalidConfig) {
+				t.Fatal(err)
+			}
+		}()
+		NewLocalAuthChecker("", WithLocalAccessCodeConfig(LocalAccessCode{EncryptContent: true}))
+	}()
+	if _, err = NewLocalAuthCheckerE("", WithLocalAccessCodeConfig(LocalAccessCode{EncryptContent: true})); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal(err)
+	}
+	if _, err = NewRedisJwtUtilE(context.Background(), WithRedisConfig(Redis{})); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "redis.address") {
+		t.Fatal(err)
+	}
+	// WithConfig在应用选项之前校验配置，选项不会被调用
+	applied := false
+	_, err = NewRedisJwtUtilWithConfig(context.Background(), &JwtUtilConfig{}, func(*RedisJwtUtil) { applied = true })
+	if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "jwt.privateKey") || applied {
+		t.Fatal(err, applied)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	publicDer, _ := x509.MarshalPKIXPublicKey(&other.PublicKey)
+	t.Setenv("JWT_REDIS_ADDRESS", "127.0.0.1:6379")
+	t.Setenv("JWT_JWT_PRIVATE_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer})))
+	t.Setenv("JWT_JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})))
+	if _, err = LoadJwtUtilConfig("", "JWT"); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "jwt.publicKey") {
+		t.Fatal(err)
+	}
+	publicDer, _ = x509.MarshalPKIXPublicKey(&key.PublicKey)
+	t.Setenv("JWT_JWT_PUBLIC_KEY", string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})))
+	jwtConfig, err := LoadJwtUtilConfig("", "JWT")
+	if err != nil || jwtConfig.Prefix != DefaultCachePrefix || jwtConfig.Address != "127.0.0.1:6379" {
+		t.Fatal(jwtConfig, err)
+	}
+}
+
+func TestJwtAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	encode := func(key crypto.Signer) (PemBytes, PemBytes) {
+		privateDer, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicDer, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}),
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+	}
+
+	now := float64(time.Now().Unix())
+	for _, c := range []struct {
+		key       crypto.Signer
+		algorithm string
+	}{
+		{ecKey, JwtAlgorithmES256},
+		{edKey, JwtAlgorithmEdDSA},
+		{rsaKey, JwtAlgorithmPS256},
+		{rsaKey, JwtAlgorithmRS512},
+		{ecKey, ""},
+	} {
+		privateKey, publicKey := encode(c.key)
+		config := &JwtUtilConfig{Redis: Redis{Address: "127.0.0.1:6379"}, Jwt: Jwt{PrivateKey: privateKey, PublicKey: publicKey, Algorithm: c.algorithm}}
+		if err := config.Validate(); err != nil {
+			t.Fatal(c.algorithm, err)
+		}
+		util := &RedisJwtUtil{}
+		WithJwtConfig(config.Jwt)(util)
+		jwtUser, err := util.GenerateJwt("1", "user", "user", "d1", now, now+60)
+		if err != nil {
+			t.Fatal(c.algorithm, err)
+		}
+		if _, err = util.ValidateJwt(jwtUser.Token); err != nil {
+			t.Fatal(c.algorithm, err)
+		}
+		verifier, err := NewJwtVerifier(publicKey, util.Config.Algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = verifier.ValidateJwt(jwtUser.Token); err != nil {
+			t.Fatal(c.algorithm, err)
+		}
+	}
+
+	// 未在允许列表中的算法
+	privateKey, publicKey := encode(rsaKey)
+	util := &RedisJwtUtil{}
+	WithJwtConfig(Jwt{PrivateKey: privateKey, PublicKey: publicKey, Algorithm: JwtAlgorithmPS256})(util)
+	ps256, _ := util.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if _, err := (&JwtVerifier{PublicKey: &rsaKey.PublicKey}).ValidateJwt(ps256.Token); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+	if _, err := (&JwtVerifier{PublicKey: &rsaKey.PublicKey, Algorithms: []string{JwtAlgorithmRS256, JwtAlgorithmPS256}}).ValidateJwt(ps256.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// none和使用公钥作为HMAC秘钥的令牌
+	claims := jwt.MapClaims{
+		JwtTokenClaimsId: "1", JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1",
+		JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60,
+	}
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(publicKey))
+	confused := &JwtVerifier{PublicKey: &rsaKey.PublicKey, Algorithms: []string{"none", "HS256", JwtAlgorithmRS256}}
+	for _, token := range []string{none, hs256} {
+		if _, err := confused.ValidateJwt(token); !errors.Is(err, ErrJwtErrFormat) {
+			t.Fatal(err)
+		}
+	}
+	for _, algorithm := range []string{"none", "HS256", JwtAlgorithmES256, JwtAlgorithmEdDSA} {
+		if _, err := NewJwtVerifier(publicKey, algorithm); !errors.Is(err, ErrJwtAlgorithm) {
+			t.Fatal(algorithm, err)
+		}
+	}
+
+	// 算法与秘钥类型或曲线不匹配
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	for _, c := range []struct {
+		key       crypto.Signer
+		algorithm string
+	}{{rsaKey, JwtAlgorithmES256}, {p384, JwtAlgorithmES256}, {edKey, JwtAlgorithmRS256}, {ecKey, "HS256"}} {
+		privateKey, publicKey := encode(c.key)
+		config := &JwtUtilConfig{Redis: Redis{Address: "127.0.0.1:6379"}, Jwt: Jwt{PrivateKey: privateKey, PublicKey: publicKey, Algorithm: c.algorithm}}
+		if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "jwt.algorithm") {
+			t.Fatal(c.algorithm, err)
+		}
+	}
+}
+
+func TestJwtKeyring(t *testing.T) {
+	encode := func(key crypto.Signer) (PemBytes, PemBytes) {
+		privateDer, _ := x509.MarshalPKCS8PrivateKey(key)
+		publicDer, _ := x509.MarshalPKIXPublicKey(key.Public())
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}),
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+	}
+	kidOf := func(token string) interface{} {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Header[JwtTokenHeaderKeyId]
+	}
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	oldPrivate, oldPublic := encode(oldKey)
+	newPrivate, newPublic := encode(newKey)
+	now := float64(time.Now().Unix())
+
+	build := func(config Jwt) *RedisJwtUtil {
+		jwtConfig := &JwtUtilConfig{Redis: Redis{Address: "127.0.0.1:6379"}, Jwt: config}
+		if err := jwtConfig.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		util := &RedisJwtUtil{}
+		WithJwtConfig(config)(util)
+		return util
+	}
+
+	// 轮换前签发的令牌不带kid
+	legacy, err := build(Jwt{PrivateKey: oldPrivate, PublicKey: oldPublic}).GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if err != nil || kidOf(legacy.Token) != nil {
+		t.Fatal(err)
+	}
+
+	// 新秘钥尚未生效时仍使用旧秘钥签名，但已可用于校验
+	scheduled := build(Jwt{PrivateKey: oldPrivate, PublicKey: oldPublic, Keys: []JwtKey{
+		{Kid: "2", PrivateKey: newPrivate, NotBefore: time.Now().Add(time.Hour)},
+	}})
+	jwtUser, err := scheduled.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if err != nil || kidOf(jwtUser.Token) != nil {
+		t.Fatal(err)
+	}
+	newUtil := build(Jwt{Keys: []JwtKey{{Kid: "2", PrivateKey: newPrivate}}})
+	preSigned, _ := newUtil.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if _, err = scheduled.ValidateJwt(preSigned.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// 新秘钥生效后签发的令牌带kid，轮换前的令牌仍然有效
+	rotated := build(Jwt{PublicKey: oldPublic, Keys: []JwtKey{
+		{Kid: "2", PrivateKey: newPrivate, NotBefore: time.Now().Add(-time.Minute)},
+	}})
+	jwtUser, err = rotated.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if err != nil || kidOf(jwtUser.Token) != "2" {
+		t.Fatal(err)
+	}
+	for _, token := range []string{legacy.Token, jwtUser.Token} {
+		if _, err = rotated.ValidateJwt(token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 未知kid和已退役的秘钥
+	unknown := build(Jwt{Keys: []JwtKey{{Kid: "3", PrivateKey: newPrivate}}})
+	if _, err = unknown.ValidateJwt(jwtUser.Token); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+	retired := build(Jwt{Keys: []JwtKey{
+		{PublicKey: oldPublic, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(-time.Minute)},
+		{Kid: "2", PrivateKey: newPrivate},
+	}})
+	if _, err = retired.ValidateJwt(legacy.Token); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+	if _, err = retired.ValidateJwt(jwtUser.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, config := range []Jwt{
+		{Keys: []JwtKey{{Kid: "2", PrivateKey: newPrivate}, {Kid: "2", PublicKey: oldPublic}}},
+		{Keys: []JwtKey{{Kid: "2", PublicKey: newPublic}}},
+		{Keys: []JwtKey{{Kid: "2", PrivateKey: newPrivate, NotBefore: time.Now().Add(time.Hour)}}},
+		{Keys: []JwtKey{{Kid: "2", PrivateKey: newPrivate, PublicKey: oldPublic}}},
+	} {
+		jwtConfig := &JwtUtilConfig{Redis: Redis{Address: "127.0.0.1:6379"}, Jwt: config}
+		if err = jwtConfig.Validate(); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	edPublic, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	for _, key := range []crypto.PublicKey{&rsaKey.PublicKey, &ecKey.PublicKey, edPublic} {
+		jwk, err := NewJWK("k", DefaultJwtAlgorithmOf(key), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := json.Marshal(jwk)
+		var decoded JWK
+		_ = json.Unmarshal(data, &decoded)
+		parsed, err := decoded.PublicKey()
+		if err != nil || !publicKeyEqual(parsed, key) {
+			t.Fatal(jwk, err)
+		}
+	}
+
+	newUtil := func(entries ...*JwtKeyringEntry) *RedisJwtUtil {
+		keyring, err := NewJwtKeyringWithEntries(entries...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &RedisJwtUtil{Keyring: keyring}
+	}
+	current := atomic.Pointer[RedisJwtUtil]{}
+	current.Store(newUtil(
+		&JwtKeyringEntry{Kid: "1", PrivateKey: rsaKey},
+		&JwtKeyringEntry{Kid: "2", PrivateKey: ecKey, NotBefore: time.Now().Add(time.Hour)},
+		&JwtKeyringEntry{Kid: "old", PrivateKey: edKey, NotAfter: time.Now().Add(-time.Minute)},
+	))
+	var fetches int32
+	handler := &JWKSHandler{Util: current.Load}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := &JWKS{}
+	_ = json.NewDecoder(res.Body).Decode(jwks)
+	_ = res.Body.Close()
+	if res.Header.Get("Content-Type") != JWKSContentType || len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "1" || jwks.Keys[1].Crv != "P-384" {
+		t.Fatal(res.Header, jwks)
+	}
+
+	now := float64(time.Now().Unix())
+	verifier := NewJWKSVerifier(server.URL, WithJWKSRefreshInterval(time.Hour, 0))
+	jwtUser, _ := current.Load().GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if user, err := verifier.ValidateJwt(jwtUser.Token); err != nil || user.Id != "1" {
+		t.Fatal(err)
+	}
+
+	// 未知kid时重新下载
+	rotated := newUtil(&JwtKeyringEntry{Kid: "3", PrivateKey: edKey})
+	jwtUser, _ = rotated.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	throttled := NewJWKSVerifier(server.URL, WithJWKSRefreshInterval(time.Hour, time.Hour))
+	if err = throttled.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	current.Store(rotated)
+	atomic.StoreInt32(&fetches, 0)
+	if _, err = throttled.ValidateJwt(jwtUser.Token); !errors.Is(err, ErrJwtErrFormat) || atomic.LoadInt32(&fetches) != 0 {
+		t.Fatal(err, fetches)
+	}
+	if _, err = verifier.ValidateJwt(jwtUser.Token); err != nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Fatal(err, fetches)
+	}
+
+	// 允许列表之外的算法
+	restricted := NewJWKSVerifier(server.URL, WithJWKSAlgorithms(JwtAlgorithmRS256))
+	if _, err = restricted.ValidateJwt(jwtUser.Token); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	if _, err = NewJWKSVerifier(server.URL).ValidateJwt(jwtUser.Token); !errors.Is(err, ErrAuthServiceUnavailable) {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshTokenClaims(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	util := &RedisJwtUtil{PrivateKey: key, PublicKey: &key.PublicKey, Config: JwtUtilConfig{Jwt: Jwt{Prefix: DefaultCachePrefix, CacheSplitter: DefaultCacheSplitter}}}
+	now := float64(time.Now().Unix())
+	claims := func(typ string, exp float64) jwt.MapClaims {
+		return jwt.MapClaims{
+			JwtTokenClaimsType: typ, JwtTokenClaimsId: "1", JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1",
+			JwtTokenClaimsFamily: "f1", JwtTokenClaimsTokenId: "t1", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: exp,
+		}
+	}
+
+	// 刷新令牌不能作为访问令牌使用
+	refreshToken, err := util.signJwt(claims(JwtTokenTypeRefresh, now+60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = util.ValidateJwt(refreshToken); !errors.Is(err, ErrJwtErrVersion) {
+		t.Fatal(err)
+	}
+	parsed, err := util.parseRefreshToken(refreshToken)
+	if err != nil || parsed != (refreshTokenClaims{id: "1", did: "d1", fid: "f1", jti: "t1"}) {
+		t.Fatal(parsed, err)
+	}
+
+	// 访问令牌、过期或伪造的刷新令牌在访问redis之前被拒绝
+	accessToken, _ := util.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	expired, _ := util.signJwt(claims(JwtTokenTypeRefresh, now-60))
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged, _ := (&RedisJwtUtil{PrivateKey: otherKey}).signJwt(claims(JwtTokenTypeRefresh, now+60))
+	for _, token := range []string{accessToken.Token, expired, forged, "malformed"} {
+		if _, err = util.RefreshJwt(token); !errors.Is(err, ErrRefreshTokenFail) {
+			t.Fatal(err)
+		}
+		if err = util.RevokeRefreshToken(token); !errors.Is(err, ErrRefreshTokenFail) {
+			t.Fatal(err)
+		}
+	}
+
+	// 令牌族与访问令牌使用相同的前缀，按用户或设备注销时一起删除
+	familyKey := util.GetRefreshTokenCacheKey("1", "d1", "f1")
+	if familyKey != "Jwt::1::d1::RefreshToken::f1" || !strings.HasPrefix(familyKey, util.GetUserDidJwtCacheKeyPrefix("1", "d1")) {
+		t.Fatal(familyKey)
+	}
+}
+
+type testTenantClaims struct {
+	RawJwtUser
+	Tenant string   `json:"tenant"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+func TestJwtCustomClaims(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	util := &RedisJwtUtil{PrivateKey: key, PublicKey: &key.PublicKey}
+	now := float64(time.Now().Unix())
+	claims := &testTenantClaims{
+		RawJwtUser: RawJwtUser{Id: "1", Name: "user", Kind: "user", Did: "d1", Exp: now + 60},
+		Tenant:     "t1",
+		Roles:      []string{"admin", "dev"},
+	}
+	jwtUser, err := GenerateJwtWithClaims(util, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Iss != DefaultIssuer || claims.Iat == 0 || jwtUser.RawJwtUser != claims.RawJwtUser || jwtUser.Extra["tenant"] != "t1" {
+		t.Fatal(claims, jwtUser)
+	}
+
+	// RedisJwtUtil、JwtVerifier和JWKSVerifier都可以解析自定义声明
+	verifier := &JwtVerifier{PublicKey: &key.PublicKey}
+	keyring, _ := util.keyring()
+	jwks, _ := NewJWKS(keyring, time.Now())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+	for _, v := range []JwtClaimsValidator{util, verifier, NewJWKSVerifier(server.URL)} {
+		parsed, err := ValidateJwtInto[testTenantClaims](v, jwtUser.Token)
+		if err != nil || !reflect.DeepEqual(parsed, claims) {
+			t.Fatal(parsed, err)
+		}
+	}
+
+	// 原有的ValidateJwt将自定义声明保存在Extra中，没有自定义声明时Extra为nil
+	validated, err := util.ValidateJwt(jwtUser.Token)
+	if err != nil || validated.RawJwtUser != claims.RawJwtUser || validated.Extra["tenant"] != "t1" ||
+		!reflect.DeepEqual(validated.Extra["roles"], []any{"admin", "dev"}) || len(validated.Extra) != 2 {
+		t.Fatal(validated, err)
+	}
+	plain, _ := util.GenerateJwt("1", "user", "user", "d1", now, now+60)
+	if validated, err = util.ValidateJwt(plain.Token); err != nil || validated.Extra != nil {
+		t.Fatal(validated, err)
+	}
+
+	// 声明类型不匹配或缺少标准声明时返回ErrJwtErrVersion，不会panic
+	for _, mapClaims := range []jwt.MapClaims{
+		{JwtTokenClaimsId: 1, JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60},
+		{JwtTokenClaimsId: "1", JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60, "tenant": 1},
+		{JwtTokenClaimsId: "1", JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60},
+		{JwtTokenClaimsType: JwtTokenTypeRefresh, JwtTokenClaimsId: "1", JwtTokenClaimsName: "user", JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60},
+	} {
+		token, _ := util.signJwt(mapClaims)
+		if _, err = ValidateJwtInto[testTenantClaims](util, token); !errors.Is(err, ErrJwtErrVersion) {
+			t.Fatal(mapClaims, err)
+		}
+	}
+	wrongType, _ := util.signJwt(jwt.MapClaims{JwtTokenClaimsId: "1", JwtTokenClaimsName: 1, JwtTokenClaimsKind: "user", JwtTokenClaimsDeviceId: "d1", JwtTokenClaimsIssuer: DefaultIssuer, JwtTokenClaimsIssueAt: now, JwtTokenClaimsExpireAt: now + 60})
+	if _, err = util.ValidateJwt(wrongType); !errors.Is(err, ErrJwtErrVersion) {
+		t.Fatal(err)
+	}
+}
+
+// newTestRedisJwtConfig 使用miniredis和新生成的秘钥对的配置
+func newTestRedisJwtConfig(t *testing.T, redis *miniredis.Miniredis) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	publicDer, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	data, err := json.Marshal(JwtUtilConfig{
+		Redis: Redis{Address: redis.Addr()},
+		Jwt: Jwt{
+			PublicKey:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer}),
+			PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestRedisJwtUtil 连接miniredis的RedisJwtUtil
+func newTestRedisJwtUtil(t *testing.T, redis *miniredis.Miniredis) *RedisJwtUtil {
+	config := &JwtUtilConfig{}
+	if err := json.Unmarshal(newTestRedisJwtConfig(t, redis), config); err != nil {
+		t.Fatal(err)
+	}
+	util, err := NewRedisJwtUtilWithConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(util.closeRedis)
+	return util
+}
+
+func TestRedisRefreshToken(t *testing.T) {
+	mr := miniredis.RunT(t)
+	util := newTestRedisJwtUtil(t, mr)
+	ctx := context.Background()
+	valid := func(user *JwtUser) bool {
+		parsed, err := util.ValidateJwt(user.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := util.IsJwtInCache(ctx, parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	first, err := util.SignJwtWithRefreshToken("1", "user", "user", "d1")
+	if err != nil || !valid(first.JwtUser) {
+		t.Fatal(first, err)
+	}
+	// 同一秒内刷新，访问令牌的缓存键不变，原访问令牌也要失效
+	second, err := util.RefreshJwt(first.RefreshToken)
+	if err != nil || second.RefreshToken == first.RefreshToken || !valid(second.JwtUser) {
+		t.Fatal(second, err)
+	}
+	if valid(first.JwtUser) {
+		t.Fatal("refreshed access token is still valid", second.Iat == first.Iat)
+	}
+	third, err := util.RefreshJwt(second.RefreshToken)
+	if err != nil || !valid(third.JwtUser) || valid(second.JwtUser) {
+		t.Fatal(third, err)
+	}
+
+	// 旧刷新令牌被重复使用时注销整个令牌族和当前访问令牌
+	familyKey := util.GetRefreshTokenCacheKey("1", "d1", mustParseRefreshToken(t, util, third.RefreshToken).fid)
+	if !mr.Exists(familyKey) {
+		t.Fatal("family not saved", mr.Keys())
+	}
+	if _, err = util.RefreshJwt(first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatal(err)
+	}
+	if mr.Exists(familyKey) || valid(third.JwtUser) {
+		t.Fatal("family not revoked", mr.Keys())
+	}
+	if _, err = util.RefreshJwt(third.RefreshToken); !errors.Is(err, ErrRefreshTokenFail) {
+		t.Fatal(err)
+	}
+
+	// rotateRefreshTokenScript：jti不匹配时删除令牌族，令牌族不存在时返回0
+	key := util.GetRefreshTokenCacheKey("2", "d1", "f1")
+	mr.Set(key, `{"jti":"a"}`)
+	for _, c := range []struct {
+		jti    string
+		status int
+		value  string
+	}{
+		{"a", 1, `{"jti":"b"}`},
+		{"a", 2, ""},
+		{"b", 0, ""},
+	} {
+		status, err := rotateRefreshTokenScript.Run(ctx, util.UniversalClient(), []string{key}, c.jti, `{"jti":"b"}`, time.Minute.Milliseconds()).Int()
+		if err != nil || status != c.status {
+			t.Fatal(c.jti, status, err)
+		}
+		if value, _ := mr.Get(key); value != c.value {
+			t.Fatal(c.jti, value)
+		}
+		if ttl := mr.TTL(key); c.status == 1 && ttl != time.Minute {
+			t.Fatal(c.jti, ttl)
+		}
+	}
+
+	// 退出登录注销令牌族和当前访问令牌
+	pair, err := util.SignJwtWithRefreshToken("3", "user", "user", "d1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = util.RevokeRefreshToken(pair.RefreshToken); err != nil || valid(pair.JwtUser) {
+		t.Fatal(err)
+	}
+	if _, err = util.RefreshJwt(pair.RefreshToken); !errors.Is(err, ErrRefreshTokenFail) {
+		t.Fatal(err)
+	}
+	if err = util.RevokeRefreshToken(pair.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustParseRefreshToken(t *testing.T, util *RedisJwtUtil, refreshToken string) refreshTokenClaims {
+	claims, err := util.parseRefreshToken(refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestReloadingRedisJwtUtil(t *testing.T) {
+	first, second := miniredis.RunT(t), miniredis.RunT(t)
+	path := t.TempDir() + "/jwt.json"
+	if err := os.WriteFile(path, newTestRedisJwtConfig(t, first), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	reloader, err := NewReloader(ctx, &FileConfigSource{Path: path}, RedisJwtUtilBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	util := ReloadingRedisJwtUtil{reloader}
+	checker := NewLocalAuthChecker("", WithJwtSessionValidator(util))
+	oldUser := reloader.Current().SignJwtAndSaveToCache("1", "user", "user", "d1")
+	if _, err = checker.CheckAuth(ctx, oldUser.Token, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后LocalAuthChecker使用新的秘钥和redis
+	if err = os.WriteFile(path, newTestRedisJwtConfig(t, second), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = reloader.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newUser := reloader.Current().SignJwtAndSaveToCache("1", "user", "user", "d1")
+	if !second.Exists(reloader.Current().GetUserJwtCacheKey("1", "d1", newUser.Iat)) {
+		t.Fatal("new token should be saved to the new redis")
+	}
+	if _, err = checker.CheckAuth(ctx, newUser.Token, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = checker.CheckAuth(ctx, oldUser.Token, false); !errors.Is(err, ErrJwtErrFormat) {
+		t.Fatal(err)
+	}
+
+	// 进行中的调用结束后才关闭旧连接
+	refs := &redisRefs{}
+	closed := false
+	refs.acquire()
+	refs.closeWhenIdle(func() { closed = true })
+	if closed {
+		t.Fatal("closed while in use")
+	}
+	refs.release()
+	if !closed {
+		t.Fatal("not closed after release")
+	}
+}
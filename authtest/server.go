@@ -0,0 +1,381 @@
+package authtest
+
+import (
+	"encoding/json"
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/go-logr/logr"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+// RecordedRequest 记录发往模拟鉴权服务的请求
+type RecordedRequest struct {
+	Path   string
+	Header http.Header
+	Form   url.Values
+}
+
+type user struct {
+	jwtUser    *auth.JwtUser
+	customAuth any
+	customPerm any
+	codes      map[string]bool
+	actions    map[string]bool
+}
+
+type client struct {
+	secret string
+	codes  map[string]bool
+}
+
+// Server 进程内的模拟鉴权服务，实现HttpClient调用的全部接口，返回与真实服务相同的HttpResponse结构
+type Server struct {
+	*httptest.Server
+	config   *Config
+	aesUtil  *auth.AesUtil
+	mu       sync.Mutex
+	users    map[string]*user
+	clients  map[string]*client
+	failures map[string]int
+	requests []RecordedRequest
+}
+
+func (s *Server) SetUser(token string, jwtUser auth.RawJwtUser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getUser(token).jwtUser = &auth.JwtUser{RawJwtUser: jwtUser, Token: token}
+}
+
+func (s *Server) SetCustomAuth(token string, customAuth any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getUser(token).customAuth = customAuth
+}
+
+func (s *Server) SetCustomPerm(token string, customPerm any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getUser(token).customPerm = customPerm
+}
+
+func (s *Server) GrantPerms(token string, codes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.getUser(token)
+	for _, code := range codes {
+		u.codes[code] = true
+	}
+}
+
+func (s *Server) GrantAction(token string, service string, method string, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getUser(token).actions[auth.Action{Service: service, Method: method, Path: path}.Key()] = true
+}
+
+func (s *Server) RemoveUser(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, token)
+}
+
+func (s *Server) SetClient(id string, secret string, codes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &client{secret: secret, codes: make(map[string]bool, len(codes))}
+	for _, code := range codes {
+		c.codes[code] = true
+	}
+	s.clients[id] = c
+}
+
+// SetFailure 让指定接口返回HTTP状态码status，模拟鉴权服务故障，status为0时恢复正常
+func (s *Server) SetFailure(path string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = status
+}
+
+func (s *Server) Requests() []RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RecordedRequest(nil), s.requests...)
+}
+
+func (s *Server) ClearRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// NewClient 创建连接到模拟服务的HttpClient，请求头配置与服务端保持一致。
+// 客户端的请求头和加密配置总是使用服务端的配置，未设置Client.AccessCode时使用服务端允许的第一个访问码
+func (s *Server) NewClient(options ...auth.ClientOption) *auth.HttpClient {
+	defaults := []auth.ClientOption{
+		auth.WithAccessCodeConfig(auth.AccessCode{
+			Enable:             s.config.AccessCode.Enable,
+			SkipUserTokenCheck: true,
+			Header:             s.config.AccessCode.Header,
+			EncryptContent:     s.config.AccessCode.EncryptContent,
+		}),
+		auth.WithRandomKeyConfig(auth.RandomKey{Enable: s.config.RandomKey.Enable, Header: s.config.RandomKey.Header}),
+		auth.WithUserConfig(auth.User{Header: s.config.User.Header, HeaderSchema: s.config.User.HeaderSchema}),
+	}
+	options = append(defaults, options...)
+	options = append(options, func(client *auth.HttpClient) {
+		client.Config.Client.Header = s.config.Client.Header
+		client.Config.Client.HeaderSchema = s.config.Client.HeaderSchema
+		client.Config.Client.EncryptContent = s.config.Client.EncryptContent
+		if len(client.Config.Client.AccessCode) == 0 && len(s.config.AccessCode.Codes) > 0 {
+			client.Config.Client.AccessCode = s.config.AccessCode.Codes[0]
+		}
+	})
+	return auth.NewHttpClient(s.URL, "authtest", s.config.AesKey, options...)
+}
+
+func (s *Server) getUser(token string) *user {
+	u, ok := s.users[token]
+	if !ok {
+		u = &user{codes: make(map[string]bool), actions: make(map[string]bool)}
+		s.users[token] = u
+	}
+	return u
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(auth.UrlPostCheckAuth, s.handleCheckAuth)
+	mux.HandleFunc(auth.UrlPostCheckPermByCode, s.handleCheckPermByCode)
+	mux.HandleFunc(auth.UrlPostCheckPermByAction, s.handleCheckPermByAction)
+	mux.HandleFunc(auth.UrlPostCheckPermsByCodes, s.handleCheckPermsByCodes)
+	mux.HandleFunc(auth.UrlPostCheckPermsByActions, s.handleCheckPermsByActions)
+	mux.HandleFunc(auth.UrlPostCheckClientAuth, s.handleCheckClientAuth)
+	mux.HandleFunc(auth.UrlPostCheckClientPermByCode, s.handleCheckClientPermByCode)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		s.mu.Lock()
+		s.requests = append(s.requests, RecordedRequest{Path: r.URL.Path, Header: r.Header.Clone(), Form: r.PostForm})
+		status, failed := s.failures[r.URL.Path]
+		s.mu.Unlock()
+		if failed {
+			w.WriteHeader(status)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := s.checkCommonHeaders(r); err != nil {
+			writeError(w, auth.CodeAuthFail, err)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// checkCommonHeaders 校验访问码、随机码和追踪id请求头
+func (s *Server) checkCommonHeaders(r *http.Request) error {
+	if s.config.TraceId && len(r.Header.Get(auth.TraceId)) == 0 {
+		return auth.ErrInternalError
+	}
+	if s.config.AccessCode.Enable {
+		accessCode, err := auth.ExtractAccessCode(r.Header.Get, s.config.AccessCode.Header, s.config.AccessCode.EncryptContent, s.aesUtil, logr.Discard())
+		if err != nil {
+			return err
+		}
+		if len(s.config.AccessCode.Codes) > 0 && !contains(s.config.AccessCode.Codes, accessCode) {
+			return auth.ErrAuthFail
+		}
+	}
+	if s.config.RandomKey.Enable {
+		if _, err := auth.ExtractRandomKey(r.Header.Get, s.config.RandomKey.Header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) currentUser(r *http.Request) (*user, error) {
+	token, err := auth.ExtractUserToken(r.Header.Get, s.config.User.Header, s.config.User.HeaderSchema)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[token]
+	if !ok || u.jwtUser == nil {
+		return nil, auth.ErrAuthFail
+	}
+	return u, nil
+}
+
+func (s *Server) currentClient(r *http.Request) (*client, string, error) {
+	id, secret, _, err := auth.ExtractClientInfoAndToken(r.Header.Get, s.config.Client.Header, s.config.Client.HeaderSchema, s.config.Client.EncryptContent, s.aesUtil, logr.Discard())
+	if err != nil {
+		return nil, "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[id]
+	if !ok || c.secret != secret {
+		return nil, id, auth.ErrClientTokenFail
+	}
+	return c, id, nil
+}
+
+func (s *Server) handleCheckAuth(w http.ResponseWriter, r *http.Request) {
+	u, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	result := &auth.CheckAuthResult{User: u.jwtUser}
+	if formBool(r, "fulfillCustomAuth") {
+		result.CustomAuth = u.customAuth
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleCheckPermByCode(w http.ResponseWriter, r *http.Request) {
+	u, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	if !s.hasPerm(u.codes, r.PostForm.Get("code")) {
+		writeError(w, auth.CodePermFail, auth.ErrPermFail)
+		return
+	}
+	writeResult(w, permResult(r, u))
+}
+
+func (s *Server) handleCheckPermByAction(w http.ResponseWriter, r *http.Request) {
+	u, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	action := auth.Action{Service: r.PostForm.Get("service"), Method: r.PostForm.Get("method"), Path: r.PostForm.Get("path")}
+	if !s.hasPerm(u.actions, action.Key()) {
+		writeError(w, auth.CodePermFail, auth.ErrPermFail)
+		return
+	}
+	writeResult(w, permResult(r, u))
+}
+
+func (s *Server) handleCheckPermsByCodes(w http.ResponseWriter, r *http.Request) {
+	u, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	var codes []string
+	if err = json.Unmarshal([]byte(r.PostForm.Get("codes")), &codes); err != nil {
+		writeError(w, auth.CodeInternalError, auth.ErrInternalError)
+		return
+	}
+	result := permsResult(r, u)
+	for _, code := range codes {
+		result.Perms[code] = s.hasPerm(u.codes, code)
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleCheckPermsByActions(w http.ResponseWriter, r *http.Request) {
+	u, err := s.currentUser(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	var actions []auth.Action
+	if err = json.Unmarshal([]byte(r.PostForm.Get("actions")), &actions); err != nil {
+		writeError(w, auth.CodeInternalError, auth.ErrInternalError)
+		return
+	}
+	result := permsResult(r, u)
+	for _, action := range actions {
+		result.Perms[action.Key()] = s.hasPerm(u.actions, action.Key())
+	}
+	writeResult(w, result)
+}
+
+func (s *Server) handleCheckClientAuth(w http.ResponseWriter, r *http.Request) {
+	_, id, err := s.currentClient(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	writeResult(w, &auth.CheckClientAuthResult{ClientAuthOk: true, ClientId: id})
+}
+
+func (s *Server) handleCheckClientPermByCode(w http.ResponseWriter, r *http.Request) {
+	c, _, err := s.currentClient(r)
+	if err != nil {
+		writeError(w, auth.CodeAuthFail, err)
+		return
+	}
+	writeResult(w, &auth.CheckClientPermResult{ClientPermOk: s.hasPerm(c.codes, r.PostForm.Get("code"))})
+}
+
+func (s *Server) hasPerm(granted map[string]bool, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return granted[key]
+}
+
+func permResult(r *http.Request, u *user) *auth.CheckPermResult {
+	result := &auth.CheckPermResult{}
+	if formBool(r, "fulfillJwt") {
+		result.User = u.jwtUser
+	}
+	if formBool(r, "fulfillCustomAuth") {
+		result.CustomAuth = u.customAuth
+	}
+	if formBool(r, "fulfillCustomPerm") {
+		result.CustomPerm = u.customPerm
+	}
+	return result
+}
+
+func permsResult(r *http.Request, u *user) *auth.CheckPermsResult {
+	result := &auth.CheckPermsResult{Perms: make(map[string]bool)}
+	if formBool(r, "fulfillJwt") {
+		result.User = u.jwtUser
+	}
+	if formBool(r, "fulfillCustomAuth") {
+		result.CustomAuth = u.customAuth
+	}
+	return result
+}
+
+func formBool(r *http.Request, key string) bool {
+	return r.PostForm.Get(key) == "true"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	writeJson(w, auth.HttpResult{Code: auth.CodeSuccess, Success: true, Result: result})
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJson(w, auth.HttpResult{Code: code, Message: err.Error(), Success: false})
+}
+
+func writeJson(w http.ResponseWriter, result auth.HttpResult) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(result)
+}
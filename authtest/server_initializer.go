@@ -0,0 +1,111 @@
+package authtest
+
+import (
+	auth "github.com/Macrow/auth-go-sdk"
+	"net/http/httptest"
+)
+
+type AccessCode struct {
+	Enable         bool
+	Header         string
+	EncryptContent bool
+	Codes          []string // 允许的访问码，为空时只校验请求头存在
+}
+
+type RandomKey struct {
+	Enable bool
+	Header string
+}
+
+type User struct {
+	Header       string
+	HeaderSchema string
+}
+
+type Client struct {
+	Header         string
+	HeaderSchema   string
+	EncryptContent bool
+}
+
+type Config struct {
+	AesKey  string
+	TraceId bool // 要求请求携带追踪id
+	AccessCode
+	RandomKey
+	User
+	Client
+}
+
+type Option func(*Config)
+
+func WithAesKey(key string) Option {
+	return func(config *Config) {
+		config.AesKey = key
+	}
+}
+
+func WithTraceId() Option {
+	return func(config *Config) {
+		config.TraceId = true
+	}
+}
+
+func WithAccessCode(accessCode AccessCode) Option {
+	return func(config *Config) {
+		config.AccessCode.Enable = accessCode.Enable
+		config.AccessCode.Header = auth.GetNonEmptyValueWithBackup(accessCode.Header, auth.DefaultHeaderAccessCode)
+		config.AccessCode.EncryptContent = accessCode.EncryptContent
+		config.AccessCode.Codes = accessCode.Codes
+	}
+}
+
+func WithRandomKey(randomKey RandomKey) Option {
+	return func(config *Config) {
+		config.RandomKey.Enable = randomKey.Enable
+		config.RandomKey.Header = auth.GetNonEmptyValueWithBackup(randomKey.Header, auth.DefaultHeaderRandomKey)
+	}
+}
+
+func WithUser(user User) Option {
+	return func(config *Config) {
+		config.User.Header = auth.GetNonEmptyValueWithBackup(user.Header, auth.DefaultHeaderUserToken)
+		config.User.HeaderSchema = auth.GetNonEmptyValueWithBackup(user.HeaderSchema, auth.DefaultHeaderSchema)
+	}
+}
+
+func WithClient(client Client) Option {
+	return func(config *Config) {
+		config.Client.Header = auth.GetNonEmptyValueWithBackup(client.Header, auth.DefaultHeaderClientToken)
+		config.Client.HeaderSchema = auth.GetNonEmptyValueWithBackup(client.HeaderSchema, auth.DefaultHeaderSchema)
+		config.Client.EncryptContent = client.EncryptContent
+	}
+}
+
+// NewServer 启动模拟鉴权服务，使用完毕后需调用Close
+func NewServer(options ...Option) *Server {
+	config := &Config{
+		AccessCode: AccessCode{Header: auth.DefaultHeaderAccessCode},
+		RandomKey:  RandomKey{Header: auth.DefaultHeaderRandomKey},
+		User: User{
+			Header:       auth.DefaultHeaderUserToken,
+			HeaderSchema: auth.DefaultHeaderSchema,
+		},
+		Client: Client{
+			Header:       auth.DefaultHeaderClientToken,
+			HeaderSchema: auth.DefaultHeaderSchema,
+		},
+	}
+	for _, opt := range options {
+		opt(config)
+	}
+	s := &Server{
+		config:   config,
+		aesUtil:  auth.NewAesUtil(config.AesKey),
+		users:    make(map[string]*user),
+		clients:  make(map[string]*client),
+		failures: make(map[string]int),
+	}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
@@ -0,0 +1,149 @@
+package authtest
+
+import (
+	"errors"
+	auth "github.com/Macrow/auth-go-sdk"
+	"net/http"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	server := NewServer(
+		WithAesKey("12345678-ABC-DEF"),
+		WithTraceId(),
+		WithRandomKey(RandomKey{Enable: true}),
+		WithClient(Client{EncryptContent: true}),
+	)
+	defer server.Close()
+	server.SetUser("token", auth.RawJwtUser{Id: "1", Name: "admin"})
+	server.SetCustomAuth("token", "custom")
+	server.GrantPerms("token", "order:read")
+	server.GrantAction("token", "orders", http.MethodGet, "/orders")
+	server.SetClient("svc", "secret", "order:write")
+
+	header := func(key string) string {
+		switch key {
+		case auth.DefaultHeaderUserToken:
+			return auth.DefaultHeaderSchema + " token"
+		case auth.DefaultHeaderRandomKey:
+			return "123456"
+		}
+		return ""
+	}
+	client := server.NewClient(auth.WithClientConfig(auth.Client{Id: "svc", Secret: "secret", EnableIdAndSecret: true, EncryptContent: true}))
+
+	authResult, err := client.CheckAuth(header, true)
+	if err != nil || authResult.User.Id != "1" || authResult.User.Token != "token" || authResult.CustomAuth != "custom" {
+		t.Fatal(authResult, err)
+	}
+	if _, err = client.CheckPermByCode(header, "order:read", true, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.CheckPermByCode(header, "order:write", true, false, false); !auth.IsDeniedError(err) {
+		t.Fatal(err)
+	}
+	if _, err = client.CheckPermByAction(header, "orders", http.MethodGet, "/orders", false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	perms, err := client.CheckPermsByCodes(header, []string{"order:read", "order:write"}, false, false)
+	if err != nil || !perms.Perms["order:read"] || perms.Perms["order:write"] {
+		t.Fatal(perms, err)
+	}
+	clientResult, err := client.CheckClientAuth(nil)
+	if err != nil || !clientResult.ClientAuthOk || clientResult.ClientId != "svc" {
+		t.Fatal(clientResult, err)
+	}
+	clientPerm, err := client.CheckClientPermByCode(nil, "order:write")
+	if err != nil || !clientPerm.ClientPermOk {
+		t.Fatal(clientPerm, err)
+	}
+
+	requests := server.Requests()
+	if len(requests) != 7 || requests[0].Path != auth.UrlPostCheckAuth || requests[0].Header.Get(auth.TraceId) == "" {
+		t.Fatal(requests)
+	}
+
+	if _, err = client.CheckAuth(func(key string) string {
+		if key == auth.DefaultHeaderRandomKey {
+			return ""
+		}
+		return header(key)
+	}, false); !errors.Is(err, auth.ErrRandomKeyEmpty) {
+		t.Fatal(err)
+	}
+
+	server.SetFailure(auth.UrlPostCheckAuth, http.StatusBadGateway)
+	if _, err = client.CheckAuth(header, false); !errors.Is(err, auth.ErrAuthServerFail) {
+		t.Fatal(err)
+	}
+}
+
+func TestServerForwardedClientToken(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetClient("caller", "secret")
+
+	client := server.NewClient(auth.WithClientConfig(auth.Client{Id: "self", Secret: "self-secret", EnableIdAndSecret: true}))
+	token, err := auth.GenerateClientToken("caller", "secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.CheckClientAuth(func(key string) string {
+		if key == auth.DefaultHeaderClientToken {
+			return auth.DefaultHeaderSchema + " " + token
+		}
+		return ""
+	})
+	if err != nil || result.ClientId != "caller" {
+		t.Fatal(result, err)
+	}
+}
+
+func TestServerNewClientConfig(t *testing.T) {
+	server := NewServer(
+		WithAesKey("12345678-ABC-DEF"),
+		WithAccessCode(AccessCode{Enable: true, EncryptContent: true, Codes: []string{"code"}}),
+		WithClient(Client{Header: "X-Client", HeaderSchema: "Token", EncryptContent: true}),
+	)
+	defer server.Close()
+	server.SetClient("svc", "secret", "order:write")
+
+	// 调用方只提供id和secret，请求头、加密方式和访问码来自服务端配置
+	client := server.NewClient(auth.WithClientConfig(auth.Client{Id: "svc", Secret: "secret", EnableIdAndSecret: true}))
+	result, err := client.CheckClientPermByCode(nil, "order:write")
+	if err != nil || !result.ClientPermOk {
+		t.Fatal(result, err)
+	}
+	requests := server.Requests()
+	if len(requests) != 1 || len(requests[0].Header.Get("X-Client")) == 0 || requests[0].Header.Get(auth.DefaultHeaderAccessCode) == "code" {
+		t.Fatal(requests)
+	}
+}
+
+func TestServerBatchFallbackWithRandomKey(t *testing.T) {
+	server := NewServer(WithRandomKey(RandomKey{Enable: true}))
+	defer server.Close()
+	server.SetUser("token", auth.RawJwtUser{Id: "1"})
+	server.GrantPerms("token", "order:read", "order:write")
+	server.SetFailure(auth.UrlPostCheckPermsByCodes, http.StatusNotFound)
+
+	header := func(key string) string {
+		switch key {
+		case auth.DefaultHeaderUserToken:
+			return auth.DefaultHeaderSchema + " token"
+		case auth.DefaultHeaderRandomKey:
+			return auth.GenerateRandomKey()
+		}
+		return ""
+	}
+	// 逐个检查会重复发送同一个随机码，因此不会改为逐个检查
+	client := server.NewClient()
+	if _, err := client.CheckPermsByCodes(header, []string{"order:read", "order:write"}, true, false); !errors.Is(err, auth.ErrBatchUnsupported) {
+		t.Fatal(err)
+	}
+	for _, r := range server.Requests() {
+		if r.Path != auth.UrlPostCheckPermsByCodes {
+			t.Fatal(r.Path)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryMinBackoff               = 100 * time.Millisecond
+	DefaultRetryMaxBackoff               = 2 * time.Second
+	MinRetryBackoff                      = 2 * time.Nanosecond // 退避抖动在[0, 间隔/2)内取随机数，间隔过小时会panic
+	DefaultCircuitBreakerThreshold       = 5
+	DefaultCircuitBreakerOpenTimeout     = 30 * time.Second
+	DefaultCircuitBreakerHalfOpenMaxCall = 1
+)
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker 连续失败达到阈值后熔断，熔断期间直接失败，超时后进入半开状态放行少量探测请求
+type circuitBreaker struct {
+	mu               sync.Mutex
+	state            CircuitState
+	failures         int
+	threshold        int
+	openTimeout      time.Duration
+	halfOpenMaxCalls int
+	halfOpenCalls    int
+	openedAt         time.Time
+	onStateChange    func(from, to CircuitState)
+	now              func() time.Time
+}
+
+func newCircuitBreaker(config CircuitBreaker, onStateChange func(from, to CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		state:            CircuitClosed,
+		threshold:        config.FailureThreshold,
+		openTimeout:      config.OpenTimeout,
+		halfOpenMaxCalls: config.HalfOpenMaxCalls,
+		onStateChange:    onStateChange,
+		now:              time.Now,
+	}
+}
+
+// reconfigure 重新加载配置时更新熔断参数，保留当前状态
+func (b *circuitBreaker) reconfigure(config CircuitBreaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = config.FailureThreshold
+	b.openTimeout = config.OpenTimeout
+	b.halfOpenMaxCalls = config.HalfOpenMaxCalls
+}
+
+func (b *circuitBreaker) State() CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow 判断是否放行本次请求，放行后必须调用record或release
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	allowed := true
+	var transition circuitTransition
+	switch b.state {
+	case CircuitOpen:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			allowed = false
+			break
+		}
+		transition = b.setState(CircuitHalfOpen)
+		b.halfOpenCalls = 1
+	case CircuitHalfOpen:
+		if b.halfOpenCalls >= b.halfOpenMaxCalls {
+			allowed = false
+			break
+		}
+		b.halfOpenCalls++
+	}
+	b.mu.Unlock()
+	b.notify(transition)
+	return allowed
+}
+
+func (b *circuitBreaker) record(success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	var transition circuitTransition
+	if success {
+		b.failures = 0
+		if b.state == CircuitHalfOpen {
+			transition = b.setState(CircuitClosed)
+		}
+	} else {
+		b.failures++
+		if b.state == CircuitHalfOpen || b.failures >= b.threshold {
+			b.openedAt = b.now()
+			transition = b.setState(CircuitOpen)
+		}
+	}
+	b.mu.Unlock()
+	b.notify(transition)
+}
+
+// release 归还未产生结论的请求（如调用方主动取消），不影响熔断统计
+func (b *circuitBreaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == CircuitHalfOpen && b.halfOpenCalls > 0 {
+		b.halfOpenCalls--
+	}
+}
+
+// circuitTransition 持有锁时发生的状态变化，释放锁之后再通知
+type circuitTransition struct {
+	from, to CircuitState
+	changed  bool
+}
+
+// setState 调用方需持有锁，并在释放锁之后将返回值传给notify
+func (b *circuitBreaker) setState(state CircuitState) circuitTransition {
+	if b.state == state {
+		return circuitTransition{}
+	}
+	from := b.state
+	b.state = state
+	b.halfOpenCalls = 0
+	if state == CircuitClosed {
+		b.failures = 0
+	}
+	return circuitTransition{from: from, to: state, changed: true}
+}
+
+// notify 在锁外调用回调，回调中可以调用State等需要加锁的方法
+func (b *circuitBreaker) notify(transition circuitTransition) {
+	if transition.changed && b.onStateChange != nil {
+		b.onStateChange(transition.from, transition.to)
+	}
+}
@@ -0,0 +1,263 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ConfigErrors 配置校验发现的全部问题，errors.Is(err, ErrInvalidConfig)为true
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return MsgInvalidConfig + ": " + strings.Join(messages, "; ")
+}
+
+func (e ConfigErrors) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// configProblems 收集校验问题，field为配置项在YAML中的路径
+type configProblems []error
+
+func (p *configProblems) add(field string, format string, args ...any) {
+	*p = append(*p, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+}
+
+func (p configProblems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return ConfigErrors(p)
+}
+
+// LoadHttpClientConfig 在默认配置的基础上，依次应用path指定的YAML或JSON文件和envPrefix开头的环境变量，
+// path或envPrefix为空时跳过对应来源，返回前调用Validate。
+// 环境变量名由前缀和各级YAML键转换为大写下划线形式组成，如AUTH_SERVICE_AUTH_SERVICE_BASE_URL、AUTH_USER_HEADER，
+// 时间使用5s、1m等形式，列表使用YAML或JSON
+func LoadHttpClientConfig(path string, envPrefix string) (*HttpClientConfig, error) {
+	config := defaultHttpClientConfig("", "", "")
+	if err := loadConfig(path, envPrefix, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadLocalAuthCheckerConfig 规则与LoadHttpClientConfig相同
+func LoadLocalAuthCheckerConfig(path string, envPrefix string) (*LocalAuthCheckerConfig, error) {
+	config := defaultLocalAuthCheckerConfig("")
+	if err := loadConfig(path, envPrefix, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadJwtUtilConfig 规则与LoadHttpClientConfig相同，秘钥使用PEM格式的字符串
+func LoadJwtUtilConfig(path string, envPrefix string) (*JwtUtilConfig, error) {
+	config := defaultJwtUtilConfig()
+	if err := loadConfig(path, envPrefix, config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func loadConfig(path string, envPrefix string, out any) error {
+	if len(path) > 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err = DecodeConfig(data, strings.TrimPrefix(filepath.Ext(path), "."), out); err != nil {
+			return err
+		}
+	}
+	if len(envPrefix) > 0 {
+		problems := configProblems{}
+		applyEnv(reflect.ValueOf(out).Elem(), strings.TrimSuffix(envPrefix, "_"), &problems)
+		return problems.err()
+	}
+	return nil
+}
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	pemBytesType = reflect.TypeOf(PemBytes(nil))
+)
+
+// applyEnv 按YAML键递归设置存在的环境变量，无法解析的值记录到problems
+func applyEnv(v reflect.Value, name string, problems *configProblems) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "-" || len(key) == 0 || !field.IsExported() {
+			continue
+		}
+		fieldName := name + "_" + envName(key)
+		fieldValue := v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			applyEnv(fieldValue, fieldName, problems)
+			continue
+		}
+		val, ok := os.LookupEnv(fieldName)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(fieldValue, val); err != nil {
+			problems.add(fieldName, "%s", err.Error())
+		}
+	}
+}
+
+func setEnvValue(v reflect.Value, val string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Type() == pemBytesType:
+		v.SetBytes([]byte(val))
+	case v.Kind() == reflect.String:
+		v.SetString(val)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Slice:
+		return DecodeConfig([]byte(val), ConfigFormatYaml, v.Addr().Interface())
+	default:
+		return fmt.Errorf("不支持的配置类型: %s", v.Type())
+	}
+	return nil
+}
+
+// parseJsonDurations 将JSON中对应time.Duration字段的字符串（如5s）转换为纳秒数，encoding/json只能解析纳秒数
+func parseJsonDurations(data []byte, t reflect.Type) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var raw any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	raw, err := convertJsonDurations(raw, t, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+func convertJsonDurations(v any, t reflect.Type, path string) (any, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == durationType:
+		s, ok := v.(string)
+		if !ok {
+			return v, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		return int64(d), nil
+	case t.Kind() == reflect.Struct:
+		if m, ok := v.(map[string]any); ok {
+			return m, convertJsonFields(m, t, path)
+		}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		if items, ok := v.([]any); ok {
+			for i, item := range items {
+				converted, err := convertJsonDurations(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				items[i] = converted
+			}
+		}
+	case t.Kind() == reflect.Map:
+		if m, ok := v.(map[string]any); ok {
+			for key, item := range m {
+				converted, err := convertJsonDurations(item, t.Elem(), path+"."+key)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = converted
+			}
+		}
+	}
+	return v, nil
+}
+
+// convertJsonFields 与encoding/json相同，按json标签（没有标签时按字段名）不区分大小写地匹配键，未加标签的嵌入结构体展开匹配
+func convertJsonFields(m map[string]any, t reflect.Type, path string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if len(name) == 0 && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := convertJsonFields(m, field.Type, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		for key, item := range m {
+			if !strings.EqualFold(key, name) {
+				continue
+			}
+			converted, err := convertJsonDurations(item, field.Type, strings.TrimPrefix(path+"."+key, "."))
+			if err != nil {
+				return err
+			}
+			m[key] = converted
+		}
+	}
+	return nil
+}
+
+// envName 将authServiceBaseUrl转换为AUTH_SERVICE_BASE_URL
+func envName(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if unicode.IsUpper(r) && i > 0 {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
@@ -0,0 +1,364 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-logr/logr"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReloadCloseDelay 重新加载后，旧RedisJwtUtil的redis连接在此时间后、且通过ReloadingRedisJwtUtil进行中的调用全部结束后关闭
+const DefaultReloadCloseDelay = time.Minute
+
+// ConfigBuilder 根据配置内容构建实例，current为当前实例，首次构建时为nil
+type ConfigBuilder[T any] func(ctx context.Context, data []byte, format string, current *T) (*T, error)
+
+// Reloader 从ConfigSource加载配置并构建实例，配置变化时重新构建后原子替换，
+// 新配置无法解析或构建失败时保留当前实例，相当于回滚到上一份有效配置
+type Reloader[T any] struct {
+	source   ConfigSource
+	build    ConfigBuilder[T]
+	current  atomic.Pointer[T]
+	lock     sync.Mutex
+	data     []byte
+	logger   logr.Logger
+	onReload func(err error)
+}
+
+// Current 返回当前实例，调用方不应长期持有，以便使用最新配置
+func (r *Reloader[T]) Current() *T {
+	return r.current.Load()
+}
+
+// Reload 立即加载配置，内容未变化时不重新构建
+func (r *Reloader[T]) Reload(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	data, err := r.source.Load(ctx)
+	if err == nil && r.current.Load() != nil && bytes.Equal(data, r.data) {
+		return nil
+	}
+	if err == nil && len(bytes.TrimSpace(data)) == 0 {
+		err = ErrConfigEmpty
+	}
+	var next *T
+	if err == nil {
+		next, err = r.build(ctx, data, r.source.Format(), r.current.Load())
+	}
+	if err != nil {
+		if r.current.Load() != nil {
+			r.logger.Error(err, "reload config failed, keep current config")
+		}
+		r.notify(err)
+		return err
+	}
+	r.current.Store(next)
+	r.data = data
+	r.logger.Info("config reloaded")
+	r.notify(nil)
+	return nil
+}
+
+// Watch 监听配置来源并在变化时重新加载，直到ctx结束，通常在单独的goroutine中调用
+func (r *Reloader[T]) Watch(ctx context.Context) error {
+	return r.source.Watch(ctx, func() {
+		_ = r.Reload(ctx)
+	})
+}
+
+func (r *Reloader[T]) notify(err error) {
+	if r.onReload != nil {
+		r.onReload(err)
+	}
+}
+
+// HttpClientBuilder 将配置内容解析为HttpClientConfig后创建HttpClient，
+// options在配置之后应用，用于设置日志、熔断回调等无法写在配置中的选项。
+// 鉴权服务地址未变化时沿用当前实例的熔断状态、鉴权结果缓存和批量接口探测状态，避免故障期间修改配置导致熔断被重置
+func HttpClientBuilder(options ...ClientOption) ConfigBuilder[HttpClient] {
+	return func(_ context.Context, data []byte, format string, current *HttpClient) (*HttpClient, error) {
+		config := defaultHttpClientConfig("", "", "")
+		if err := DecodeConfig(data, format, config); err != nil {
+			return nil, err
+		}
+		client, err := NewHttpClientWithConfig(config, options...)
+		if err != nil {
+			return nil, err
+		}
+		client.inheritState(current)
+		return client, nil
+	}
+}
+
+// inheritState 沿用previous的运行状态，熔断器沿用previous的状态回调，熔断参数使用新配置
+func (c *HttpClient) inheritState(previous *HttpClient) {
+	if previous == nil || previous.Config.AuthServiceBaseUrl != c.Config.AuthServiceBaseUrl {
+		return
+	}
+	if c.breaker != nil && previous.breaker != nil {
+		previous.breaker.reconfigure(c.Config.CircuitBreaker)
+		c.breaker = previous.breaker
+	}
+	if c.decisionCache != nil && previous.decisionCache != nil && c.Config.DecisionCache == previous.Config.DecisionCache {
+		c.decisionCache = previous.decisionCache
+	}
+	atomic.StoreInt64(&c.batchRouteMissingUntil, atomic.LoadInt64(&previous.batchRouteMissingUntil))
+}
+
+// LocalAuthCheckerBuilder 将配置内容解析为LocalAuthCheckerConfig后创建LocalAuthChecker，
+// 各数据源通过options传入，每次重新加载时复用
+func LocalAuthCheckerBuilder(options ...LocalCheckerOption) ConfigBuilder[LocalAuthChecker] {
+	return func(_ context.Context, data []byte, format string, _ *LocalAuthChecker) (*LocalAuthChecker, error) {
+		config := defaultLocalAuthCheckerConfig("")
+		if err := DecodeConfig(data, format, config); err != nil {
+			return nil, err
+		}
+		return NewLocalAuthCheckerWithConfig(config, options...)
+	}
+}
+
+// RedisJwtUtilBuilder 将配置内容解析为JwtUtilConfig后创建RedisJwtUtil，
+// redis配置未变化时复用当前的连接，否则在DefaultReloadCloseDelay后关闭旧连接。
+// 其他组件应通过ReloadingRedisJwtUtil使用，直接持有Current()返回的实例在关闭后将无法访问redis
+func RedisJwtUtilBuilder(options ...JwtUtilOption) ConfigBuilder[RedisJwtUtil] {
+	return func(ctx context.Context, data []byte, format string, current *RedisJwtUtil) (*RedisJwtUtil, error) {
+		config := defaultJwtUtilConfig()
+		if err := DecodeConfig(data, format, config); err != nil {
+			return nil, err
+		}
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+		reuse := current != nil && current.Config.Redis == config.Redis
+		redisOption := WithRedisConfig(config.Redis)
+		if reuse {
+			redisOption = withRedisClientsFrom(current)
+		}
+		util, err := NewRedisJwtUtilE(ctx, append([]JwtUtilOption{redisOption, WithJwtConfig(config.Jwt)}, options...)...)
+		if err != nil {
+			return nil, err
+		}
+		if !reuse {
+			util.redisRefs = &redisRefs{}
+		}
+		if current != nil && !reuse {
+			old, refs := current.UniversalClient(), current.redisRefs
+			time.AfterFunc(DefaultReloadCloseDelay, func() {
+				refs.closeWhenIdle(func() {
+					_ = old.Close()
+				})
+			})
+		}
+		return util, nil
+	}
+}
+
+// redisRefs 统计共用同一组redis连接的进行中调用，重新加载后旧连接等这些调用结束后才关闭
+type redisRefs struct {
+	mu      sync.Mutex
+	active  int
+	onIdle  func()
+	closing bool
+}
+
+func (r *redisRefs) acquire() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.active++
+	r.mu.Unlock()
+}
+
+func (r *redisRefs) release() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.active--
+	var onIdle func()
+	if r.active == 0 && r.onIdle != nil {
+		onIdle, r.onIdle = r.onIdle, nil
+	}
+	r.mu.Unlock()
+	if onIdle != nil {
+		onIdle()
+	}
+}
+
+// closeWhenIdle 没有进行中的调用时立即执行closeFunc，否则在最后一个调用结束时执行
+func (r *redisRefs) closeWhenIdle(closeFunc func()) {
+	if r == nil {
+		closeFunc()
+		return
+	}
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return
+	}
+	r.closing = true
+	if r.active > 0 {
+		r.onIdle = closeFunc
+		r.mu.Unlock()
+		return
+	}
+	r.mu.Unlock()
+	closeFunc()
+}
+
+// ReloadingHttpClient 每次调用都使用Reloader中最新的HttpClient，可直接传给中间件
+type ReloadingHttpClient struct {
+	*Reloader[HttpClient]
+}
+
+var _ IAuthClientCtx = ReloadingHttpClient{}
+
+func (c ReloadingHttpClient) IsPublicRoute(f GetHeaderFun) bool {
+	return c.Current().IsPublicRoute(f)
+}
+
+func (c ReloadingHttpClient) CheckAuth(f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.Current().CheckAuth(f, fulfillCustomAuth)
+}
+
+func (c ReloadingHttpClient) CheckPermByCode(f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.Current().CheckPermByCode(f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingHttpClient) CheckPermByAction(f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.Current().CheckPermByAction(f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingHttpClient) CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return c.Current().CheckClientAuth(f)
+}
+
+func (c ReloadingHttpClient) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return c.Current().CheckClientPermByCode(f, code)
+}
+
+func (c ReloadingHttpClient) CheckAuthCtx(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.Current().CheckAuthCtx(ctx, f, fulfillCustomAuth)
+}
+
+func (c ReloadingHttpClient) CheckPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.Current().CheckPermByCodeCtx(ctx, f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingHttpClient) CheckPermByActionCtx(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.Current().CheckPermByActionCtx(ctx, f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingHttpClient) CheckClientAuthCtx(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return c.Current().CheckClientAuthCtx(ctx, f)
+}
+
+func (c ReloadingHttpClient) CheckClientPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return c.Current().CheckClientPermByCodeCtx(ctx, f, code)
+}
+
+// ReloadingLocalAuthChecker 每次调用都使用Reloader中最新的LocalAuthChecker
+type ReloadingLocalAuthChecker struct {
+	*Reloader[LocalAuthChecker]
+}
+
+var _ IAuthCheck = ReloadingLocalAuthChecker{}
+
+func (c ReloadingLocalAuthChecker) IsPublicRoute(f GetHeaderFun) bool {
+	return c.Current().IsPublicRoute(f)
+}
+
+func (c ReloadingLocalAuthChecker) IsAccessCodeOk(ctx context.Context, code string) (bool, error) {
+	return c.Current().IsAccessCodeOk(ctx, code)
+}
+
+func (c ReloadingLocalAuthChecker) IsRandomKeyOk(ctx context.Context, key string) (bool, error) {
+	return c.Current().IsRandomKeyOk(ctx, key)
+}
+
+func (c ReloadingLocalAuthChecker) CheckAuth(ctx context.Context, userToken string, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.Current().CheckAuth(ctx, userToken, fulfillCustomAuth)
+}
+
+func (c ReloadingLocalAuthChecker) CheckPermByCode(ctx context.Context, userToken string, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.Current().CheckPermByCode(ctx, userToken, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingLocalAuthChecker) CheckPermByAction(ctx context.Context, userToken string, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (CheckPermResult, error) {
+	return c.Current().CheckPermByAction(ctx, userToken, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ReloadingLocalAuthChecker) CheckClientAuth(ctx context.Context, clientId string, clientSecret string) (*CheckClientAuthResult, error) {
+	return c.Current().CheckClientAuth(ctx, clientId, clientSecret)
+}
+
+func (c ReloadingLocalAuthChecker) CheckClientPermByCode(ctx context.Context, clientId string, clientSecret string, code string) (*CheckClientPermResult, error) {
+	return c.Current().CheckClientPermByCode(ctx, clientId, clientSecret, code)
+}
+
+// ReloadingRedisJwtUtil 每次调用都使用Reloader中最新的RedisJwtUtil，可传给WithJwtSessionValidator、
+// WithAccessCodeStore等选项，重新加载后LocalAuthChecker使用新的秘钥和redis连接
+type ReloadingRedisJwtUtil struct {
+	*Reloader[RedisJwtUtil]
+}
+
+var (
+	_ JwtSessionValidator = ReloadingRedisJwtUtil{}
+	_ JwtClaimsValidator  = ReloadingRedisJwtUtil{}
+	_ AccessCodeStore     = ReloadingRedisJwtUtil{}
+	_ RandomKeyStore      = ReloadingRedisJwtUtil{}
+)
+
+// acquire 返回最新的实例，调用结束前其redis连接不会被关闭
+func (u ReloadingRedisJwtUtil) acquire() (*RedisJwtUtil, func()) {
+	util := u.Current()
+	util.redisRefs.acquire()
+	return util, util.redisRefs.release
+}
+
+func (u ReloadingRedisJwtUtil) ValidateJwt(tokenString string) (*JwtUser, error) {
+	return u.Current().ValidateJwt(tokenString)
+}
+
+func (u ReloadingRedisJwtUtil) ValidateJwtClaims(tokenString string) (map[string]any, error) {
+	return u.Current().ValidateJwtClaims(tokenString)
+}
+
+func (u ReloadingRedisJwtUtil) IsJwtInCache(ctx context.Context, jwtUser *JwtUser) (bool, error) {
+	util, release := u.acquire()
+	defer release()
+	return util.IsJwtInCache(ctx, jwtUser)
+}
+
+func (u ReloadingRedisJwtUtil) IssueAccessCode(ctx context.Context, spec AccessCodeSpec) (*AccessCodeRecord, error) {
+	util, release := u.acquire()
+	defer release()
+	return util.IssueAccessCode(ctx, spec)
+}
+
+func (u ReloadingRedisJwtUtil) ConsumeAccessCode(ctx context.Context, code string) (*AccessCodeRecord, error) {
+	util, release := u.acquire()
+	defer release()
+	return util.ConsumeAccessCode(ctx, code)
+}
+
+func (u ReloadingRedisJwtUtil) RevokeAccessCode(ctx context.Context, code string) error {
+	util, release := u.acquire()
+	defer release()
+	return util.RevokeAccessCode(ctx, code)
+}
+
+func (u ReloadingRedisJwtUtil) ListAccessCodes(ctx context.Context, filter AccessCodeFilter) ([]*AccessCodeRecord, error) {
+	util, release := u.acquire()
+	defer release()
+	return util.ListAccessCodes(ctx, filter)
+}
+
+func (u ReloadingRedisJwtUtil) RememberRandomKey(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	util, release := u.acquire()
+	defer release()
+	return util.RememberRandomKey(ctx, key, ttl)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"github.com/go-logr/logr"
+)
+
+type reloaderOptions struct {
+	logger   logr.Logger
+	onReload func(err error)
+}
+
+type ReloaderOption func(options *reloaderOptions)
+
+func WithReloaderLogger(logger logr.Logger) ReloaderOption {
+	return func(options *reloaderOptions) {
+		options.logger = logger
+	}
+}
+
+// WithReloadCallback 每次加载新配置后调用，err为nil表示已替换为新实例，否则表示仍在使用原实例
+func WithReloadCallback(onReload func(err error)) ReloaderOption {
+	return func(options *reloaderOptions) {
+		options.onReload = onReload
+	}
+}
+
+// NewReloader 首次加载配置失败时返回错误，之后需调用Watch监听配置变化
+func NewReloader[T any](ctx context.Context, source ConfigSource, build ConfigBuilder[T], options ...ReloaderOption) (*Reloader[T], error) {
+	o := &reloaderOptions{}
+	for _, opt := range options {
+		opt(o)
+	}
+	if o.logger.GetSink() == nil {
+		o.logger = logr.Discard()
+	}
+	r := &Reloader[T]{source: source, build: build, logger: o.logger, onReload: o.onReload}
+	if err := r.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	ConfigFormatJson          = "json"
+	ConfigFormatYaml          = "yaml"
+	DefaultConfigPollInterval = 10 * time.Second
+	configMapDataLink         = "..data" // Kubernetes挂载ConfigMap时，更新配置会替换该符号链接
+	fileWatchDebounce         = 100 * time.Millisecond
+)
+
+// ConfigSource 可热加载的配置来源
+type ConfigSource interface {
+	// Load 读取当前的配置内容
+	Load(ctx context.Context) ([]byte, error)
+	// Format 配置内容的格式，ConfigFormatJson或ConfigFormatYaml
+	Format() string
+	// Watch 配置可能发生变化时调用notify，直到ctx结束，只有无法开始监听时才返回错误
+	Watch(ctx context.Context, notify func()) error
+}
+
+var (
+	_ ConfigSource = (*FileConfigSource)(nil)
+	_ ConfigSource = (*EnvConfigSource)(nil)
+	_ ConfigSource = (*RedisConfigSource)(nil)
+)
+
+// FileConfigSource 按扩展名识别格式的配置文件，通过fsnotify监听所在目录，支持编辑器的重命名写入和ConfigMap挂载
+type FileConfigSource struct {
+	Path string
+}
+
+func (s *FileConfigSource) Load(_ context.Context) ([]byte, error) {
+	return os.ReadFile(s.Path)
+}
+
+func (s *FileConfigSource) Format() string {
+	return strings.TrimPrefix(filepath.Ext(s.Path), ".")
+}
+
+func (s *FileConfigSource) Watch(ctx context.Context, notify func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	path := filepath.Clean(s.Path)
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		return err
+	}
+	// 一次保存通常产生多个事件，合并后再通知，避免读到写了一半的文件
+	var debounce *time.Timer
+	defer func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}()
+	changed := func() {
+		if debounce == nil {
+			debounce = time.AfterFunc(fileWatchDebounce, notify)
+		} else {
+			debounce.Reset(fileWatchDebounce)
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == path || filepath.Base(event.Name) == configMapDataLink {
+				changed()
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// 事件可能已丢失，重新加载一次
+			changed()
+		}
+	}
+}
+
+// EnvConfigSource 从环境变量读取整份配置，按Interval轮询，ConfigFormat为空时按YAML解析（兼容JSON）
+type EnvConfigSource struct {
+	Name         string
+	ConfigFormat string
+	Interval     time.Duration
+}
+
+func (s *EnvConfigSource) Load(_ context.Context) ([]byte, error) {
+	val := os.Getenv(s.Name)
+	if len(val) == 0 {
+		return nil, fmt.Errorf("%w: 环境变量%s为空", ErrConfigEmpty, s.Name)
+	}
+	return []byte(val), nil
+}
+
+func (s *EnvConfigSource) Format() string {
+	return GetNonEmptyValueWithBackup(s.ConfigFormat, ConfigFormatYaml)
+}
+
+func (s *EnvConfigSource) Watch(ctx context.Context, notify func()) error {
+	return pollConfig(ctx, s.Interval, notify)
+}
+
+// RedisConfigSource 从redis的字符串键读取整份配置，按Interval轮询，ConfigFormat为空时按YAML解析（兼容JSON）
+type RedisConfigSource struct {
+	Client       redis.UniversalClient
+	Key          string
+	ConfigFormat string
+	Interval     time.Duration
+}
+
+func (s *RedisConfigSource) Load(ctx context.Context) ([]byte, error) {
+	data, err := s.Client.Get(ctx, s.Key).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: redis键%s不存在", ErrConfigEmpty, s.Key)
+	}
+	return data, err
+}
+
+func (s *RedisConfigSource) Format() string {
+	return GetNonEmptyValueWithBackup(s.ConfigFormat, ConfigFormatYaml)
+}
+
+func (s *RedisConfigSource) Watch(ctx context.Context, notify func()) error {
+	return pollConfig(ctx, s.Interval, notify)
+}
+
+// pollConfig 定时通知，由Reloader比较内容是否变化
+func pollConfig(ctx context.Context, interval time.Duration, notify func()) error {
+	if interval <= 0 {
+		interval = DefaultConfigPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			notify()
+		}
+	}
+}
+
+// DecodeConfig 按格式将配置内容解析到out，时间在YAML和JSON中都可以使用5s、1m等形式，JSON中也可以使用纳秒数
+func DecodeConfig(data []byte, format string, out any) error {
+	var err error
+	switch strings.ToLower(format) {
+	case ConfigFormatJson:
+		data, err = parseJsonDurations(data, reflect.TypeOf(out))
+		if err == nil {
+			err = json.Unmarshal(data, out)
+		}
+	case ConfigFormatYaml, "yml":
+		err = yaml.Unmarshal(data, out)
+	default:
+		return fmt.Errorf("%w: 不支持的配置格式: %s", ErrInvalidConfig, format)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, err.Error())
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ const (
 	CodeSuccess              = 0
 	DefaultCachePrefix       = "Jwt"
 	DefaultCacheSplitter     = "::"
+	AccessCodeCachePrefix    = "AccessCode"
+	RefreshTokenCachePrefix  = "RefreshToken"
 	DefaultIssuer            = "auth-go-sdk"
 	DefaultHeaderRandomKey   = "Random-Key"
 	DefaultHeaderAccessCode  = "Access-Code"
@@ -11,6 +13,9 @@ const (
 	DefaultHeaderClientToken = "HttpClient-Authorization"
 	DefaultHeaderSchema      = "Bearer"
 	DefaultMetaBy            = "id"
+	DefaultBatchConcurrency  = 8
+
+	DefaultRefreshExpireInMinutes = 30 * 24 * 60
 
 	JwtTokenClaimsId          = "id"
 	JwtTokenClaimsName        = "name"
@@ -19,14 +24,22 @@ const (
 	JwtTokenClaimsIssuer      = "iss"
 	JwtTokenClaimsIssueAt     = "iat"
 	JwtTokenClaimsExpireAt    = "exp"
+	JwtTokenClaimsType        = "typ"
+	JwtTokenClaimsFamily      = "fid"
+	JwtTokenClaimsTokenId     = "jti"
+	JwtTokenTypeRefresh       = "refresh"
+	JwtTokenHeaderKeyId       = "kid"
 	ClientIdAndSecretSplitter = "@"
 	DidAndIatJoiner           = "-"
 
 	UrlPostCheckAuth             = "/current/jwt"
 	UrlPostCheckPermByCode       = "/current/check-operation"
 	UrlPostCheckPermByAction     = "/current/check-action"
+	UrlPostCheckPermsByCodes     = "/current/check-operations"
+	UrlPostCheckPermsByActions   = "/current/check-actions"
 	UrlPostCheckClientAuth       = "/client/validate"
 	UrlPostCheckClientPermByCode = "/client/check-operation"
+	UrlGetJwtPublicKey           = "/jwt/public-key"
 
 	KeySkipAuthCheck = "__SkipAuthCheck__"
 	KeyJwtUser       = "__JwtUser__"
@@ -36,4 +49,15 @@ const (
 	KeyMetaBy        = "__MetaBy__"
 
 	TraceId = "request-trace-id"
+
+	QueryPage     = "page"
+	QueryPageSize = "pageSize"
+)
+
+// 随机码
+const (
+	RandomKeyCachePrefix     = "RandomKey"
+	RandomKeyTimestampJoiner = "."
+	RandomKeyBytes           = 16
+	RandomKeyMaxLength       = 128
 )
@@ -15,6 +15,8 @@ type IAuthCheck interface {
 	CheckClientPermByCode(ctx context.Context, clientId string, clientSecret string, code string) (*CheckClientPermResult, error)
 }
 
+var _ IAuthCheck = (*LocalAuthChecker)(nil)
+
 type GetHeaderFun = func(key string) string
 
 // IAuthClient 实现远程调用验证，所有方法都不抛出异常，如果权限检查失败，jwtUser返回nil
@@ -25,3 +27,20 @@ type IAuthClient interface {
 	CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, error)
 	CheckClientPermByCode(f GetHeaderFun, code string) (*CheckClientPermResult, error)
 }
+
+// IAuthClientCtx 在IAuthClient的基础上增加带context.Context的方法，调用方可通过类型断言判断是否支持，
+// 带Ctx后缀的方法会将ctx的取消和截止时间传递给远程调用，超时返回context.DeadlineExceeded
+type IAuthClientCtx interface {
+	IAuthClient
+
+	CheckAuthCtx(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error)
+	CheckPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error)
+	CheckPermByActionCtx(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error)
+	CheckClientAuthCtx(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error)
+	CheckClientPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error)
+}
+
+var (
+	_ IAuthClientCtx = (*HttpClient)(nil)
+	_ IAuthClientCtx = localAuthClient{}
+)
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultDecisionCacheCapacity    = 10000
+	DefaultDecisionCacheTTL         = 30 * time.Second
+	DefaultDecisionCacheNegativeTTL = 5 * time.Second
+)
+
+type DecisionCacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Size   int
+}
+
+type decisionEntry struct {
+	key      string
+	tokenKey string
+	value    any
+	err      error
+	expireAt time.Time
+}
+
+// decisionCache 缓存远程鉴权结果，按LRU淘汰，每条记录带过期时间，拒绝结果使用更短的过期时间
+type decisionCache struct {
+	mu          sync.Mutex
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	lru         *list.List
+	items       map[string]*list.Element
+	byToken     map[string]map[string]struct{}
+	hits        uint64
+	misses      uint64
+	now         func() time.Time
+}
+
+func newDecisionCache(config DecisionCache) *decisionCache {
+	return &decisionCache{
+		capacity:    config.Capacity,
+		ttl:         config.TTL,
+		negativeTTL: config.NegativeTTL,
+		lru:         list.New(),
+		items:       make(map[string]*list.Element),
+		byToken:     make(map[string]map[string]struct{}),
+		now:         time.Now,
+	}
+}
+
+func hashDecisionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// decisionCacheKey 由令牌哈希、接口地址和请求参数组成，令牌为空时返回空字符串表示不缓存
+func decisionCacheKey(token string, url string, params ...string) (key string, tokenKey string) {
+	if len(token) == 0 {
+		return "", ""
+	}
+	tokenKey = hashDecisionToken(token)
+	return tokenKey + "|" + url + "|" + strings.Join(params, "|"), tokenKey
+}
+
+func (d *decisionCache) get(key string) (any, error, bool) {
+	if d == nil || len(key) == 0 {
+		return nil, nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	elem, ok := d.items[key]
+	if !ok {
+		atomic.AddUint64(&d.misses, 1)
+		return nil, nil, false
+	}
+	entry := elem.Value.(*decisionEntry)
+	if !d.now().Before(entry.expireAt) {
+		d.removeElement(elem)
+		atomic.AddUint64(&d.misses, 1)
+		return nil, nil, false
+	}
+	d.lru.MoveToFront(elem)
+	atomic.AddUint64(&d.hits, 1)
+	return cloneDecision(entry.value), entry.err, true
+}
+
+// put 缓存通过的鉴权结果，过期时间不会超过令牌本身的exp
+func (d *decisionCache) put(key string, tokenKey string, value any, user *JwtUser) {
+	if d == nil || len(key) == 0 {
+		return
+	}
+	now := d.now()
+	expireAt := now.Add(d.ttl)
+	if user != nil && user.Exp > 0 {
+		jwtExpireAt := time.Unix(int64(user.Exp), 0)
+		if jwtExpireAt.Before(expireAt) {
+			expireAt = jwtExpireAt
+		}
+	}
+	if !expireAt.After(now) {
+		return
+	}
+	d.set(&decisionEntry{key: key, tokenKey: tokenKey, value: cloneDecision(value), expireAt: expireAt})
+}
+
+// putDenied 缓存鉴权服务明确拒绝的结果，网络错误等不会被缓存
+func (d *decisionCache) putDenied(key string, tokenKey string, err error) {
+	if d == nil || len(key) == 0 || d.negativeTTL <= 0 || !IsDeniedError(err) {
+		return
+	}
+	d.set(&decisionEntry{key: key, tokenKey: tokenKey, err: err, expireAt: d.now().Add(d.negativeTTL)})
+}
+
+func (d *decisionCache) set(entry *decisionEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.items[entry.key]; ok {
+		elem.Value = entry
+		d.lru.MoveToFront(elem)
+		return
+	}
+	d.items[entry.key] = d.lru.PushFront(entry)
+	keys, ok := d.byToken[entry.tokenKey]
+	if !ok {
+		keys = make(map[string]struct{})
+		d.byToken[entry.tokenKey] = keys
+	}
+	keys[entry.key] = struct{}{}
+	for d.capacity > 0 && d.lru.Len() > d.capacity {
+		d.removeElement(d.lru.Back())
+	}
+}
+
+func (d *decisionCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*decisionEntry)
+	d.lru.Remove(elem)
+	delete(d.items, entry.key)
+	if keys, ok := d.byToken[entry.tokenKey]; ok {
+		delete(keys, entry.key)
+		if len(keys) == 0 {
+			delete(d.byToken, entry.tokenKey)
+		}
+	}
+}
+
+func (d *decisionCache) invalidateToken(token string) {
+	if d == nil || len(token) == 0 {
+		return
+	}
+	tokenKey := hashDecisionToken(token)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.byToken[tokenKey] {
+		if elem, ok := d.items[key]; ok {
+			d.removeElement(elem)
+		}
+	}
+}
+
+func (d *decisionCache) stats() DecisionCacheStats {
+	if d == nil {
+		return DecisionCacheStats{}
+	}
+	d.mu.Lock()
+	size := d.lru.Len()
+	d.mu.Unlock()
+	return DecisionCacheStats{
+		Hits:   atomic.LoadUint64(&d.hits),
+		Misses: atomic.LoadUint64(&d.misses),
+		Size:   size,
+	}
+}
+
+func cloneJwtUser(user *JwtUser) *JwtUser {
+	if user == nil {
+		return nil
+	}
+	clone := *user
+	return &clone
+}
+
+// cloneDecision 复制缓存的结果，避免调用方修改缓存内容
+func cloneDecision(value any) any {
+	switch v := value.(type) {
+	case *CheckAuthResult:
+		clone := *v
+		clone.User = cloneJwtUser(v.User)
+		return &clone
+	case *CheckPermResult:
+		clone := *v
+		clone.User = cloneJwtUser(v.User)
+		return &clone
+	default:
+		return value
+	}
+}
@@ -3,43 +3,161 @@ package auth
 import "errors"
 
 const (
-	MsgInternalError         = "服务内部错误"
-	MsgAuthServerFail        = "访问鉴权服务失败"
-	MsgAccessCodeEmpty       = "未提供访问码"
-	MsgRandomKeyEmpty        = "未提供随机码"
-	MsgUserTokenEmpty        = "未提供用户令牌"
-	MsgClientTokenEmpty      = "未提供客户端令牌"
-	MsgClientIdOrSecretEmpty = "未提供客户端Id和秘钥"
-	MsgClientTokenFail       = "客户端验证失败"
-	MsgJwtErrFormat          = "令牌格式错误"
-	MsgJwtErrVersion         = "令牌版本错误"
-	MsgNoResult              = "解析返回结果错误"
-	MsgRateLimit             = "访问过于频繁"
-	MsgAuthFail              = "身份验证失败"
-	MsgPermFail              = "权限验证失败"
-	MsgAESKeyError           = "加密key必须为16位"
-	MsgEncryptFail           = "加密身份信息失败"
-	MsgDecryptFail           = "身份信息校验失败"
-	MsgEmptyContent          = "加解密内容为空"
+	MsgInternalError          = "服务内部错误"
+	MsgAuthServerFail         = "访问鉴权服务失败"
+	MsgAuthServiceUnavailable = "鉴权服务暂不可用"
+	MsgAccessCodeEmpty        = "未提供访问码"
+	MsgAccessCodeFail         = "访问码无效"
+	MsgAccessCodeOwnerEmpty   = "访问码未绑定客户端或用户"
+	MsgRandomKeyEmpty         = "未提供随机码"
+	MsgRandomKeyFail          = "随机码无效"
+	MsgUserTokenEmpty         = "未提供用户令牌"
+	MsgClientTokenEmpty       = "未提供客户端令牌"
+	MsgClientIdOrSecretEmpty  = "未提供客户端Id和秘钥"
+	MsgClientTokenFail        = "客户端验证失败"
+	MsgJwtErrFormat           = "令牌格式错误"
+	MsgJwtErrVersion          = "令牌版本错误"
+	MsgJwtAlgorithm           = "不支持的令牌签名算法"
+	MsgJwtNoSigningKey        = "没有可用的令牌签名秘钥"
+	MsgRefreshTokenFail       = "刷新令牌无效"
+	MsgRefreshTokenReused     = "刷新令牌已被使用"
+	MsgNoResult               = "解析返回结果错误"
+	MsgRateLimit              = "访问过于频繁"
+	MsgAuthFail               = "身份验证失败"
+	MsgPermFail               = "权限验证失败"
+	MsgAESKeyError            = "加密key必须为16位"
+	MsgEncryptFail            = "加密身份信息失败"
+	MsgDecryptFail            = "身份信息校验失败"
+	MsgEmptyContent           = "加解密内容为空"
+	MsgRequestBodyConflict    = "请求体不能同时为JSON和表单"
+	MsgProviderNotConfigured  = "未配置本地鉴权数据源"
+	MsgConfigEmpty            = "配置内容为空"
+	MsgInvalidConfig          = "配置错误"
+	MsgBatchUnsupported       = "鉴权服务不支持批量检查"
 )
 
 var (
-	ErrInternalError         = errors.New(MsgInternalError)
-	ErrAuthServerFail        = errors.New(MsgAuthServerFail)
-	ErrAccessCodeEmpty       = errors.New(MsgAccessCodeEmpty)
-	ErrRandomKeyEmpty        = errors.New(MsgRandomKeyEmpty)
-	ErrUserTokenEmpty        = errors.New(MsgUserTokenEmpty)
-	ErrClientTokenEmpty      = errors.New(MsgClientTokenEmpty)
-	ErrClientIdOrSecretEmpty = errors.New(MsgClientIdOrSecretEmpty)
-	ErrClientTokenFail       = errors.New(MsgClientTokenFail)
-	ErrJwtErrFormat          = errors.New(MsgJwtErrFormat)
-	ErrJwtErrVersion         = errors.New(MsgJwtErrVersion)
-	ErrNoResult              = errors.New(MsgNoResult)
-	ErrRateLimit             = errors.New(MsgRateLimit)
-	ErrAuthFail              = errors.New(MsgAuthFail)
-	ErrPermFail              = errors.New(MsgPermFail)
-	ErrAESKeyFail            = errors.New(MsgAESKeyError)
-	ErrEncryptFail           = errors.New(MsgEncryptFail)
-	ErrDecryptFail           = errors.New(MsgDecryptFail)
-	ErrEmptyContent          = errors.New(MsgEmptyContent)
+	ErrInternalError          = errors.New(MsgInternalError)
+	ErrAuthServerFail         = errors.New(MsgAuthServerFail)
+	ErrAuthServiceUnavailable = errors.New(MsgAuthServiceUnavailable)
+	ErrAccessCodeEmpty        = errors.New(MsgAccessCodeEmpty)
+	ErrAccessCodeFail         = errors.New(MsgAccessCodeFail)
+	ErrAccessCodeOwnerEmpty   = errors.New(MsgAccessCodeOwnerEmpty)
+	ErrRandomKeyEmpty         = errors.New(MsgRandomKeyEmpty)
+	ErrRandomKeyFail          = errors.New(MsgRandomKeyFail)
+	ErrUserTokenEmpty         = errors.New(MsgUserTokenEmpty)
+	ErrClientTokenEmpty       = errors.New(MsgClientTokenEmpty)
+	ErrClientIdOrSecretEmpty  = errors.New(MsgClientIdOrSecretEmpty)
+	ErrClientTokenFail        = errors.New(MsgClientTokenFail)
+	ErrJwtErrFormat           = errors.New(MsgJwtErrFormat)
+	ErrJwtErrVersion          = errors.New(MsgJwtErrVersion)
+	ErrJwtAlgorithm           = errors.New(MsgJwtAlgorithm)
+	ErrJwtNoSigningKey        = errors.New(MsgJwtNoSigningKey)
+	ErrRefreshTokenFail       = errors.New(MsgRefreshTokenFail)
+	ErrRefreshTokenReused     = errors.New(MsgRefreshTokenReused)
+	ErrNoResult               = errors.New(MsgNoResult)
+	ErrRateLimit              = errors.New(MsgRateLimit)
+	ErrAuthFail               = errors.New(MsgAuthFail)
+	ErrPermFail               = errors.New(MsgPermFail)
+	ErrAESKeyFail             = errors.New(MsgAESKeyError)
+	ErrEncryptFail            = errors.New(MsgEncryptFail)
+	ErrDecryptFail            = errors.New(MsgDecryptFail)
+	ErrEmptyContent           = errors.New(MsgEmptyContent)
+	ErrRequestBodyConflict    = errors.New(MsgRequestBodyConflict)
+	ErrProviderNotConfigured  = errors.New(MsgProviderNotConfigured)
+	ErrConfigEmpty            = errors.New(MsgConfigEmpty)
+	ErrInvalidConfig          = errors.New(MsgInvalidConfig)
+	ErrBatchUnsupported       = errors.New(MsgBatchUnsupported)
 )
+
+// 鉴权服务返回的业务码，与哨兵错误的对应关系见ServiceCodeErrors
+const (
+	CodeAuthFail           = 401
+	CodePermFail           = 403
+	CodeRateLimit          = 429
+	CodeInternalError      = 500
+	CodeServiceUnavailable = 503
+)
+
+// ServiceCodeErrors 鉴权服务业务码与哨兵错误的对应关系：
+//
+//	401 ErrAuthFail               身份验证失败（令牌无效、过期或已注销）
+//	403 ErrPermFail               权限验证失败
+//	429 ErrRateLimit              访问过于频繁
+//	500 ErrInternalError          鉴权服务内部错误
+//	503 ErrAuthServiceUnavailable 鉴权服务暂不可用
+//
+// 未登记的业务码按返回的Message匹配Msg*常量，仍无法匹配时AuthError.Err为nil
+var ServiceCodeErrors = map[int]error{
+	CodeAuthFail:           ErrAuthFail,
+	CodePermFail:           ErrPermFail,
+	CodeRateLimit:          ErrRateLimit,
+	CodeInternalError:      ErrInternalError,
+	CodeServiceUnavailable: ErrAuthServiceUnavailable,
+}
+
+var serviceMessageErrors = map[string]error{
+	MsgInternalError:          ErrInternalError,
+	MsgAuthServerFail:         ErrAuthServerFail,
+	MsgAuthServiceUnavailable: ErrAuthServiceUnavailable,
+	MsgAccessCodeEmpty:        ErrAccessCodeEmpty,
+	MsgAccessCodeFail:         ErrAccessCodeFail,
+	MsgRandomKeyEmpty:         ErrRandomKeyEmpty,
+	MsgRandomKeyFail:          ErrRandomKeyFail,
+	MsgUserTokenEmpty:         ErrUserTokenEmpty,
+	MsgClientTokenEmpty:       ErrClientTokenEmpty,
+	MsgClientIdOrSecretEmpty:  ErrClientIdOrSecretEmpty,
+	MsgClientTokenFail:        ErrClientTokenFail,
+	MsgJwtErrFormat:           ErrJwtErrFormat,
+	MsgJwtErrVersion:          ErrJwtErrVersion,
+	MsgRateLimit:              ErrRateLimit,
+	MsgAuthFail:               ErrAuthFail,
+	MsgPermFail:               ErrPermFail,
+	MsgDecryptFail:            ErrDecryptFail,
+}
+
+// AuthError 访问鉴权服务失败的详细信息，可通过errors.Is判断对应的哨兵错误，通过errors.As获取业务码和追踪id
+type AuthError struct {
+	Code       int    // 鉴权服务返回的业务码，请求未得到业务响应时为CodeSuccess
+	Message    string // 鉴权服务返回的错误信息
+	HttpStatus int    // HTTP状态码
+	TraceId    string // 请求追踪id
+	Err        error  // 对应的哨兵错误
+}
+
+func newServiceError(code int, message string, httpStatus int, traceId string) *AuthError {
+	err, ok := ServiceCodeErrors[code]
+	if !ok {
+		err = serviceMessageErrors[message]
+	}
+	return &AuthError{Code: code, Message: message, HttpStatus: httpStatus, TraceId: traceId, Err: err}
+}
+
+func (e *AuthError) Error() string {
+	if len(e.Message) == 0 && e.Err != nil {
+		return e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// Denied 鉴权服务是否明确拒绝了本次请求，业务码500、503等服务故障不属于拒绝
+func (e *AuthError) Denied() bool {
+	switch e.Code {
+	case CodeAuthFail, CodePermFail, CodeRateLimit:
+		return true
+	case CodeSuccess, CodeInternalError, CodeServiceUnavailable:
+		return false
+	}
+	// 未登记的业务码按Message对应的哨兵错误判断
+	return e.Err != nil && e.Err != ErrInternalError && e.Err != ErrAuthServerFail && e.Err != ErrAuthServiceUnavailable
+}
+
+// IsDeniedError 判断错误是否为鉴权服务的明确拒绝（令牌无效、权限不足等），而非网络或服务故障
+func IsDeniedError(err error) bool {
+	var e *AuthError
+	return errors.As(err, &e) && e.Denied()
+}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"github.com/go-logr/logr"
 	"github.com/google/uuid"
@@ -10,10 +11,19 @@ import (
 )
 
 type HttpClient struct {
-	Config  *HttpClientConfig
-	Agent   *req.Client
-	AesUtil *AesUtil
-	logger  logr.Logger
+	Config        *HttpClientConfig
+	Agent         *req.Client
+	AesUtil       *AesUtil
+	logger        logr.Logger
+	decisionCache *decisionCache
+	breaker       *circuitBreaker
+	public        *publicRoutes
+	// jwtPreValidator 启用令牌预校验时非空
+	jwtPreValidator *jwtPreValidator
+	// optionErrors 选项遇到的配置错误，NewHttpClientE返回这些错误，NewHttpClient则panic
+	optionErrors configProblems
+	// batchRouteMissingUntil 鉴权服务不支持批量检查接口时记录重新探测的时间（UnixNano），此前直接逐个检查
+	batchRouteMissingUntil int64
 }
 
 func handleError[T Result](res *req.Response, result *HttpResponse[T], logger logr.Logger, validateResultIsNull bool) error {
@@ -23,18 +33,26 @@ func handleError[T Result](res *req.Response, result *HttpResponse[T], logger lo
 	}
 	if res.Err != nil {
 		logger.Error(res.Err, res.Err.Error())
+		// 区分调用方取消/超时与鉴权服务故障
+		if errors.Is(res.Err, context.DeadlineExceeded) {
+			return context.DeadlineExceeded
+		}
+		if errors.Is(res.Err, context.Canceled) {
+			return context.Canceled
+		}
 		return res.Err
 	}
 	if res.StatusCode != http.StatusOK {
-		logger.Error(ErrAuthServerFail, ErrAuthServerFail.Error())
-		return ErrAuthServerFail
+		err := &AuthError{Message: MsgAuthServerFail, HttpStatus: res.StatusCode, TraceId: traceIdOf(res), Err: ErrAuthServerFail}
+		logger.Error(err, err.Error(), "status", res.StatusCode, "traceId", err.TraceId)
+		return err
 	}
 	if result == nil {
 		logger.Error(ErrNoResult, ErrNoResult.Error())
 		return ErrNoResult
 	}
 	if result.Code != CodeSuccess {
-		return errors.New(result.Message)
+		return newServiceError(result.Code, result.Message, res.StatusCode, traceIdOf(res))
 	}
 	if validateResultIsNull && result.Result == nil {
 		return ErrNoResult
@@ -42,6 +60,72 @@ func handleError[T Result](res *req.Response, result *HttpResponse[T], logger lo
 	return nil
 }
 
+func traceIdOf(res *req.Response) string {
+	if res.Request != nil && res.Request.Headers != nil {
+		if traceId := res.Request.Headers.Get(TraceId); len(traceId) > 0 {
+			return traceId
+		}
+	}
+	if res.Response != nil {
+		return res.Header.Get(TraceId)
+	}
+	return ""
+}
+
+// do 发送请求，ctx的取消和截止时间会传递到底层连接，未设置截止时间时使用配置的默认超时
+// idempotent为true的请求在网络错误或服务端5xx时按配置重试，熔断期间直接返回ErrAuthServiceUnavailable。
+// 启用随机码时不重试，重试会重复发送同一个随机码，被鉴权服务当作重放拒绝
+func (c *HttpClient) do(ctx context.Context, r *req.Request, idempotent bool) *req.Response {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.Config.Timeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, c.Config.Timeout)
+			defer cancel()
+		}
+	}
+	if !c.breaker.allow() {
+		return &req.Response{Request: r, Err: ErrAuthServiceUnavailable}
+	}
+	if idempotent && c.Config.Retry.MaxRetries > 0 && !c.Config.RandomKey.Enable {
+		r.SetRetryCount(c.Config.Retry.MaxRetries).
+			SetRetryBackoffInterval(c.Config.Retry.MinBackoff, c.Config.Retry.MaxBackoff).
+			SetRetryCondition(shouldRetry)
+	}
+	res := r.SetContext(ctx).Do()
+	if res.Err != nil && ctx.Err() != nil {
+		c.breaker.release()
+	} else {
+		c.breaker.record(!isServiceFailure(res))
+	}
+	return res
+}
+
+func isServiceFailure(res *req.Response) bool {
+	return res.Err != nil || res.Response == nil || res.StatusCode >= http.StatusInternalServerError
+}
+
+func shouldRetry(res *req.Response, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return err != nil || isServiceFailure(res)
+}
+
+func (c *HttpClient) onCircuitStateChange(from, to CircuitState) {
+	c.logger.Info("auth service circuit breaker state changed", "from", from.String(), "to", to.String())
+	if c.Config.CircuitBreaker.OnStateChange != nil {
+		c.Config.CircuitBreaker.OnStateChange(from, to)
+	}
+}
+
+// CircuitState 返回访问鉴权服务的熔断状态，未启用熔断时始终为CircuitClosed
+func (c *HttpClient) CircuitState() CircuitState {
+	return c.breaker.State()
+}
+
 func (c *HttpClient) initTraceLog(f GetHeaderFun, r *req.Request) error {
 	if c.Config.EnableTraceLog {
 		var traceId string
@@ -79,7 +163,7 @@ func (c *HttpClient) initAccessCodeAndRandomKey(f GetHeaderFun, r *req.Request)
 				return err
 			}
 			if c.Config.AccessCode.EncryptContent {
-				accessCode, err = c.AesUtil.encrypt(c.Config.Client.AccessCode)
+				accessCode, err = c.AesUtil.encrypt(accessCode)
 				if err != nil {
 					panic(err)
 				}
@@ -91,7 +175,11 @@ func (c *HttpClient) initAccessCodeAndRandomKey(f GetHeaderFun, r *req.Request)
 	}
 	if c.Config.RandomKey.Enable {
 		if f == nil {
-			r.SetHeader(c.Config.RandomKey.Header, GenerateRandomKey())
+			if c.Config.RandomKey.BindTimestamp {
+				r.SetHeader(c.Config.RandomKey.Header, GenerateTimestampedRandomKey())
+			} else {
+				r.SetHeader(c.Config.RandomKey.Header, GenerateRandomKey())
+			}
 		} else {
 			randomKey, err := ExtractRandomKey(f, c.Config.RandomKey.Header)
 			if err != nil {
@@ -131,7 +219,9 @@ func (c *HttpClient) initClientToken(f GetHeaderFun, r *req.Request) (string, er
 			}
 			r.SetHeader(c.Config.Client.Header, c.Config.Client.HeaderSchema+" "+clientToken)
 		} else {
-			clientId, clientSecret, schemaAndToken, err := ExtractClientInfoAndToken(f, c.Config.Client.Header, c.Config.Client.HeaderSchema, c.Config.Client.EncryptContent, c.AesUtil, c.logger)
+			var clientSecret, schemaAndToken string
+			var err error
+			clientId, clientSecret, schemaAndToken, err = ExtractClientInfoAndToken(f, c.Config.Client.Header, c.Config.Client.HeaderSchema, c.Config.Client.EncryptContent, c.AesUtil, c.logger)
 			if err != nil {
 				return clientId, err
 			}
@@ -147,7 +237,27 @@ func (c *HttpClient) initClientToken(f GetHeaderFun, r *req.Request) (string, er
 	return clientId, nil
 }
 
+// decisionCacheKey 启用访问码或随机码时不缓存，否则命中缓存会跳过鉴权服务对访问码和随机码的校验
+func (c *HttpClient) decisionCacheKey(token string, url string, params ...string) (key string, tokenKey string) {
+	if c.Config.AccessCode.Enable || c.Config.RandomKey.Enable {
+		return "", ""
+	}
+	return decisionCacheKey(token, url, params...)
+}
+
+// IsPublicRoute 请求是否匹配Public配置的路由，f需通过伪请求头提供请求方法、路径和来源地址
+func (c *HttpClient) IsPublicRoute(f GetHeaderFun) bool {
+	return c.public.match(f, "", "")
+}
+
 func (c *HttpClient) CheckAuth(f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.CheckAuthCtx(context.Background(), f, fulfillCustomAuth)
+}
+
+func (c *HttpClient) CheckAuthCtx(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	if c.public.match(f, "", "") {
+		return &CheckAuthResult{SkippedAuthCheck: true}, nil
+	}
 	r := c.Agent.Post(UrlPostCheckAuth)
 	err := c.initTraceLog(f, r)
 	if err != nil {
@@ -164,22 +274,40 @@ func (c *HttpClient) CheckAuth(f GetHeaderFun, fulfillCustomAuth bool) (*CheckAu
 		c.logger.Error(err, err.Error())
 		return nil, err
 	}
+	if err = c.preValidateJwt(ctx, token); err != nil {
+		return nil, err
+	}
+	cacheKey, tokenKey := c.decisionCacheKey(token, UrlPostCheckAuth, strconv.FormatBool(fulfillCustomAuth))
+	if cached, err, ok := c.decisionCache.get(cacheKey); ok {
+		if err != nil {
+			return nil, err
+		}
+		return cached.(*CheckAuthResult), nil
+	}
 	result := &HttpResponse[CheckAuthResult]{}
-	res := r.
+	res := c.do(ctx, r.
 		SetResult(result).
-		SetFormDataAnyType(map[string]interface{}{"fulfillCustomAuth": strconv.FormatBool(fulfillCustomAuth)}).
-		Do()
+		SetFormDataAnyType(map[string]interface{}{"fulfillCustomAuth": strconv.FormatBool(fulfillCustomAuth)}), true)
 	err = handleError[CheckAuthResult](res, result, c.logger, true)
 	if err != nil {
+		c.decisionCache.putDenied(cacheKey, tokenKey, err)
 		return nil, err
 	}
 	if result.Result.User != nil {
 		result.Result.User.Token = token
 	}
+	c.decisionCache.put(cacheKey, tokenKey, result.Result, result.Result.User)
 	return result.Result, nil
 }
 
 func (c *HttpClient) CheckPermByCode(f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.CheckPermByCodeCtx(context.Background(), f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c *HttpClient) CheckPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	if c.public.match(f, "", "") {
+		return &CheckPermResult{SkippedAuthCheck: true}, nil
+	}
 	r := c.Agent.Post(UrlPostCheckPermByCode)
 	err := c.initTraceLog(f, r)
 	if err != nil {
@@ -196,27 +324,45 @@ func (c *HttpClient) CheckPermByCode(f GetHeaderFun, code string, fulfillJwt boo
 		c.logger.Error(err, err.Error())
 		return nil, err
 	}
+	if err = c.preValidateJwt(ctx, token); err != nil {
+		return nil, err
+	}
+	cacheKey, tokenKey := c.decisionCacheKey(token, UrlPostCheckPermByCode, code, strconv.FormatBool(fulfillJwt), strconv.FormatBool(fulfillCustomAuth), strconv.FormatBool(fulfillCustomPerm))
+	if cached, err, ok := c.decisionCache.get(cacheKey); ok {
+		if err != nil {
+			return nil, err
+		}
+		return cached.(*CheckPermResult), nil
+	}
 	result := &HttpResponse[CheckPermResult]{}
 	formData := make(map[string]any, 4)
 	formData["code"] = code
 	formData["fulfillJwt"] = fulfillJwt
 	formData["fulfillCustomAuth"] = fulfillCustomAuth
 	formData["fulfillCustomPerm"] = fulfillCustomPerm
-	res := r.
+	res := c.do(ctx, r.
 		SetResult(result).
-		SetFormDataAnyType(formData).
-		Do()
+		SetFormDataAnyType(formData), true)
 	err = handleError[CheckPermResult](res, result, c.logger, true)
 	if err != nil {
+		c.decisionCache.putDenied(cacheKey, tokenKey, err)
 		return nil, err
 	}
 	if result.Result.User != nil {
 		result.Result.User.Token = token
 	}
+	c.decisionCache.put(cacheKey, tokenKey, result.Result, result.Result.User)
 	return result.Result, nil
 }
 
 func (c *HttpClient) CheckPermByAction(f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.CheckPermByActionCtx(context.Background(), f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c *HttpClient) CheckPermByActionCtx(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	if c.public.match(f, method, path) {
+		return &CheckPermResult{SkippedAuthCheck: true}, nil
+	}
 	r := c.Agent.Post(UrlPostCheckPermByAction)
 	err := c.initTraceLog(f, r)
 	if err != nil {
@@ -233,6 +379,16 @@ func (c *HttpClient) CheckPermByAction(f GetHeaderFun, service string, method st
 		c.logger.Error(err, err.Error())
 		return nil, err
 	}
+	if err = c.preValidateJwt(ctx, token); err != nil {
+		return nil, err
+	}
+	cacheKey, tokenKey := c.decisionCacheKey(token, UrlPostCheckPermByAction, service, method, path, strconv.FormatBool(fulfillJwt), strconv.FormatBool(fulfillCustomAuth), strconv.FormatBool(fulfillCustomPerm))
+	if cached, err, ok := c.decisionCache.get(cacheKey); ok {
+		if err != nil {
+			return nil, err
+		}
+		return cached.(*CheckPermResult), nil
+	}
 	result := &HttpResponse[CheckPermResult]{}
 	formData := make(map[string]any, 6)
 	formData["service"] = service
@@ -241,21 +397,26 @@ func (c *HttpClient) CheckPermByAction(f GetHeaderFun, service string, method st
 	formData["fulfillJwt"] = fulfillJwt
 	formData["fulfillCustomAuth"] = fulfillCustomAuth
 	formData["fulfillCustomPerm"] = fulfillCustomPerm
-	res := r.
+	res := c.do(ctx, r.
 		SetResult(result).
-		SetFormDataAnyType(formData).
-		Do()
+		SetFormDataAnyType(formData), true)
 	err = handleError[CheckPermResult](res, result, c.logger, true)
 	if err != nil {
+		c.decisionCache.putDenied(cacheKey, tokenKey, err)
 		return nil, err
 	}
 	if result.Result.User != nil {
 		result.Result.User.Token = token
 	}
+	c.decisionCache.put(cacheKey, tokenKey, result.Result, result.Result.User)
 	return result.Result, nil
 }
 
 func (c *HttpClient) CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return c.CheckClientAuthCtx(context.Background(), f)
+}
+
+func (c *HttpClient) CheckClientAuthCtx(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error) {
 	r := c.Agent.Post(UrlPostCheckClientAuth)
 	err := c.initTraceLog(f, r)
 	if err != nil {
@@ -267,23 +428,31 @@ func (c *HttpClient) CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, er
 		c.logger.Error(err, err.Error())
 		return nil, err
 	}
-	_, err = c.initClientToken(f, r)
+	clientId, err := c.initClientToken(f, r)
 	if err != nil {
 		c.logger.Error(err, err.Error())
 		return nil, err
 	}
+	if f == nil {
+		clientId = c.Config.Client.Id
+	}
 	result := &HttpResponse[CheckClientAuthResult]{}
-	res := r.
-		SetResult(result).
-		Do()
+	res := c.do(ctx, r.SetResult(result), true)
 	err = handleError[CheckClientAuthResult](res, result, c.logger, true)
 	if err != nil {
 		return nil, err
 	}
+	if len(result.Result.ClientId) == 0 {
+		result.Result.ClientId = clientId
+	}
 	return result.Result, nil
 }
 
 func (c *HttpClient) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return c.CheckClientPermByCodeCtx(context.Background(), f, code)
+}
+
+func (c *HttpClient) CheckClientPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error) {
 	r := c.Agent.Post(UrlPostCheckClientPermByCode)
 	err := c.initTraceLog(f, r)
 	if err != nil {
@@ -303,10 +472,9 @@ func (c *HttpClient) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckC
 	result := &HttpResponse[CheckClientPermResult]{}
 	formData := make(map[string]any, 1)
 	formData["code"] = code
-	res := r.
+	res := c.do(ctx, r.
 		SetResult(result).
-		SetFormDataAnyType(formData).
-		Do()
+		SetFormDataAnyType(formData), true)
 	err = handleError[CheckClientPermResult](res, result, c.logger, true)
 	if err != nil {
 		return nil, err
@@ -315,35 +483,28 @@ func (c *HttpClient) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckC
 }
 
 func (c *HttpClient) ClientRequest(traceId string, urlPath string, httpMethod string, queryParam map[string]any, formData map[string]any) (any, error) {
-	r := c.Agent.R()
-	var err error
-	if len(traceId) == 0 {
-		traceId = uuid.New().String()
-	}
-	r.SetHeader(TraceId, traceId)
-	err = c.initAccessCodeAndRandomKey(nil, r)
-	if err != nil {
-		c.logger.Error(err, err.Error())
-		return nil, err
-	}
-	_, err = c.initClientToken(nil, r)
+	return c.ClientRequestCtx(context.Background(), traceId, urlPath, httpMethod, queryParam, formData)
+}
+
+func (c *HttpClient) ClientRequestCtx(ctx context.Context, traceId string, urlPath string, httpMethod string, queryParam map[string]any, formData map[string]any) (any, error) {
+	result, err := ClientDo[any](ctx, c, RequestSpec{
+		TraceId: traceId,
+		Method:  httpMethod,
+		Path:    urlPath,
+		Query:   queryParam,
+		Form:    formData,
+	})
 	if err != nil {
-		c.logger.Error(err, err.Error())
 		return nil, err
 	}
-	result := &HttpResponse[any]{}
+	return result, nil
+}
 
-	r.Method = httpMethod
-	r.RawURL = urlPath
+// InvalidateUser 清除该用户令牌的全部缓存鉴权结果，用户登出或权限变更时调用
+func (c *HttpClient) InvalidateUser(token string) {
+	c.decisionCache.invalidateToken(token)
+}
 
-	res := r.
-		SetResult(result).
-		SetQueryParamsAnyType(queryParam).
-		SetFormDataAnyType(formData).
-		Do()
-	err = handleError[any](res, result, c.logger, false)
-	if err != nil {
-		return nil, err
-	}
-	return result.Result, nil
+func (c *HttpClient) DecisionCacheStats() DecisionCacheStats {
+	return c.decisionCache.stats()
 }
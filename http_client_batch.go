@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBatchProbeInterval 鉴权服务不支持批量接口时，默认间隔多久重新尝试
+const DefaultBatchProbeInterval = 10 * time.Minute
+
+var errBatchRouteMissing = errors.New("auth service does not support batch permission check")
+
+func (c *HttpClient) CheckPermsByCodes(f GetHeaderFun, codes []string, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	return c.CheckPermsByCodesCtx(context.Background(), f, codes, fulfillJwt, fulfillCustomAuth)
+}
+
+// CheckPermsByCodesCtx 一次检查多个权限码，鉴权服务不支持批量接口时自动改为有限并发的逐个检查，
+// 启用访问码或随机码时不能逐个检查，返回ErrBatchUnsupported
+func (c *HttpClient) CheckPermsByCodesCtx(ctx context.Context, f GetHeaderFun, codes []string, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	codes = uniqueStrings(codes)
+	if c.public.match(f, "", "") {
+		return skippedPermsResult(codes), nil
+	}
+	if c.batchRouteAvailable() {
+		encoded, err := json.Marshal(codes)
+		if err != nil {
+			return nil, err
+		}
+		result, err := c.checkPermsInBatch(ctx, f, UrlPostCheckPermsByCodes, map[string]any{"codes": string(encoded)}, fulfillJwt, fulfillCustomAuth)
+		if !errors.Is(err, errBatchRouteMissing) {
+			return result, err
+		}
+	}
+	return c.checkPermsOneByOne(ctx, f, len(codes), fulfillJwt, fulfillCustomAuth, func(ctx context.Context, i int) (string, error) {
+		_, err := c.CheckPermByCodeCtx(ctx, f, codes[i], false, false, false)
+		return codes[i], err
+	})
+}
+
+func (c *HttpClient) CheckPermsByActions(f GetHeaderFun, actions []Action, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	return c.CheckPermsByActionsCtx(context.Background(), f, actions, fulfillJwt, fulfillCustomAuth)
+}
+
+// CheckPermsByActionsCtx 一次检查多个操作，结果以Action.Key()为键。按每个操作的method和path匹配公开路由，
+// 匹配的操作不检查，记入Skipped；全部匹配时SkippedAuthCheck为true
+func (c *HttpClient) CheckPermsByActionsCtx(ctx context.Context, f GetHeaderFun, actions []Action, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	actions, skipped := c.splitPublicActions(f, uniqueActions(actions))
+	if len(actions) == 0 && len(skipped) > 0 {
+		return skippedPermsResult(skipped), nil
+	}
+	result, err := c.checkPermsByActions(ctx, f, actions, fulfillJwt, fulfillCustomAuth)
+	if err != nil {
+		return nil, err
+	}
+	result.Skipped = skipped
+	return result, nil
+}
+
+// splitPublicActions 将匹配公开路由的操作分离出来，返回需要检查的操作和公开操作的键
+func (c *HttpClient) splitPublicActions(f GetHeaderFun, actions []Action) ([]Action, []string) {
+	var skipped []string
+	checked := actions[:0:0]
+	for _, a := range actions {
+		if len(a.Method) > 0 && len(a.Path) > 0 && c.public.match(f, a.Method, a.Path) {
+			skipped = append(skipped, a.Key())
+			continue
+		}
+		checked = append(checked, a)
+	}
+	return checked, skipped
+}
+
+func (c *HttpClient) checkPermsByActions(ctx context.Context, f GetHeaderFun, actions []Action, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	if c.batchRouteAvailable() {
+		encoded, err := json.Marshal(actions)
+		if err != nil {
+			return nil, err
+		}
+		result, err := c.checkPermsInBatch(ctx, f, UrlPostCheckPermsByActions, map[string]any{"actions": string(encoded)}, fulfillJwt, fulfillCustomAuth)
+		if !errors.Is(err, errBatchRouteMissing) {
+			return result, err
+		}
+	}
+	return c.checkPermsOneByOne(ctx, f, len(actions), fulfillJwt, fulfillCustomAuth, func(ctx context.Context, i int) (string, error) {
+		a := actions[i]
+		_, err := c.CheckPermByActionCtx(ctx, f, a.Service, a.Method, a.Path, false, false, false)
+		return a.Key(), err
+	})
+}
+
+func (c *HttpClient) checkPermsInBatch(ctx context.Context, f GetHeaderFun, url string, formData map[string]any, fulfillJwt bool, fulfillCustomAuth bool) (*CheckPermsResult, error) {
+	r := c.Agent.Post(url)
+	err := c.initTraceLog(f, r)
+	if err != nil {
+		c.logger.Error(err, err.Error())
+		return nil, err
+	}
+	err = c.initAccessCodeAndRandomKey(f, r)
+	if err != nil {
+		c.logger.Error(err, err.Error())
+		return nil, err
+	}
+	token, err := c.initUserToken(f, r)
+	if err != nil {
+		c.logger.Error(err, err.Error())
+		return nil, err
+	}
+	if err = c.preValidateJwt(ctx, token); err != nil {
+		return nil, err
+	}
+	result := &HttpResponse[CheckPermsResult]{}
+	formData["fulfillJwt"] = fulfillJwt
+	formData["fulfillCustomAuth"] = fulfillCustomAuth
+	res := c.do(ctx, r.
+		SetResult(result).
+		SetFormDataAnyType(formData), true)
+	if res.Err == nil && res.Response != nil && res.StatusCode == http.StatusNotFound {
+		atomic.StoreInt64(&c.batchRouteMissingUntil, time.Now().Add(c.Config.Batch.ProbeInterval).UnixNano())
+		c.logger.Info("auth service does not support batch permission check, fall back to checking one by one", "url", url, "probeInterval", c.Config.Batch.ProbeInterval)
+		return nil, errBatchRouteMissing
+	}
+	err = handleError[CheckPermsResult](res, result, c.logger, true)
+	if err != nil {
+		return nil, err
+	}
+	if result.Result.User != nil {
+		result.Result.User.Token = token
+	}
+	if result.Result.Perms == nil {
+		result.Result.Perms = make(map[string]bool)
+	}
+	return result.Result, nil
+}
+
+// batchRouteAvailable 发现批量接口不存在后，经过Batch.ProbeInterval再重新尝试，鉴权服务升级后即可恢复批量检查
+func (c *HttpClient) batchRouteAvailable() bool {
+	return time.Now().UnixNano() >= atomic.LoadInt64(&c.batchRouteMissingUntil)
+}
+
+// checkPermsOneByOne 先获取一次用户信息，再以有限并发逐个检查，权限不足（ErrPermFail）记为false，
+// 遇到其他错误（如身份验证失败、访问过于频繁）立即返回。
+// 启用访问码或随机码时返回ErrBatchUnsupported，逐个检查会重复发送请求中的一次性访问码和随机码，第一次之后都会被拒绝
+func (c *HttpClient) checkPermsOneByOne(ctx context.Context, f GetHeaderFun, n int, fulfillJwt bool, fulfillCustomAuth bool, check func(ctx context.Context, i int) (string, error)) (*CheckPermsResult, error) {
+	if c.Config.AccessCode.Enable || c.Config.RandomKey.Enable {
+		return nil, ErrBatchUnsupported
+	}
+	result := &CheckPermsResult{Perms: make(map[string]bool, n)}
+	if fulfillJwt || fulfillCustomAuth {
+		authResult, err := c.CheckAuthCtx(ctx, f, fulfillCustomAuth)
+		if err != nil {
+			return nil, err
+		}
+		result.SkippedAuthCheck = authResult.SkippedAuthCheck
+		if fulfillJwt {
+			result.User = authResult.User
+		}
+		result.CustomAuth = authResult.CustomAuth
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	concurrency := c.Config.Batch.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			key, err := check(ctx, i)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result.Perms[key] = true
+			case errors.Is(err, ErrPermFail):
+				result.Perms[key] = false
+			case firstErr == nil:
+				firstErr = err
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// skippedPermsResult 公开路由跳过鉴权，不检查任何权限，与单个检查的SkippedAuthCheck结果一致，Perms为空
+func skippedPermsResult(keys []string) *CheckPermsResult {
+	return &CheckPermsResult{SkippedAuthCheck: true, Perms: map[string]bool{}, Skipped: keys}
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+func uniqueActions(actions []Action) []Action {
+	seen := make(map[string]struct{}, len(actions))
+	unique := make([]Action, 0, len(actions))
+	for _, a := range actions {
+		if _, ok := seen[a.Key()]; ok {
+			continue
+		}
+		seen[a.Key()] = struct{}{}
+		unique = append(unique, a)
+	}
+	return unique
+}
@@ -1,48 +1,177 @@
 package auth
 
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
 type Service struct {
-	AuthServiceBaseUrl string
-	CurrentServiceName string
-	EncryptKey         string
-	EnableTraceLog     bool
+	AuthServiceBaseUrl string        `json:"authServiceBaseUrl" yaml:"authServiceBaseUrl"`
+	CurrentServiceName string        `json:"currentServiceName" yaml:"currentServiceName"`
+	EncryptKey         string        `json:"encryptKey" yaml:"encryptKey"` // AES加密key，与NewHttpClient的aesKey参数相同
+	EnableTraceLog     bool          `json:"enableTraceLog" yaml:"enableTraceLog"`
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"` // 单次调用的默认超时，ctx未设置截止时间时生效，0表示不限制
 }
 
 type AccessCode struct {
-	Enable             bool
-	SkipUserTokenCheck bool
-	Header             string
-	EncryptContent     bool
+	Enable             bool   `json:"enable" yaml:"enable"`
+	SkipUserTokenCheck bool   `json:"skipUserTokenCheck" yaml:"skipUserTokenCheck"`
+	Header             string `json:"header" yaml:"header"`
+	EncryptContent     bool   `json:"encryptContent" yaml:"encryptContent"`
 }
 
 type RandomKey struct {
-	Enable bool
-	Header string
+	Enable        bool   `json:"enable" yaml:"enable"`
+	Header        string `json:"header" yaml:"header"`
+	BindTimestamp bool   `json:"bindTimestamp" yaml:"bindTimestamp"` // 自行生成的随机码带有时间戳，需与本地检查的LocalRandomKey.BindTimestamp一致
 }
 
 type User struct {
-	Header       string
-	HeaderSchema string
+	Header       string `json:"header" yaml:"header"`
+	HeaderSchema string `json:"headerSchema" yaml:"headerSchema"`
 }
 
 type Client struct {
-	Id                string
-	Secret            string
-	EnableIdAndSecret bool
-	AccessCode        string
-	Header            string
-	HeaderSchema      string
-	EncryptContent    bool
+	Id                string `json:"id" yaml:"id"`
+	Secret            string `json:"secret" yaml:"secret"`
+	EnableIdAndSecret bool   `json:"enableIdAndSecret" yaml:"enableIdAndSecret"`
+	AccessCode        string `json:"accessCode" yaml:"accessCode"`
+	Header            string `json:"header" yaml:"header"`
+	HeaderSchema      string `json:"headerSchema" yaml:"headerSchema"`
+	EncryptContent    bool   `json:"encryptContent" yaml:"encryptContent"`
+}
+
+// DecisionCache 鉴权结果缓存，启用访问码或随机码时不生效，以免跳过鉴权服务对二者的校验
+type DecisionCache struct {
+	Enable      bool          `json:"enable" yaml:"enable"`
+	Capacity    int           `json:"capacity" yaml:"capacity"`       // 最大缓存条数
+	TTL         time.Duration `json:"ttl" yaml:"ttl"`                 // 鉴权通过结果的缓存时间，不会超过令牌的过期时间
+	NegativeTTL time.Duration `json:"negativeTtl" yaml:"negativeTtl"` // 鉴权拒绝结果的缓存时间
+}
+
+type Retry struct {
+	MaxRetries int           `json:"maxRetries" yaml:"maxRetries"` // 鉴权检查类请求的最大重试次数，0表示不重试，启用随机码时不重试
+	MinBackoff time.Duration `json:"minBackoff" yaml:"minBackoff"` // 指数退避的初始间隔
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"` // 指数退避的最大间隔
+}
+
+type CircuitBreaker struct {
+	Enable           bool                        `json:"enable" yaml:"enable"`
+	FailureThreshold int                         `json:"failureThreshold" yaml:"failureThreshold"` // 连续失败多少次后熔断
+	OpenTimeout      time.Duration               `json:"openTimeout" yaml:"openTimeout"`           // 熔断持续时间，之后进入半开状态
+	HalfOpenMaxCalls int                         `json:"halfOpenMaxCalls" yaml:"halfOpenMaxCalls"` // 半开状态允许的探测请求数
+	OnStateChange    func(from, to CircuitState) `json:"-" yaml:"-"`                               // 熔断状态变化回调
+}
+
+type Batch struct {
+	Concurrency   int           `json:"concurrency" yaml:"concurrency"`     // 鉴权服务不支持批量接口时，逐个检查的最大并发数
+	ProbeInterval time.Duration `json:"probeInterval" yaml:"probeInterval"` // 鉴权服务不支持批量接口时，间隔多久重新尝试批量接口
+}
+
+type JwtPreValidation struct {
+	Enable       bool     `json:"enable" yaml:"enable"`
+	PublicKey    string   `json:"publicKey" yaml:"publicKey"`       // PEM格式的RSA、ECDSA或Ed25519公钥，为空时从鉴权服务下载
+	PublicKeyUrl string   `json:"publicKeyUrl" yaml:"publicKeyUrl"` // 下载公钥的地址
+	Algorithms   []string `json:"algorithms" yaml:"algorithms"`     // 允许的签名算法，为空时只允许公钥类型对应的默认算法
+	JwksUrl      string   `json:"jwksUrl" yaml:"jwksUrl"`           // JWKS地址，配置后按令牌头中的kid选择公钥，以/开头时相对于authServiceBaseUrl
+}
+
+// Public 无需鉴权的路由，匹配时不解析任何令牌，直接返回SkippedAuthCheck为true的结果
+type Public struct {
+	Routes []PublicRoute `json:"routes" yaml:"routes"`
 }
 
 type Auditing struct {
-	MetaBy string
+	MetaBy string `json:"metaBy" yaml:"metaBy"`
 }
 
 type HttpClientConfig struct {
-	Service
-	AccessCode
-	RandomKey
-	User
-	Client
-	Auditing
+	Service          `json:"service" yaml:"service"`
+	AccessCode       `json:"accessCode" yaml:"accessCode"`
+	RandomKey        `json:"randomKey" yaml:"randomKey"`
+	User             `json:"user" yaml:"user"`
+	Client           `json:"client" yaml:"client"`
+	DecisionCache    `json:"decisionCache" yaml:"decisionCache"`
+	Retry            `json:"retry" yaml:"retry"`
+	CircuitBreaker   `json:"circuitBreaker" yaml:"circuitBreaker"`
+	Batch            `json:"batch" yaml:"batch"`
+	JwtPreValidation `json:"jwtPreValidation" yaml:"jwtPreValidation"`
+	Public           `json:"public" yaml:"public"`
+	Auditing         `json:"auditing" yaml:"auditing"`
+}
+
+// Validate 检查配置并一次返回全部问题，返回的错误为ConfigErrors
+func (c *HttpClientConfig) Validate() error {
+	problems := configProblems{}
+	if len(c.AuthServiceBaseUrl) == 0 {
+		problems.add("service.authServiceBaseUrl", "不能为空")
+	} else if u, err := url.Parse(c.AuthServiceBaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		problems.add("service.authServiceBaseUrl", "不是有效的http地址: %s", c.AuthServiceBaseUrl)
+	}
+	validateEncryptKey(&problems, "service.encryptKey", c.EncryptKey, c.AccessCode.EncryptContent || c.Client.EncryptContent)
+	validateNonNegative(&problems, "service.timeout", int64(c.Timeout))
+	if strings.Contains(c.Client.Id, ClientIdAndSecretSplitter) {
+		problems.add("client.id", "不能包含%s", ClientIdAndSecretSplitter)
+	}
+	if (len(c.Client.Id) == 0) != (len(c.Client.Secret) == 0) {
+		problems.add("client.secret", "客户端id和秘钥需同时配置")
+	}
+	validateNonNegative(&problems, "decisionCache.capacity", int64(c.DecisionCache.Capacity))
+	validateNonNegative(&problems, "decisionCache.ttl", int64(c.DecisionCache.TTL))
+	validateNonNegative(&problems, "decisionCache.negativeTtl", int64(c.DecisionCache.NegativeTTL))
+	validateNonNegative(&problems, "retry.maxRetries", int64(c.Retry.MaxRetries))
+	validateNonNegative(&problems, "retry.minBackoff", int64(c.Retry.MinBackoff))
+	validateNonNegative(&problems, "retry.maxBackoff", int64(c.Retry.MaxBackoff))
+	if c.Retry.MinBackoff > 0 && c.Retry.MinBackoff < MinRetryBackoff {
+		problems.add("retry.minBackoff", "不能小于%s", MinRetryBackoff)
+	}
+	if c.Retry.MaxBackoff > 0 && c.Retry.MaxBackoff < MinRetryBackoff {
+		problems.add("retry.maxBackoff", "不能小于%s", MinRetryBackoff)
+	}
+	if c.Retry.MinBackoff > 0 && c.Retry.MaxBackoff > 0 && c.Retry.MinBackoff > c.Retry.MaxBackoff {
+		problems.add("retry.maxBackoff", "不能小于minBackoff")
+	}
+	validateNonNegative(&problems, "circuitBreaker.failureThreshold", int64(c.CircuitBreaker.FailureThreshold))
+	validateNonNegative(&problems, "circuitBreaker.openTimeout", int64(c.CircuitBreaker.OpenTimeout))
+	validateNonNegative(&problems, "circuitBreaker.halfOpenMaxCalls", int64(c.CircuitBreaker.HalfOpenMaxCalls))
+	validateNonNegative(&problems, "batch.concurrency", int64(c.Batch.Concurrency))
+	validateNonNegative(&problems, "batch.probeInterval", int64(c.Batch.ProbeInterval))
+	if len(c.JwtPreValidation.JwksUrl) > 0 {
+		for _, algorithm := range c.JwtPreValidation.Algorithms {
+			if _, ok := jwtSigningMethods[algorithm]; !ok {
+				problems.add("jwtPreValidation.algorithms", "不支持的令牌签名算法: %s", algorithm)
+			}
+		}
+	} else if len(c.JwtPreValidation.PublicKey) > 0 {
+		if _, err := NewJwtVerifier([]byte(c.JwtPreValidation.PublicKey), c.JwtPreValidation.Algorithms...); err != nil {
+			problems.add("jwtPreValidation.publicKey", "%s", err.Error())
+		}
+	}
+	validatePublicRoutes(&problems, "public.routes", c.Public.Routes)
+	return problems.err()
+}
+
+func validateEncryptKey(problems *configProblems, field string, key string, required bool) {
+	if len(key) == 0 {
+		if required {
+			problems.add(field, "启用加密时不能为空")
+		}
+		return
+	}
+	if len(key) != 16 {
+		problems.add(field, "%s", MsgAESKeyError)
+	}
+}
+
+func validateNonNegative(problems *configProblems, field string, val int64) {
+	if val < 0 {
+		problems.add(field, "不能为负数")
+	}
+}
+
+func validatePublicRoutes(problems *configProblems, field string, routes []PublicRoute) {
+	if _, err := newPublicRoutes(routes); err != nil {
+		problems.add(field, "%s", err.Error())
+	}
 }
@@ -3,6 +3,7 @@ package auth
 import (
 	"github.com/go-logr/logr"
 	"github.com/imroc/req/v3"
+	"time"
 )
 
 type ClientOption func(*HttpClient)
@@ -20,6 +21,7 @@ func WithRandomKeyConfig(config RandomKey) ClientOption {
 	return func(client *HttpClient) {
 		client.Config.RandomKey.Enable = config.Enable
 		client.Config.RandomKey.Header = GetNonEmptyValueWithBackup(config.Header, DefaultHeaderRandomKey)
+		client.Config.RandomKey.BindTimestamp = config.BindTimestamp
 	}
 }
 
@@ -42,58 +44,247 @@ func WithClientConfig(config Client) ClientOption {
 	}
 }
 
+func WithDecisionCacheConfig(config DecisionCache) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.DecisionCache.Enable = config.Enable
+		client.Config.DecisionCache.Capacity = config.Capacity
+		if config.Capacity <= 0 {
+			client.Config.DecisionCache.Capacity = DefaultDecisionCacheCapacity
+		}
+		client.Config.DecisionCache.TTL = config.TTL
+		if config.TTL <= 0 {
+			client.Config.DecisionCache.TTL = DefaultDecisionCacheTTL
+		}
+		client.Config.DecisionCache.NegativeTTL = config.NegativeTTL
+		if config.NegativeTTL <= 0 {
+			client.Config.DecisionCache.NegativeTTL = DefaultDecisionCacheNegativeTTL
+		}
+	}
+}
+
+// WithRetryConfig 退避间隔小于MinRetryBackoff时按MinRetryBackoff处理
+func WithRetryConfig(config Retry) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.Retry.MaxRetries = config.MaxRetries
+		client.Config.Retry.MinBackoff = config.MinBackoff
+		if config.MinBackoff <= 0 {
+			client.Config.Retry.MinBackoff = DefaultRetryMinBackoff
+		} else if config.MinBackoff < MinRetryBackoff {
+			client.Config.Retry.MinBackoff = MinRetryBackoff
+		}
+		client.Config.Retry.MaxBackoff = config.MaxBackoff
+		if config.MaxBackoff <= 0 {
+			client.Config.Retry.MaxBackoff = DefaultRetryMaxBackoff
+		} else if config.MaxBackoff < MinRetryBackoff {
+			client.Config.Retry.MaxBackoff = MinRetryBackoff
+		}
+	}
+}
+
+func WithCircuitBreakerConfig(config CircuitBreaker) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.CircuitBreaker.Enable = config.Enable
+		client.Config.CircuitBreaker.FailureThreshold = config.FailureThreshold
+		if config.FailureThreshold <= 0 {
+			client.Config.CircuitBreaker.FailureThreshold = DefaultCircuitBreakerThreshold
+		}
+		client.Config.CircuitBreaker.OpenTimeout = config.OpenTimeout
+		if config.OpenTimeout <= 0 {
+			client.Config.CircuitBreaker.OpenTimeout = DefaultCircuitBreakerOpenTimeout
+		}
+		client.Config.CircuitBreaker.HalfOpenMaxCalls = config.HalfOpenMaxCalls
+		if config.HalfOpenMaxCalls <= 0 {
+			client.Config.CircuitBreaker.HalfOpenMaxCalls = DefaultCircuitBreakerHalfOpenMaxCall
+		}
+		client.Config.CircuitBreaker.OnStateChange = config.OnStateChange
+	}
+}
+
+func WithBatchConfig(config Batch) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.Batch.Concurrency = config.Concurrency
+		if config.Concurrency <= 0 {
+			client.Config.Batch.Concurrency = DefaultBatchConcurrency
+		}
+		client.Config.Batch.ProbeInterval = config.ProbeInterval
+		if config.ProbeInterval <= 0 {
+			client.Config.Batch.ProbeInterval = DefaultBatchProbeInterval
+		}
+	}
+}
+
+func WithJwtPreValidationConfig(config JwtPreValidation) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.JwtPreValidation.Enable = config.Enable
+		client.Config.JwtPreValidation.PublicKey = config.PublicKey
+		client.Config.JwtPreValidation.PublicKeyUrl = GetNonEmptyValueWithBackup(config.PublicKeyUrl, UrlGetJwtPublicKey)
+		client.Config.JwtPreValidation.Algorithms = config.Algorithms
+		client.Config.JwtPreValidation.JwksUrl = config.JwksUrl
+	}
+}
+
+// WithPublicConfig 路由配置错误时记录为public.routes的配置错误
+func WithPublicConfig(config Public) ClientOption {
+	return func(client *HttpClient) {
+		public, err := newPublicRoutes(config.Routes)
+		if err != nil {
+			client.optionErrors.add("public.routes", "%s", err.Error())
+			return
+		}
+		client.Config.Public.Routes = config.Routes
+		client.public = public
+	}
+}
+
 func WithAuditingConfig(config Auditing) ClientOption {
 	return func(client *HttpClient) {
 		client.Config.Auditing.MetaBy = GetNonEmptyValueWithBackup(config.MetaBy, DefaultMetaBy)
 	}
 }
 
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.Service.Timeout = timeout
+	}
+}
+
 func WithHttpClientLogger(logger logr.Logger) ClientOption {
 	return func(client *HttpClient) {
 		client.logger = logger
 	}
 }
 
-func NewHttpClient(AuthServiceBaseUrl string, CurrentServiceName string, aesKey string, options ...ClientOption) *HttpClient {
-	client := &HttpClient{
-		Config: &HttpClientConfig{
-			Service: Service{
-				AuthServiceBaseUrl: AuthServiceBaseUrl,
-				CurrentServiceName: CurrentServiceName,
-				EnableTraceLog:     true,
-			},
-			AccessCode: AccessCode{
-				Enable:             false,
-				SkipUserTokenCheck: true,
-				Header:             DefaultHeaderAccessCode,
-				EncryptContent:     false,
-			},
-			RandomKey: RandomKey{
-				Enable: false,
-				Header: DefaultHeaderRandomKey,
-			},
-			User: User{
-				Header:       DefaultHeaderUserToken,
-				HeaderSchema: DefaultHeaderSchema,
-			},
-			Client: Client{
-				EnableIdAndSecret: true,
-				Header:            DefaultHeaderClientToken,
-				HeaderSchema:      DefaultHeaderSchema,
-				EncryptContent:    false,
-			},
-			Auditing: Auditing{
-				MetaBy: DefaultMetaBy,
-			},
+// defaultHttpClientConfig 未通过选项设置时使用的配置
+func defaultHttpClientConfig(AuthServiceBaseUrl string, CurrentServiceName string, aesKey string) *HttpClientConfig {
+	return &HttpClientConfig{
+		Service: Service{
+			AuthServiceBaseUrl: AuthServiceBaseUrl,
+			CurrentServiceName: CurrentServiceName,
+			EncryptKey:         aesKey,
+			EnableTraceLog:     true,
+		},
+		AccessCode: AccessCode{
+			Enable:             false,
+			SkipUserTokenCheck: true,
+			Header:             DefaultHeaderAccessCode,
+			EncryptContent:     false,
+		},
+		RandomKey: RandomKey{
+			Enable: false,
+			Header: DefaultHeaderRandomKey,
+		},
+		User: User{
+			Header:       DefaultHeaderUserToken,
+			HeaderSchema: DefaultHeaderSchema,
 		},
-		AesUtil: NewAesUtil(aesKey),
+		Client: Client{
+			EnableIdAndSecret: true,
+			Header:            DefaultHeaderClientToken,
+			HeaderSchema:      DefaultHeaderSchema,
+			EncryptContent:    false,
+		},
+		DecisionCache: DecisionCache{
+			Enable:      false,
+			Capacity:    DefaultDecisionCacheCapacity,
+			TTL:         DefaultDecisionCacheTTL,
+			NegativeTTL: DefaultDecisionCacheNegativeTTL,
+		},
+		Retry: Retry{
+			MaxRetries: 0,
+			MinBackoff: DefaultRetryMinBackoff,
+			MaxBackoff: DefaultRetryMaxBackoff,
+		},
+		CircuitBreaker: CircuitBreaker{
+			Enable:           false,
+			FailureThreshold: DefaultCircuitBreakerThreshold,
+			OpenTimeout:      DefaultCircuitBreakerOpenTimeout,
+			HalfOpenMaxCalls: DefaultCircuitBreakerHalfOpenMaxCall,
+		},
+		Batch: Batch{
+			Concurrency:   DefaultBatchConcurrency,
+			ProbeInterval: DefaultBatchProbeInterval,
+		},
+		JwtPreValidation: JwtPreValidation{
+			Enable:       false,
+			PublicKeyUrl: UrlGetJwtPublicKey,
+		},
+		Auditing: Auditing{
+			MetaBy: DefaultMetaBy,
+		},
+	}
+}
+
+// NewHttpClient 配置错误时panic，错误为ConfigErrors
+func NewHttpClient(AuthServiceBaseUrl string, CurrentServiceName string, aesKey string, options ...ClientOption) *HttpClient {
+	client, err := NewHttpClientE(AuthServiceBaseUrl, CurrentServiceName, aesKey, options...)
+	if err != nil {
+		panic(err)
 	}
+	return client
+}
+
+// NewHttpClientE 与NewHttpClient相同，但配置错误时返回ConfigErrors而不是panic
+func NewHttpClientE(AuthServiceBaseUrl string, CurrentServiceName string, aesKey string, options ...ClientOption) (*HttpClient, error) {
+	client := &HttpClient{Config: defaultHttpClientConfig(AuthServiceBaseUrl, CurrentServiceName, aesKey)}
 	for _, opt := range options {
 		opt(client)
 	}
-	if client.logger.GetSink() == nil {
-		client.logger = logr.Discard()
+	if err := client.optionErrors.err(); err != nil {
+		return nil, err
+	}
+	if err := client.Config.Validate(); err != nil {
+		return nil, err
+	}
+	client.init()
+	return client, nil
+}
+
+// NewHttpClientWithConfig 使用LoadHttpClientConfig等方式得到的配置创建HttpClient，先校验配置，options在配置之后应用
+func NewHttpClientWithConfig(config *HttpClientConfig, options ...ClientOption) (*HttpClient, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewHttpClientE(config.AuthServiceBaseUrl, config.CurrentServiceName, config.EncryptKey, append(config.options(), options...)...)
+}
+
+// init 应用选项后创建派生状态
+func (c *HttpClient) init() {
+	c.AesUtil = NewAesUtil(c.Config.EncryptKey)
+	if c.logger.GetSink() == nil {
+		c.logger = logr.Discard()
+	}
+	if c.Config.DecisionCache.Enable {
+		c.decisionCache = newDecisionCache(c.Config.DecisionCache)
+	}
+	if c.Config.CircuitBreaker.Enable {
+		c.breaker = newCircuitBreaker(c.Config.CircuitBreaker, c.onCircuitStateChange)
+	}
+	if c.Config.JwtPreValidation.Enable {
+		c.jwtPreValidator = newJwtPreValidator(c.Config.JwtPreValidation, c.Config.AuthServiceBaseUrl, c.logger)
+	}
+	c.Agent = req.C().SetBaseURL(c.Config.AuthServiceBaseUrl)
+}
+
+func withServiceConfig(config Service) ClientOption {
+	return func(client *HttpClient) {
+		client.Config.Service = config
+	}
+}
+
+// options 将配置的各部分转换为对应的选项，空值按各选项的规则使用默认值
+func (c *HttpClientConfig) options() []ClientOption {
+	return []ClientOption{
+		withServiceConfig(c.Service),
+		WithAccessCodeConfig(c.AccessCode),
+		WithRandomKeyConfig(c.RandomKey),
+		WithUserConfig(c.User),
+		WithClientConfig(c.Client),
+		WithDecisionCacheConfig(c.DecisionCache),
+		WithRetryConfig(c.Retry),
+		WithCircuitBreakerConfig(c.CircuitBreaker),
+		WithBatchConfig(c.Batch),
+		WithJwtPreValidationConfig(c.JwtPreValidation),
+		WithPublicConfig(c.Public),
+		WithAuditingConfig(c.Auditing),
 	}
-	client.Agent = req.C().SetBaseURL(AuthServiceBaseUrl)
-	return client
 }
@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+	"golang.org/x/sync/singleflight"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// jwtPublicKeyRetryInterval 公钥下载失败后，在此间隔内不再重新下载
+	jwtPublicKeyRetryInterval = 30 * time.Second
+	// jwtPublicKeyRefreshInterval 令牌无法通过已下载的公钥校验时重新下载，两次下载至少间隔此时间
+	jwtPublicKeyRefreshInterval = DefaultJWKSMinRefreshInterval
+)
+
+// jwtPreValidator 在访问鉴权服务之前离线校验用户令牌。配置了JWKS地址时按kid选择公钥，遇到未知kid时重新下载；
+// 否则使用配置的公钥，公钥未配置时首次使用前从鉴权服务下载，令牌无法通过校验时重新下载以适应秘钥轮换。
+// 下载在锁外进行，并发请求通过singleflight共用一次下载，已有公钥的请求不会等待
+type jwtPreValidator struct {
+	jwks            *JWKSVerifier
+	static          bool // 公钥来自配置，不重新下载
+	refreshInterval time.Duration
+	verifier        atomic.Pointer[JwtVerifier]
+	download        singleflight.Group
+	mu              sync.Mutex // 保护retryAt和downloadedAt
+	retryAt         time.Time
+	downloadedAt    time.Time
+}
+
+func newJwtPreValidator(config JwtPreValidation, baseUrl string, logger logr.Logger) *jwtPreValidator {
+	v := &jwtPreValidator{refreshInterval: jwtPublicKeyRefreshInterval}
+	if len(config.JwksUrl) > 0 {
+		jwksUrl := config.JwksUrl
+		if strings.HasPrefix(jwksUrl, "/") {
+			jwksUrl = strings.TrimSuffix(baseUrl, "/") + jwksUrl
+		}
+		v.jwks = NewJWKSVerifier(jwksUrl, WithJWKSAlgorithms(config.Algorithms...), WithJWKSLogger(logger))
+		return v
+	}
+	if len(config.PublicKey) > 0 {
+		verifier, err := NewJwtVerifier([]byte(config.PublicKey), config.Algorithms...)
+		if err != nil {
+			panic(err)
+		}
+		v.static = true
+		v.verifier.Store(verifier)
+	}
+	return v
+}
+
+// preValidateJwt 令牌签名、有效期或声明不正确时返回ErrJwtErrFormat，公钥获取失败时放行，由鉴权服务完成校验
+func (c *HttpClient) preValidateJwt(ctx context.Context, token string) error {
+	if c.jwtPreValidator == nil || len(token) == 0 {
+		return nil
+	}
+	if jwks := c.jwtPreValidator.jwks; jwks != nil {
+		_, err := jwks.ValidateJwtCtx(ctx, token)
+		if errors.Is(err, ErrAuthServiceUnavailable) || errors.Is(err, ErrNoResult) {
+			c.logger.Error(err, "download jwks failed, skip jwt pre-validation")
+			return nil
+		}
+		if err != nil {
+			return ErrJwtErrFormat
+		}
+		return nil
+	}
+	verifier, err := c.jwtVerifier(ctx, nil)
+	if err != nil {
+		c.logger.Error(err, "download jwt public key failed, skip jwt pre-validation")
+		return nil
+	}
+	if _, err = verifier.ValidateJwt(token); err == nil {
+		return nil
+	}
+	// 鉴权服务轮换秘钥后，新秘钥签发的令牌无法通过旧公钥校验，重新下载后再校验一次
+	refreshed, err := c.jwtVerifier(ctx, verifier)
+	if err != nil {
+		c.logger.Error(err, "refresh jwt public key failed, skip jwt pre-validation")
+		return nil
+	}
+	if refreshed == verifier {
+		return ErrJwtErrFormat
+	}
+	if _, err = refreshed.ValidateJwt(token); err != nil {
+		return ErrJwtErrFormat
+	}
+	return nil
+}
+
+// jwtVerifier stale不为nil时表示stale无法校验令牌，距上次下载超过refreshInterval时重新下载，否则仍返回当前公钥
+func (c *HttpClient) jwtVerifier(ctx context.Context, stale *JwtVerifier) (*JwtVerifier, error) {
+	v := c.jwtPreValidator
+	current := v.verifier.Load()
+	if current != nil && (current != stale || v.static) {
+		return current, nil
+	}
+	v.mu.Lock()
+	retryAt, downloadedAt := v.retryAt, v.downloadedAt
+	v.mu.Unlock()
+	if current != nil && time.Since(downloadedAt) < v.refreshInterval {
+		return current, nil
+	}
+	if time.Now().Before(retryAt) {
+		return nil, ErrAuthServiceUnavailable
+	}
+	verifier, err, _ := v.download.Do(c.Config.JwtPreValidation.PublicKeyUrl, func() (any, error) {
+		if verifier := v.verifier.Load(); verifier != nil && verifier != stale {
+			return verifier, nil
+		}
+		verifier, err := c.downloadJwtVerifier(ctx)
+		v.mu.Lock()
+		defer v.mu.Unlock()
+		if err != nil {
+			v.retryAt = time.Now().Add(jwtPublicKeyRetryInterval)
+			return nil, err
+		}
+		v.downloadedAt = time.Now()
+		v.verifier.Store(verifier)
+		return verifier, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return verifier.(*JwtVerifier), nil
+}
+
+func (c *HttpClient) downloadJwtVerifier(ctx context.Context) (*JwtVerifier, error) {
+	result := &HttpResponse[string]{}
+	res := c.do(ctx, c.Agent.Get(c.Config.JwtPreValidation.PublicKeyUrl).SetResult(result), true)
+	if err := handleError[string](res, result, c.logger, true); err != nil {
+		return nil, err
+	}
+	return NewJwtVerifier([]byte(*result.Result), c.Config.JwtPreValidation.Algorithms...)
+}
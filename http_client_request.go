@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+)
+
+type RequestFile struct {
+	ParamName string
+	FileName  string
+	Reader    io.Reader
+}
+
+// RequestSpec 描述一次客户端请求，JSON与Form/Files不能同时设置
+type RequestSpec struct {
+	TraceId    string
+	Method     string
+	Path       string            // 请求路径，可包含路径参数，如/orders/{id}
+	PathParams map[string]string // 路径参数
+	Query      map[string]any
+	Headers    map[string]string
+	Form       map[string]any
+	Files      []RequestFile // 设置后以multipart/form-data方式提交Form和Files
+	JSON       any
+}
+
+type TypedPagedResult[T any] struct {
+	Items    []T `json:"items"`
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PageSize int `json:"pageSize"`
+}
+
+// ClientDo 以客户端身份请求其他服务，并将返回的HttpResponse.Result解析为T
+func ClientDo[T any](ctx context.Context, c *HttpClient, spec RequestSpec) (*T, error) {
+	if spec.JSON != nil && (len(spec.Form) > 0 || len(spec.Files) > 0) {
+		return nil, ErrRequestBodyConflict
+	}
+	r := c.Agent.R()
+	traceId := spec.TraceId
+	if len(traceId) == 0 {
+		traceId = uuid.New().String()
+	}
+	r.SetHeader(TraceId, traceId)
+	err := c.initAccessCodeAndRandomKey(nil, r)
+	if err != nil {
+		c.logger.Error(err, err.Error())
+		return nil, err
+	}
+	_, err = c.initClientToken(nil, r)
+	if err != nil {
+		c.logger.Error(err, err.Error())
+		return nil, err
+	}
+	method := spec.Method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+	r.Method = method
+	r.RawURL = spec.Path
+	r.SetHeaders(spec.Headers).
+		SetPathParams(spec.PathParams).
+		SetQueryParamsAnyType(spec.Query)
+	if spec.JSON != nil {
+		r.SetBodyJsonMarshal(spec.JSON)
+	}
+	if len(spec.Form) > 0 {
+		r.SetFormDataAnyType(spec.Form)
+	}
+	for _, file := range spec.Files {
+		r.SetFileReader(file.ParamName, file.FileName, file.Reader)
+	}
+
+	result := &HttpResponse[T]{}
+	res := c.do(ctx, r.SetResult(result), method == http.MethodGet || method == http.MethodHead)
+	err = handleError[T](res, result, c.logger, false)
+	if err != nil {
+		return nil, err
+	}
+	return result.Result, nil
+}
+
+// ClientDoPaged 请求分页接口，并将分页结果中的Items解析为T
+func ClientDoPaged[T any](ctx context.Context, c *HttpClient, spec RequestSpec) (*TypedPagedResult[T], error) {
+	result, err := ClientDo[TypedPagedResult[T]](ctx, c, spec)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, ErrNoResult
+	}
+	return result, nil
+}
+
+// ClientEachPage 从第1页开始依次请求分页接口，直到取完全部数据或f返回错误
+func ClientEachPage[T any](ctx context.Context, c *HttpClient, spec RequestSpec, pageSize int, f func(page *TypedPagedResult[T]) error) error {
+	query := make(map[string]any, len(spec.Query)+2)
+	for k, v := range spec.Query {
+		query[k] = v
+	}
+	spec.Query = query
+	fetched := 0
+	for page := 1; ; page++ {
+		query[QueryPage] = page
+		query[QueryPageSize] = pageSize
+		result, err := ClientDoPaged[T](ctx, c, spec)
+		if err != nil {
+			return err
+		}
+		if err = f(result); err != nil {
+			return err
+		}
+		fetched += len(result.Items)
+		if len(result.Items) == 0 || fetched >= result.Total {
+			return nil
+		}
+	}
+}
+
+// ClientDoAllPages 请求分页接口的全部数据
+func ClientDoAllPages[T any](ctx context.Context, c *HttpClient, spec RequestSpec, pageSize int) ([]T, error) {
+	var items []T
+	err := ClientEachPage[T](ctx, c, spec, pageSize, func(page *TypedPagedResult[T]) error {
+		items = append(items, page.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -13,16 +13,36 @@ type CheckPermResult struct {
 	CustomPerm       interface{} `json:"customPerm"`
 }
 
+// CheckPermsResult 批量权限检查结果，Perms的键为权限码或Action.Key()
+type CheckPermsResult struct {
+	SkippedAuthCheck bool            `json:"skippedAuthCheck"`
+	User             *JwtUser        `json:"user"`
+	CustomAuth       interface{}     `json:"customAuth"`
+	Perms            map[string]bool `json:"perms"`
+	Skipped          []string        `json:"skipped,omitempty"` // 匹配公开路由而未检查的权限码或操作，不出现在Perms中
+}
+
 type CheckClientAuthResult struct {
-	ClientAuthOk bool `json:"clientAuthOk"`
+	ClientAuthOk bool   `json:"clientAuthOk"`
+	ClientId     string `json:"clientId,omitempty"`
 }
 
 type CheckClientPermResult struct {
 	ClientPermOk bool `json:"clientPermOk"`
 }
 
+type Action struct {
+	Service string `json:"service"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+}
+
+func (a Action) Key() string {
+	return a.Service + " " + a.Method + " " + a.Path
+}
+
 type Result interface {
-	CheckAuthResult | CheckPermResult | CheckClientAuthResult | CheckClientPermResult | any
+	CheckAuthResult | CheckPermResult | CheckPermsResult | CheckClientAuthResult | CheckClientPermResult | any
 }
 
 type PagedResult struct {
@@ -0,0 +1,397 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/go-logr/logr"
+)
+
+// CheckMode 混合检查时每类检查的决策方式
+type CheckMode int
+
+const (
+	CheckModeLocalThenRemote CheckMode = iota // 先在本地检查，本地无法决定时再访问鉴权服务
+	CheckModeLocalOnly                        // 只在本地检查
+	CheckModeRemoteOnly                       // 只访问鉴权服务
+)
+
+func (m CheckMode) String() string {
+	switch m {
+	case CheckModeLocalOnly:
+		return "local-only"
+	case CheckModeRemoteOnly:
+		return "remote-only"
+	default:
+		return "local-then-remote"
+	}
+}
+
+type HybridModes struct {
+	CheckAuth             CheckMode
+	CheckPermByCode       CheckMode
+	CheckPermByAction     CheckMode
+	CheckClientAuth       CheckMode
+	CheckClientPermByCode CheckMode
+}
+
+// HybridAuthChecker 组合LocalAuthChecker和HttpClient，本地完成令牌、会话和权限的检查，
+// 只有本地缺少对应的数据源（如需要补充自定义身份或权限信息）或本地数据源出错时才访问鉴权服务，
+// 本地明确拒绝的请求不会再访问鉴权服务。本地缺少数据源时不做任何本地检查，以免消耗一次性的访问码和随机码；
+// 启用了本地访问码或随机码时，本地数据源出错也不再访问鉴权服务。
+// HybridAuthChecker按请求头检查，实现IAuthClientCtx；AuthCheck返回按令牌检查的IAuthCheck
+type HybridAuthChecker struct {
+	Local  *LocalAuthChecker
+	Remote *HttpClient
+	Modes  HybridModes
+	logger logr.Logger
+}
+
+var (
+	_ IAuthClientCtx = (*HybridAuthChecker)(nil)
+	_ IAuthCheck     = (*hybridTokenChecker)(nil)
+)
+
+func (h *HybridAuthChecker) CheckAuth(f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return h.CheckAuthCtx(context.Background(), f, fulfillCustomAuth)
+}
+
+func (h *HybridAuthChecker) CheckPermByCode(f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return h.CheckPermByCodeCtx(context.Background(), f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (h *HybridAuthChecker) CheckPermByAction(f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return h.CheckPermByActionCtx(context.Background(), f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (h *HybridAuthChecker) CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return h.CheckClientAuthCtx(context.Background(), f)
+}
+
+func (h *HybridAuthChecker) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return h.CheckClientPermByCodeCtx(context.Background(), f, code)
+}
+
+func (h *HybridAuthChecker) CheckAuthCtx(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	ready := func() (bool, error) { return h.localReadyByHeader(f, "", "", false, fulfillCustomAuth) }
+	return decide(h, "CheckAuth", h.Modes.CheckAuth, ready, h.localOneTime(), func() (*CheckAuthResult, error) {
+		result, err := h.Local.CheckAuthByHeader(ctx, f, fulfillCustomAuth)
+		if err != nil {
+			return nil, err
+		}
+		if err = h.checkCustomProvider(result.SkippedAuthCheck, fulfillCustomAuth); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}, func() (*CheckAuthResult, error) {
+		return h.Remote.CheckAuthCtx(ctx, f, fulfillCustomAuth)
+	})
+}
+
+func (h *HybridAuthChecker) CheckPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	ready := func() (bool, error) {
+		return h.localReadyByHeader(f, "", "", true, fulfillCustomAuth || fulfillCustomPerm)
+	}
+	return decide(h, "CheckPermByCode", h.Modes.CheckPermByCode, ready, h.localOneTime(), func() (*CheckPermResult, error) {
+		result, err := h.Local.CheckPermByCodeByHeader(ctx, f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+		if err != nil {
+			return nil, err
+		}
+		if err = h.checkCustomProvider(result.SkippedAuthCheck, fulfillCustomAuth || fulfillCustomPerm); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}, func() (*CheckPermResult, error) {
+		return h.Remote.CheckPermByCodeCtx(ctx, f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	})
+}
+
+func (h *HybridAuthChecker) CheckPermByActionCtx(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	ready := func() (bool, error) {
+		return h.localReadyByHeader(f, method, path, true, fulfillCustomAuth || fulfillCustomPerm)
+	}
+	return decide(h, "CheckPermByAction", h.Modes.CheckPermByAction, ready, h.localOneTime(), func() (*CheckPermResult, error) {
+		result, err := h.Local.CheckPermByActionByHeader(ctx, f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+		if err != nil {
+			return nil, err
+		}
+		if err = h.checkCustomProvider(result.SkippedAuthCheck, fulfillCustomAuth || fulfillCustomPerm); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}, func() (*CheckPermResult, error) {
+		return h.Remote.CheckPermByActionCtx(ctx, f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	})
+}
+
+// CheckClientAuthCtx f为nil时检查当前服务自身的客户端，只能访问鉴权服务
+func (h *HybridAuthChecker) CheckClientAuthCtx(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error) {
+	mode := h.Modes.CheckClientAuth
+	if f == nil {
+		mode = CheckModeRemoteOnly
+	}
+	ready := func() (bool, error) { return h.localReadyForClient(false) && h.localReadyForNonce(), nil }
+	return decide(h, "CheckClientAuth", mode, ready, h.localOneTime(), func() (*CheckClientAuthResult, error) {
+		return h.Local.CheckClientAuthByHeader(ctx, f)
+	}, func() (*CheckClientAuthResult, error) {
+		return h.Remote.CheckClientAuthCtx(ctx, f)
+	})
+}
+
+func (h *HybridAuthChecker) CheckClientPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	mode := h.Modes.CheckClientPermByCode
+	if f == nil {
+		mode = CheckModeRemoteOnly
+	}
+	ready := func() (bool, error) { return h.localReadyForClient(true) && h.localReadyForNonce(), nil }
+	return decide(h, "CheckClientPermByCode", mode, ready, h.localOneTime(), func() (*CheckClientPermResult, error) {
+		return h.Local.CheckClientPermByCodeByHeader(ctx, f, code)
+	}, func() (*CheckClientPermResult, error) {
+		return h.Remote.CheckClientPermByCodeCtx(ctx, f, code)
+	})
+}
+
+// AuthCheck 返回按令牌和客户端id、秘钥检查的IAuthCheck，访问码和随机码只在本地检查
+func (h *HybridAuthChecker) AuthCheck() IAuthCheck {
+	return &hybridTokenChecker{h: h}
+}
+
+// localReadyByHeader 匹配本地公开路由时由本地放行，否则按localReadyForUser判断，并要求访问码和随机码的数据源已配置
+func (h *HybridAuthChecker) localReadyByHeader(f GetHeaderFun, method string, path string, perm bool, fulfillCustom bool) (bool, error) {
+	if h.Local.public.match(f, method, path) {
+		return true, nil
+	}
+	if h.Local.jwt == nil {
+		return false, nil
+	}
+	token, err := h.Local.ExtractUserToken(f)
+	if err != nil {
+		return false, err
+	}
+	ready, err := h.localReadyForUser(token, perm, fulfillCustom)
+	return ready && h.localReadyForNonce(), err
+}
+
+// localReadyForUser 本地是否配置了检查用户令牌所需的数据源，perm表示还需要检查权限，fulfillCustom表示需要补充自定义信息。
+// 本地无法决定时仍先校验令牌本身（不涉及会话、访问码和随机码），令牌不正确时直接返回错误
+func (h *HybridAuthChecker) localReadyForUser(token string, perm bool, fulfillCustom bool) (bool, error) {
+	local := h.Local
+	if local.jwt == nil {
+		return false, nil
+	}
+	if local.perms != nil || !perm {
+		if local.custom != nil || !fulfillCustom {
+			return true, nil
+		}
+	}
+	if len(token) == 0 {
+		return false, ErrUserTokenEmpty
+	}
+	if _, err := local.jwt.ValidateJwt(token); err != nil && isLocalRejection(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+// localReadyForClient 本地是否配置了检查客户端所需的数据源，perm表示还需要检查客户端权限
+func (h *HybridAuthChecker) localReadyForClient(perm bool) bool {
+	local := h.Local
+	return local.clients != nil && (!perm || local.clientPerms != nil)
+}
+
+// localReadyForNonce 本地启用访问码或随机码时是否配置了对应的数据源
+func (h *HybridAuthChecker) localReadyForNonce() bool {
+	local := h.Local
+	return (!local.Config.LocalAccessCode.Enable || local.accessCodes != nil) &&
+		(!local.Config.LocalRandomKey.Enable || local.randomKeys != nil)
+}
+
+// localOneTime 本地按请求头检查时是否会消耗一次性的访问码或随机码
+func (h *HybridAuthChecker) localOneTime() bool {
+	return h.Local.Config.LocalAccessCode.Enable || h.Local.Config.LocalRandomKey.Enable
+}
+
+// checkCustomProvider 需要补充自定义信息但本地未配置CustomProvider时，交由鉴权服务处理
+func (h *HybridAuthChecker) checkCustomProvider(skipped bool, fulfillCustom bool) error {
+	if !skipped && fulfillCustom && h.Local.custom == nil {
+		return ErrProviderNotConfigured
+	}
+	return nil
+}
+
+func (h *HybridAuthChecker) localCheckAuth(ctx context.Context, token string, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	result, err := h.Local.CheckAuth(ctx, token, fulfillCustomAuth)
+	if err == nil {
+		err = h.checkCustomProvider(false, fulfillCustomAuth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (h *HybridAuthChecker) localCheckPermByCode(ctx context.Context, token string, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	result, err := h.Local.CheckPermByCode(ctx, token, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	if err == nil {
+		err = h.checkCustomProvider(false, fulfillCustomAuth || fulfillCustomPerm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (h *HybridAuthChecker) localCheckPermByAction(ctx context.Context, token string, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	result, err := h.Local.CheckPermByAction(ctx, token, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.checkCustomProvider(false, fulfillCustomAuth || fulfillCustomPerm); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// decide 按mode选择本地或远程检查。先本地后远程时先调用ready，返回false表示本地缺少所需的数据源，直接访问鉴权服务，
+// 返回错误表示本地已能明确拒绝；本地检查出错且不是明确拒绝时改为访问鉴权服务，但oneTime为true时本地可能已消耗一次性的访问码或随机码，
+// 鉴权服务会将其视为重放，因此直接返回本地的错误
+func decide[T any](h *HybridAuthChecker, name string, mode CheckMode, ready func() (bool, error), oneTime bool, local func() (T, error), remote func() (T, error)) (T, error) {
+	useLocal := mode == CheckModeLocalOnly
+	if mode == CheckModeLocalThenRemote {
+		ok, err := ready()
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		useLocal = ok
+	}
+	if useLocal {
+		result, err := local()
+		if mode == CheckModeLocalOnly || err == nil || isLocalRejection(err) {
+			return result, err
+		}
+		if oneTime {
+			h.logger.Error(err, "local auth check failed after consuming access code or random key", "check", name)
+			return result, err
+		}
+		if !errors.Is(err, ErrProviderNotConfigured) {
+			h.logger.Error(err, "local auth check failed, fall back to auth service", "check", name)
+		}
+	}
+	return remote()
+}
+
+// isLocalRejection 本地检查明确拒绝了请求，无需再访问鉴权服务
+func isLocalRejection(err error) bool {
+	for _, target := range []error{
+		ErrAccessCodeEmpty, ErrAccessCodeFail, ErrRandomKeyEmpty, ErrRandomKeyFail,
+		ErrUserTokenEmpty, ErrJwtErrFormat, ErrJwtErrVersion,
+		ErrClientTokenEmpty, ErrClientIdOrSecretEmpty, ErrClientTokenFail, ErrDecryptFail,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return IsDeniedError(err)
+}
+
+// hybridTokenChecker 按令牌检查，访问鉴权服务时将令牌和客户端id、秘钥转换为请求头
+type hybridTokenChecker struct {
+	h *HybridAuthChecker
+}
+
+func (c *hybridTokenChecker) IsAccessCodeOk(ctx context.Context, code string) (bool, error) {
+	return c.h.Local.IsAccessCodeOk(ctx, code)
+}
+
+func (c *hybridTokenChecker) IsRandomKeyOk(ctx context.Context, key string) (bool, error) {
+	return c.h.Local.IsRandomKeyOk(ctx, key)
+}
+
+func (c *hybridTokenChecker) CheckAuth(ctx context.Context, userToken string, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return decide(c.h, "CheckAuth", c.h.Modes.CheckAuth, func() (bool, error) {
+		return c.h.localReadyForUser(userToken, false, fulfillCustomAuth)
+	}, false, func() (*CheckAuthResult, error) {
+		return c.h.localCheckAuth(ctx, userToken, fulfillCustomAuth)
+	}, func() (*CheckAuthResult, error) {
+		return c.h.Remote.CheckAuthCtx(ctx, c.userHeader(userToken), fulfillCustomAuth)
+	})
+}
+
+func (c *hybridTokenChecker) CheckPermByCode(ctx context.Context, userToken string, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return decide(c.h, "CheckPermByCode", c.h.Modes.CheckPermByCode, func() (bool, error) {
+		return c.h.localReadyForUser(userToken, true, fulfillCustomAuth || fulfillCustomPerm)
+	}, false, func() (*CheckPermResult, error) {
+		return c.h.localCheckPermByCode(ctx, userToken, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	}, func() (*CheckPermResult, error) {
+		return c.h.Remote.CheckPermByCodeCtx(ctx, c.userHeader(userToken), code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	})
+}
+
+func (c *hybridTokenChecker) CheckPermByAction(ctx context.Context, userToken string, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (CheckPermResult, error) {
+	result, err := decide(c.h, "CheckPermByAction", c.h.Modes.CheckPermByAction, func() (bool, error) {
+		return c.h.localReadyForUser(userToken, true, fulfillCustomAuth || fulfillCustomPerm)
+	}, false, func() (*CheckPermResult, error) {
+		return c.h.localCheckPermByAction(ctx, userToken, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	}, func() (*CheckPermResult, error) {
+		return c.h.Remote.CheckPermByActionCtx(ctx, c.userHeader(userToken), service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	})
+	if err != nil {
+		return CheckPermResult{}, err
+	}
+	return *result, nil
+}
+
+func (c *hybridTokenChecker) CheckClientAuth(ctx context.Context, clientId string, clientSecret string) (*CheckClientAuthResult, error) {
+	return decide(c.h, "CheckClientAuth", c.h.Modes.CheckClientAuth, func() (bool, error) { return c.h.localReadyForClient(false), nil }, false, func() (*CheckClientAuthResult, error) {
+		return c.h.Local.CheckClientAuth(ctx, clientId, clientSecret)
+	}, func() (*CheckClientAuthResult, error) {
+		f, err := c.clientHeader(clientId, clientSecret)
+		if err != nil {
+			return nil, err
+		}
+		return c.h.Remote.CheckClientAuthCtx(ctx, f)
+	})
+}
+
+func (c *hybridTokenChecker) CheckClientPermByCode(ctx context.Context, clientId string, clientSecret string, code string) (*CheckClientPermResult, error) {
+	return decide(c.h, "CheckClientPermByCode", c.h.Modes.CheckClientPermByCode, func() (bool, error) { return c.h.localReadyForClient(true), nil }, false, func() (*CheckClientPermResult, error) {
+		return c.h.Local.CheckClientPermByCode(ctx, clientId, clientSecret, code)
+	}, func() (*CheckClientPermResult, error) {
+		f, err := c.clientHeader(clientId, clientSecret)
+		if err != nil {
+			return nil, err
+		}
+		return c.h.Remote.CheckClientPermByCodeCtx(ctx, f, code)
+	})
+}
+
+func (c *hybridTokenChecker) userHeader(userToken string) GetHeaderFun {
+	config := c.h.Remote.Config.User
+	return func(key string) string {
+		if key == config.Header && len(userToken) > 0 {
+			return config.HeaderSchema + " " + userToken
+		}
+		return ""
+	}
+}
+
+func (c *hybridTokenChecker) clientHeader(clientId string, clientSecret string) (GetHeaderFun, error) {
+	if len(clientId) == 0 || len(clientSecret) == 0 {
+		return nil, ErrClientIdOrSecretEmpty
+	}
+	config := c.h.Remote.Config.Client
+	var aes *AesUtil
+	if config.EncryptContent {
+		aes = c.h.Remote.AesUtil
+	}
+	token, err := GenerateClientToken(clientId, clientSecret, aes)
+	if err != nil {
+		return nil, err
+	}
+	return func(key string) string {
+		if key == config.Header {
+			return config.HeaderSchema + " " + token
+		}
+		return ""
+	}, nil
+}
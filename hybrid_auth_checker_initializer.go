@@ -0,0 +1,32 @@
+package auth
+
+import "github.com/go-logr/logr"
+
+type HybridOption func(checker *HybridAuthChecker)
+
+// WithHybridModes 设置每类检查的决策方式，默认全部为CheckModeLocalThenRemote
+func WithHybridModes(modes HybridModes) HybridOption {
+	return func(checker *HybridAuthChecker) {
+		checker.Modes = modes
+	}
+}
+
+func WithHybridLogger(logger logr.Logger) HybridOption {
+	return func(checker *HybridAuthChecker) {
+		checker.logger = logger
+	}
+}
+
+func NewHybridAuthChecker(local *LocalAuthChecker, remote *HttpClient, options ...HybridOption) *HybridAuthChecker {
+	if local == nil || remote == nil {
+		panic("请同时配置本地检查和远程检查")
+	}
+	checker := &HybridAuthChecker{Local: local, Remote: remote}
+	for _, opt := range options {
+		opt(checker)
+	}
+	if checker.logger.GetSink() == nil {
+		checker.logger = logr.Discard()
+	}
+	return checker
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	JWKSContentType   = "application/jwk-set+json"
+	DefaultJWKSMaxAge = 5 * time.Minute
+)
+
+// JWK RFC 7517格式的公钥，支持RSA、EC（P-256、P-384、P-521）和OKP（Ed25519）
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS RFC 7517格式的公钥集合
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK 将公钥转换为用于签名校验的JWK
+func NewJWK(kid string, algorithm string, key crypto.PublicKey) (JWK, error) {
+	jwk := JWK{Kid: kid, Use: "sig", Alg: algorithm}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = k.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	default:
+		return JWK{}, fmt.Errorf("%w: 不支持的公钥类型%T", ErrJwtAlgorithm, key)
+	}
+	return jwk, nil
+}
+
+// PublicKey 解析JWK中的公钥
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: RSA公钥指数错误", ErrJwtAlgorithm)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: 不支持的曲线%s", ErrJwtAlgorithm, k.Crv)
+		}
+		x, err := decodeJWKInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("%w: EC公钥不在曲线%s上", ErrJwtAlgorithm, k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: 不支持的曲线%s", ErrJwtAlgorithm, k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: Ed25519公钥错误", ErrJwtAlgorithm)
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("%w: 不支持的公钥类型%s", ErrJwtAlgorithm, k.Kty)
+	}
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("%w: JWK参数错误", ErrJwtAlgorithm)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// NewJWKS 将秘钥环中now时未退役的公钥转换为JWKS，尚未开始签发的秘钥也会提前发布
+func NewJWKS(keyring *JwtKeyring, now time.Time) (*JWKS, error) {
+	jwks := &JWKS{Keys: []JWK{}}
+	for _, entry := range keyring.Entries() {
+		if entry.retired(now) {
+			continue
+		}
+		jwk, err := NewJWK(entry.Kid, entry.Algorithm, entry.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// JWKSHandler 以JWKS格式输出RedisJwtUtil的校验公钥，供网关和其他服务通过JWKSVerifier校验令牌
+type JWKSHandler struct {
+	// Util 每次请求时调用，使用Reloader时可传入Reloader.Current
+	Util func() *RedisJwtUtil
+	// MaxAge 响应头Cache-Control的max-age，为0时使用DefaultJWKSMaxAge
+	MaxAge time.Duration
+}
+
+func NewJWKSHandler(util *RedisJwtUtil) *JWKSHandler {
+	return &JWKSHandler{Util: func() *RedisJwtUtil { return util }}
+}
+
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	keyring, err := h.Util().keyring()
+	var jwks *JWKS
+	if err == nil {
+		jwks, err = NewJWKS(keyring, time.Now())
+	}
+	if err != nil {
+		http.Error(w, MsgInternalError, http.StatusInternalServerError)
+		return
+	}
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultJWKSMaxAge
+	}
+	w.Header().Set("Content-Type", JWKSContentType)
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	_ = json.NewEncoder(w).Encode(jwks)
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultJWKSRefreshInterval    = 5 * time.Minute
+	DefaultJWKSMinRefreshInterval = 30 * time.Second
+	DefaultJWKSTimeout            = 10 * time.Second
+)
+
+// JWKSVerifier 从JWKS地址下载公钥并缓存，按令牌头中的kid选择公钥离线校验令牌，不需要私钥和redis。
+// 遇到未知的kid时重新下载（两次下载至少间隔minRefreshInterval），Run可在后台定时刷新
+type JWKSVerifier struct {
+	url                string
+	httpClient         *http.Client
+	algorithms         []string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	logger             logr.Logger
+
+	keys      atomic.Pointer[map[string]*jwksKey]
+	lock      sync.Mutex
+	fetchedAt time.Time
+}
+
+type jwksKey struct {
+	publicKey  crypto.PublicKey
+	algorithms []string
+}
+
+func (v *JWKSVerifier) ValidateJwt(tokenString string) (*JwtUser, error) {
+	return v.ValidateJwtCtx(context.Background(), tokenString)
+}
+
+// ValidateJwtCtx 尚未成功下载JWKS或kid未知且重新下载失败时返回下载错误或ErrAuthServiceUnavailable，令牌无效或kid未知时返回ErrJwtErrFormat
+func (v *JWKSVerifier) ValidateJwtCtx(ctx context.Context, tokenString string) (*JwtUser, error) {
+	if err := v.load(ctx); err != nil {
+		return nil, err
+	}
+	return parseJwtUser(tokenString, v.lookup(ctx))
+}
+
+func (v *JWKSVerifier) ValidateJwtClaims(tokenString string) (map[string]any, error) {
+	ctx := context.Background()
+	if err := v.load(ctx); err != nil {
+		return nil, err
+	}
+	return parseJwtAccessClaims(tokenString, v.lookup(ctx))
+}
+
+// load 尚未下载JWKS时下载一次
+func (v *JWKSVerifier) load(ctx context.Context) error {
+	if v.keys.Load() != nil {
+		return nil
+	}
+	if err := v.refresh(ctx, true); err != nil {
+		return err
+	}
+	if v.keys.Load() == nil {
+		return ErrAuthServiceUnavailable
+	}
+	return nil
+}
+
+// lookup 遇到未知的kid时重新下载JWKS
+func (v *JWKSVerifier) lookup(ctx context.Context) jwtKeyLookup {
+	return func(kid string) (crypto.PublicKey, []string, error) {
+		key, ok := (*v.keys.Load())[kid]
+		if !ok {
+			err := v.refresh(ctx, true)
+			if key, ok = (*v.keys.Load())[kid]; !ok {
+				if err != nil {
+					v.logger.Error(err, "refresh jwks for unknown kid failed", "kid", kid)
+					return nil, nil, err
+				}
+				return nil, nil, ErrJwtErrFormat
+			}
+		}
+		return key.publicKey, key.algorithms, nil
+	}
+}
+
+// Refresh 立即下载JWKS，失败时保留已缓存的公钥
+func (v *JWKSVerifier) Refresh(ctx context.Context) error {
+	return v.refresh(ctx, false)
+}
+
+// Run 按refreshInterval定时刷新，直到ctx结束，通常在单独的goroutine中调用
+func (v *JWKSVerifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(v.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.Refresh(ctx); err != nil {
+				v.logger.Error(err, "refresh jwks failed, keep cached keys")
+			}
+		}
+	}
+}
+
+// refresh throttled为true时，距上次下载不足minRefreshInterval则跳过，避免伪造的kid或下载失败导致频繁下载
+func (v *JWKSVerifier) refresh(ctx context.Context, throttled bool) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if throttled && time.Since(v.fetchedAt) < v.minRefreshInterval {
+		return nil
+	}
+	v.fetchedAt = time.Now()
+	jwks, err := v.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*jwksKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := v.parseKey(jwk)
+		if err != nil {
+			v.logger.Info("skip jwk", "kid", jwk.Kid, "reason", err.Error())
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	v.keys.Store(&keys)
+	return nil
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (*JWKS, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := v.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 下载JWKS失败: %s", ErrAuthServiceUnavailable, err.Error())
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: 下载JWKS失败: %s", ErrAuthServiceUnavailable, res.Status)
+	}
+	jwks := &JWKS{}
+	if err = json.NewDecoder(res.Body).Decode(jwks); err != nil {
+		return nil, fmt.Errorf("%w: JWKS格式错误: %s", ErrNoResult, err.Error())
+	}
+	return jwks, nil
+}
+
+// parseKey 跳过非签名用途的公钥，alg为空时允许的算法由algorithms或公钥类型决定
+func (v *JWKSVerifier) parseKey(jwk JWK) (*jwksKey, error) {
+	if len(jwk.Use) > 0 && jwk.Use != "sig" {
+		return nil, fmt.Errorf("use为%s", jwk.Use)
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	key := &jwksKey{publicKey: publicKey, algorithms: v.algorithms}
+	if len(jwk.Alg) > 0 {
+		if err = CheckJwtAlgorithm(jwk.Alg, publicKey); err != nil {
+			return nil, err
+		}
+		if len(v.algorithms) > 0 && !isAllowedJwtAlgorithm(v.algorithms, jwk.Alg) {
+			return nil, fmt.Errorf("%w: %s", ErrJwtAlgorithm, jwk.Alg)
+		}
+		key.algorithms = []string{jwk.Alg}
+	}
+	return key, nil
+}
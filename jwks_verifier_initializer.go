@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/go-logr/logr"
+	"net/http"
+	"time"
+)
+
+type JWKSVerifierOption func(verifier *JWKSVerifier)
+
+// WithJWKSHttpClient 设置下载JWKS使用的http.Client，默认超时为DefaultJWKSTimeout
+func WithJWKSHttpClient(client *http.Client) JWKSVerifierOption {
+	return func(verifier *JWKSVerifier) {
+		verifier.httpClient = client
+	}
+}
+
+// WithJWKSAlgorithms 允许的签名算法，为空时按JWK中的alg或公钥类型决定
+func WithJWKSAlgorithms(algorithms ...string) JWKSVerifierOption {
+	return func(verifier *JWKSVerifier) {
+		verifier.algorithms = algorithms
+	}
+}
+
+// WithJWKSRefreshInterval 设置Run的刷新间隔和遇到未知kid时两次下载的最小间隔
+func WithJWKSRefreshInterval(refreshInterval time.Duration, minRefreshInterval time.Duration) JWKSVerifierOption {
+	return func(verifier *JWKSVerifier) {
+		verifier.refreshInterval = refreshInterval
+		verifier.minRefreshInterval = minRefreshInterval
+	}
+}
+
+func WithJWKSLogger(logger logr.Logger) JWKSVerifierOption {
+	return func(verifier *JWKSVerifier) {
+		verifier.logger = logger
+	}
+}
+
+// NewJWKSVerifier 首次校验令牌时下载JWKS，也可以先调用Refresh提前下载
+func NewJWKSVerifier(url string, options ...JWKSVerifierOption) *JWKSVerifier {
+	if len(url) == 0 {
+		panic("JWKS地址配置错误")
+	}
+	verifier := &JWKSVerifier{
+		url:                url,
+		refreshInterval:    DefaultJWKSRefreshInterval,
+		minRefreshInterval: DefaultJWKSMinRefreshInterval,
+	}
+	for _, opt := range options {
+		opt(verifier)
+	}
+	if verifier.httpClient == nil {
+		verifier.httpClient = &http.Client{Timeout: DefaultJWKSTimeout}
+	}
+	if verifier.refreshInterval <= 0 {
+		verifier.refreshInterval = DefaultJWKSRefreshInterval
+	}
+	if verifier.minRefreshInterval < 0 {
+		verifier.minRefreshInterval = 0
+	}
+	for _, algorithm := range verifier.algorithms {
+		if _, ok := jwtSigningMethods[algorithm]; !ok {
+			panic(fmt.Sprintf("不支持的令牌签名算法: %s", algorithm))
+		}
+	}
+	if verifier.logger.GetSink() == nil {
+		verifier.logger = logr.Discard()
+	}
+	return verifier
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	JwtAlgorithmRS256 = "RS256"
+	JwtAlgorithmRS384 = "RS384"
+	JwtAlgorithmRS512 = "RS512"
+	JwtAlgorithmPS256 = "PS256"
+	JwtAlgorithmPS384 = "PS384"
+	JwtAlgorithmPS512 = "PS512"
+	JwtAlgorithmES256 = "ES256"
+	JwtAlgorithmES384 = "ES384"
+	JwtAlgorithmES512 = "ES512"
+	JwtAlgorithmEdDSA = "EdDSA"
+
+	DefaultJwtAlgorithm = JwtAlgorithmRS256
+)
+
+// jwtSigningMethods 支持的签名算法，只包含非对称算法：不支持none，也不支持HS系列，避免把公钥当作HMAC秘钥的算法混淆攻击
+var jwtSigningMethods = map[string]jwt.SigningMethod{
+	JwtAlgorithmRS256: jwt.SigningMethodRS256,
+	JwtAlgorithmRS384: jwt.SigningMethodRS384,
+	JwtAlgorithmRS512: jwt.SigningMethodRS512,
+	JwtAlgorithmPS256: jwt.SigningMethodPS256,
+	JwtAlgorithmPS384: jwt.SigningMethodPS384,
+	JwtAlgorithmPS512: jwt.SigningMethodPS512,
+	JwtAlgorithmES256: jwt.SigningMethodES256,
+	JwtAlgorithmES384: jwt.SigningMethodES384,
+	JwtAlgorithmES512: jwt.SigningMethodES512,
+	JwtAlgorithmEdDSA: jwt.SigningMethodEdDSA,
+}
+
+// CheckJwtAlgorithm 检查算法是否受支持，以及key（公钥或私钥）的类型和曲线是否与算法匹配
+func CheckJwtAlgorithm(algorithm string, key any) error {
+	if _, ok := jwtSigningMethods[algorithm]; !ok {
+		return fmt.Errorf("%w: %s", ErrJwtAlgorithm, algorithm)
+	}
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	var match bool
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch algorithm {
+		case JwtAlgorithmRS256, JwtAlgorithmRS384, JwtAlgorithmRS512, JwtAlgorithmPS256, JwtAlgorithmPS384, JwtAlgorithmPS512:
+			match = true
+		}
+	case *ecdsa.PublicKey:
+		match = algorithm == ecdsaJwtAlgorithm(k.Curve)
+	case ed25519.PublicKey:
+		match = algorithm == JwtAlgorithmEdDSA
+	}
+	if !match {
+		return fmt.Errorf("%w: 秘钥类型与%s不匹配", ErrJwtAlgorithm, algorithm)
+	}
+	return nil
+}
+
+// DefaultJwtAlgorithmOf 根据公钥类型选择默认算法，RSA使用RS256，ECDSA按曲线选择ES256/ES384/ES512，Ed25519使用EdDSA
+func DefaultJwtAlgorithmOf(key crypto.PublicKey) string {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsaJwtAlgorithm(k.Curve)
+	case ed25519.PublicKey:
+		return JwtAlgorithmEdDSA
+	default:
+		return DefaultJwtAlgorithm
+	}
+}
+
+func isAllowedJwtAlgorithm(algorithms []string, algorithm string) bool {
+	for _, allowed := range algorithms {
+		if allowed == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+func ecdsaJwtAlgorithm(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return JwtAlgorithmES256
+	case elliptic.P384():
+		return JwtAlgorithmES384
+	case elliptic.P521():
+		return JwtAlgorithmES512
+	default:
+		return ""
+	}
+}
+
+// ParsePrivateKeyFromPEM 解析PKCS8、PKCS1（RSA）或SEC1（ECDSA）格式的私钥
+func ParsePrivateKeyFromPEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: 无法解析私钥", ErrJwtAlgorithm)
+}
+
+// ParsePublicKeyFromPEM 解析PKIX、PKCS1（RSA）格式的公钥或证书中的公钥
+func ParsePublicKeyFromPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: 无法解析公钥", ErrJwtAlgorithm)
+}
+
+// publicKeyEqual 判断两个公钥是否相同，用于检查秘钥对是否匹配
+func publicKeyEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+// publicKeyOf 返回私钥对应的公钥
+func publicKeyOf(key crypto.PrivateKey) crypto.PublicKey {
+	if signer, ok := key.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
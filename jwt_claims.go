@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"time"
+)
+
+// jwtStandardClaims JwtUser中的标准声明，其余声明保存在JwtUser.Extra中
+var jwtStandardClaims = []string{
+	JwtTokenClaimsId,
+	JwtTokenClaimsName,
+	JwtTokenClaimsKind,
+	JwtTokenClaimsDeviceId,
+	JwtTokenClaimsIssuer,
+	JwtTokenClaimsIssueAt,
+	JwtTokenClaimsExpireAt,
+}
+
+// JwtClaims 自定义声明的约束，C必须嵌入RawJwtUser，例如
+//
+//	type MyClaims struct {
+//		auth.RawJwtUser
+//		Tenant string   `json:"tenant"`
+//		Roles  []string `json:"roles"`
+//	}
+type JwtClaims[C any] interface {
+	*C
+	rawJwtUser() *RawJwtUser
+}
+
+func (u *RawJwtUser) rawJwtUser() *RawJwtUser {
+	return u
+}
+
+// JwtClaimsValidator 校验令牌并返回全部声明，RedisJwtUtil、JwtVerifier和JWKSVerifier实现了该接口
+type JwtClaimsValidator interface {
+	ValidateJwtClaims(tokenString string) (map[string]any, error)
+}
+
+var (
+	_ JwtClaimsValidator = (*RedisJwtUtil)(nil)
+	_ JwtClaimsValidator = (*JwtVerifier)(nil)
+	_ JwtClaimsValidator = (*JWKSVerifier)(nil)
+)
+
+// GenerateJwtWithClaims 与GenerateJwt相同，但签发claims中的全部字段，Iss为空时使用配置的签发者，Iat为0时使用当前时间，并写回claims。
+// 返回的JwtUser.Extra为标准声明以外的声明
+func GenerateJwtWithClaims[C any, P JwtClaims[C]](j *RedisJwtUtil, claims P) (*JwtUser, error) {
+	raw := claims.rawJwtUser()
+	if len(raw.Iss) == 0 {
+		if len(j.Config.Issuer) == 0 {
+			j.Config.Issuer = DefaultIssuer
+		}
+		raw.Iss = j.Config.Issuer
+	}
+	if raw.Iat == 0 {
+		raw.Iat = float64(time.Now().Unix())
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	mapClaims := jwt.MapClaims{}
+	if err = json.Unmarshal(data, &mapClaims); err != nil {
+		return nil, err
+	}
+	token, err := j.signJwt(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+	return &JwtUser{RawJwtUser: *raw, Token: token, Extra: extraJwtClaims(mapClaims)}, nil
+}
+
+// ValidateJwtInto 校验令牌并将声明解析为C，缺少标准声明或声明的类型与C不匹配时返回ErrJwtErrVersion
+func ValidateJwtInto[C any, P JwtClaims[C]](v JwtClaimsValidator, tokenString string) (P, error) {
+	claims, err := v.ValidateJwtClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	out := P(new(C))
+	if err = decodeJwtClaims(claims, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeJwtClaims 检查标准声明都存在，再通过json转换为out，类型不匹配时返回ErrJwtErrVersion而不是panic
+func decodeJwtClaims(claims map[string]any, out any) error {
+	for _, name := range jwtStandardClaims {
+		if claims[name] == nil {
+			return ErrJwtErrVersion
+		}
+	}
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return ErrJwtErrVersion
+	}
+	if err = json.Unmarshal(data, out); err != nil {
+		return ErrJwtErrVersion
+	}
+	return nil
+}
+
+// extraJwtClaims 返回标准声明以外的声明，没有时返回nil
+func extraJwtClaims(claims map[string]any) map[string]any {
+	var extra map[string]any
+	for name, val := range claims {
+		if isJwtStandardClaim(name) {
+			continue
+		}
+		if extra == nil {
+			extra = map[string]any{}
+		}
+		extra[name] = val
+	}
+	return extra
+}
+
+func isJwtStandardClaim(name string) bool {
+	for _, standard := range jwtStandardClaims {
+		if name == standard {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// JwtKey 秘钥环中的一个秘钥，Kid写入令牌头，用于校验时选择公钥
+type JwtKey struct {
+	Kid               string    `json:"kid" yaml:"kid"`                             // 为空时签发的令牌不带kid，校验不带kid的令牌时使用，最多一个
+	Algorithm         string    `json:"algorithm" yaml:"algorithm"`                 // 为空时按秘钥类型选择
+	AllowedAlgorithms []string  `json:"allowedAlgorithms" yaml:"allowedAlgorithms"` // 校验时允许的算法，为空时只允许Algorithm
+	PublicKey         PemBytes  `json:"publicKey" yaml:"publicKey"`                 // 为空时由私钥得到
+	PrivateKey        PemBytes  `json:"privateKey" yaml:"privateKey"`               // 为空时只用于校验，如已停止签发的旧秘钥
+	NotBefore         time.Time `json:"notBefore" yaml:"notBefore"`                 // 开始签发的时间，零值表示立即，之前只用于校验
+	NotAfter          time.Time `json:"notAfter" yaml:"notAfter"`                   // 退役时间，之后不再签发和校验，零值表示不退役
+}
+
+// JwtKeyringEntry 解析后的秘钥
+type JwtKeyringEntry struct {
+	Kid               string
+	Algorithm         string
+	AllowedAlgorithms []string
+	PublicKey         crypto.PublicKey
+	PrivateKey        crypto.PrivateKey
+	NotBefore         time.Time
+	NotAfter          time.Time
+}
+
+func (e *JwtKeyringEntry) algorithms() []string {
+	if len(e.AllowedAlgorithms) > 0 {
+		return e.AllowedAlgorithms
+	}
+	return []string{e.Algorithm}
+}
+
+func (e *JwtKeyringEntry) retired(now time.Time) bool {
+	return !e.NotAfter.IsZero() && !now.Before(e.NotAfter)
+}
+
+func (e *JwtKeyringEntry) canSign(now time.Time) bool {
+	return e.PrivateKey != nil && !now.Before(e.NotBefore) && !e.retired(now)
+}
+
+// JwtKeyring 签名和校验令牌的秘钥集合。
+// 签名使用已生效、未退役且NotBefore最晚的私钥，校验按令牌头中的kid选择未退役的公钥，
+// 轮换时提前加入带NotBefore的新秘钥，旧秘钥保留到已签发的令牌全部过期后再设置NotAfter或移除
+type JwtKeyring struct {
+	entries []*JwtKeyringEntry
+}
+
+// NewJwtKeyring 解析秘钥，返回的错误为ConfigErrors
+func NewJwtKeyring(keys ...JwtKey) (*JwtKeyring, error) {
+	problems := configProblems{}
+	keyring := &JwtKeyring{}
+	for i, key := range keys {
+		keyring.add(&problems, fmt.Sprintf("keys[%d]", i), key)
+	}
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// NewJwtKeyringWithEntries 使用已解析的秘钥创建秘钥环，如从KMS获取的秘钥，返回的错误为ConfigErrors
+func NewJwtKeyringWithEntries(entries ...*JwtKeyringEntry) (*JwtKeyring, error) {
+	problems := configProblems{}
+	keyring := &JwtKeyring{}
+	for i, entry := range entries {
+		keyring.check(&problems, fmt.Sprintf("keys[%d]", i), entry)
+		keyring.entries = append(keyring.entries, entry)
+	}
+	if err := problems.err(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// add 解析并加入秘钥，问题记录到problems，name为秘钥在配置中的路径
+func (r *JwtKeyring) add(problems *configProblems, name string, key JwtKey) {
+	entry := &JwtKeyringEntry{
+		Kid:               key.Kid,
+		Algorithm:         key.Algorithm,
+		AllowedAlgorithms: key.AllowedAlgorithms,
+		NotBefore:         key.NotBefore,
+		NotAfter:          key.NotAfter,
+	}
+	var err error
+	if len(key.PrivateKey) > 0 {
+		if entry.PrivateKey, err = ParsePrivateKeyFromPEM(key.PrivateKey); err != nil {
+			problems.add(name+".privateKey", "%s", err.Error())
+			return
+		}
+	}
+	if len(key.PublicKey) > 0 {
+		if entry.PublicKey, err = ParsePublicKeyFromPEM(key.PublicKey); err != nil {
+			problems.add(name+".publicKey", "%s", err.Error())
+			return
+		}
+	}
+	r.check(problems, name, entry)
+	r.entries = append(r.entries, entry)
+}
+
+// check 补全公钥和默认算法，并检查秘钥对、算法、kid和有效期
+func (r *JwtKeyring) check(problems *configProblems, name string, entry *JwtKeyringEntry) {
+	if entry.PrivateKey != nil {
+		public := publicKeyOf(entry.PrivateKey)
+		if entry.PublicKey == nil {
+			entry.PublicKey = public
+		} else if !publicKeyEqual(entry.PublicKey, public) {
+			problems.add(name+".publicKey", "与私钥不匹配")
+		}
+	}
+	if entry.PublicKey == nil {
+		problems.add(name+".publicKey", "不能为空")
+		return
+	}
+	if len(entry.Algorithm) == 0 {
+		entry.Algorithm = DefaultJwtAlgorithmOf(entry.PublicKey)
+	}
+	if err := CheckJwtAlgorithm(entry.Algorithm, entry.PublicKey); err != nil {
+		problems.add(name+".algorithm", "%s", err.Error())
+	}
+	for _, algorithm := range entry.AllowedAlgorithms {
+		if err := CheckJwtAlgorithm(algorithm, entry.PublicKey); err != nil {
+			problems.add(name+".allowedAlgorithms", "%s", err.Error())
+		}
+	}
+	for _, other := range r.entries {
+		if other.Kid == entry.Kid {
+			problems.add(name+".kid", "重复: %s", entry.Kid)
+			break
+		}
+	}
+	if !entry.NotAfter.IsZero() && !entry.NotBefore.Before(entry.NotAfter) {
+		problems.add(name+".notAfter", "必须晚于notBefore")
+	}
+}
+
+// Entries 返回全部秘钥，包括尚未生效和已退役的秘钥
+func (r *JwtKeyring) Entries() []*JwtKeyringEntry {
+	return r.entries
+}
+
+// SigningKey 返回now时用于签名的秘钥
+func (r *JwtKeyring) SigningKey(now time.Time) (*JwtKeyringEntry, error) {
+	candidates := make([]*JwtKeyringEntry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		if entry.canSign(now) {
+			candidates = append(candidates, entry)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrJwtNoSigningKey
+	}
+	// NotBefore相同时使用靠后的秘钥
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].NotBefore.Before(candidates[j].NotBefore)
+	})
+	return candidates[len(candidates)-1], nil
+}
+
+// VerificationKey 返回now时kid对应的未退役秘钥，尚未开始签发的秘钥也可用于校验
+func (r *JwtKeyring) VerificationKey(kid string, now time.Time) (*JwtKeyringEntry, error) {
+	for _, entry := range r.entries {
+		if entry.Kid == kid && !entry.retired(now) {
+			return entry, nil
+		}
+	}
+	return nil, ErrJwtErrFormat
+}
+
+// lookup 用于parseJwtUser，按kid选择公钥和允许的算法
+func (r *JwtKeyring) lookup(kid string) (crypto.PublicKey, []string, error) {
+	entry, err := r.VerificationKey(kid, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return entry.PublicKey, entry.algorithms(), nil
+}
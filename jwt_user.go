@@ -12,5 +12,6 @@ type RawJwtUser struct {
 
 type JwtUser struct {
 	RawJwtUser
-	Token string `json:"token"` // 令牌字符串
+	Token string         `json:"token"`           // 令牌字符串
+	Extra map[string]any `json:"extra,omitempty"` // 标准声明以外的自定义声明，见GenerateJwtWithClaims
 }
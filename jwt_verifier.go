@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JwtVerifier 只持有公钥，离线校验令牌的签名、有效期和声明，不访问redis和鉴权服务
+type JwtVerifier struct {
+	PublicKey crypto.PublicKey
+	// Algorithms 允许的签名算法，为空时只允许公钥类型对应的默认算法，见DefaultJwtAlgorithmOf
+	Algorithms []string
+}
+
+// NewJwtVerifier 解析PEM格式的RSA、ECDSA或Ed25519公钥，algorithms必须与公钥类型匹配
+func NewJwtVerifier(publicKey []byte, algorithms ...string) (*JwtVerifier, error) {
+	key, err := ParsePublicKeyFromPEM(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, algorithm := range algorithms {
+		if err = CheckJwtAlgorithm(algorithm, key); err != nil {
+			return nil, err
+		}
+	}
+	return &JwtVerifier{PublicKey: key, Algorithms: algorithms}, nil
+}
+
+func (v *JwtVerifier) ValidateJwt(tokenString string) (*JwtUser, error) {
+	return parseJwtUser(tokenString, v.lookup)
+}
+
+func (v *JwtVerifier) ValidateJwtClaims(tokenString string) (map[string]any, error) {
+	return parseJwtAccessClaims(tokenString, v.lookup)
+}
+
+func (v *JwtVerifier) lookup(string) (crypto.PublicKey, []string, error) {
+	return v.PublicKey, v.Algorithms, nil
+}
+
+// jwtKeyLookup 按令牌头中的kid返回校验使用的公钥和允许的算法，算法为空时只允许公钥类型对应的默认算法
+type jwtKeyLookup func(kid string) (crypto.PublicKey, []string, error)
+
+// parseJwtClaims 校验令牌签名和exp等标准声明，令牌头中的alg必须是允许的算法，且与公钥的类型匹配
+func parseJwtClaims(tokenString string, lookup jwtKeyLookup) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header[JwtTokenHeaderKeyId].(string)
+		publicKey, algorithms, err := lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		if len(algorithms) == 0 {
+			algorithms = []string{DefaultJwtAlgorithmOf(publicKey)}
+		}
+		if !isAllowedJwtAlgorithm(algorithms, t.Method.Alg()) {
+			return nil, ErrJwtAlgorithm
+		}
+		if err = CheckJwtAlgorithm(t.Method.Alg(), publicKey); err != nil {
+			return nil, err
+		}
+		return publicKey, nil
+	})
+	if errors.Is(err, ErrAuthServiceUnavailable) || errors.Is(err, ErrNoResult) {
+		// 下载公钥失败，不是令牌本身的问题
+		return nil, err
+	}
+	if err != nil || token == nil || !token.Valid {
+		return nil, ErrJwtErrFormat
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrJwtErrFormat
+	}
+	return claims, nil
+}
+
+// parseJwtAccessClaims 与parseJwtClaims相同，但刷新令牌不能作为访问令牌使用
+func parseJwtAccessClaims(tokenString string, lookup jwtKeyLookup) (jwt.MapClaims, error) {
+	claims, err := parseJwtClaims(tokenString, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if claims[JwtTokenClaimsType] == JwtTokenTypeRefresh {
+		return nil, ErrJwtErrVersion
+	}
+	return claims, nil
+}
+
+// parseJwtUser 校验令牌并解析为JwtUser，标准声明以外的声明保存在Extra中
+func parseJwtUser(tokenString string, lookup jwtKeyLookup) (*JwtUser, error) {
+	claims, err := parseJwtAccessClaims(tokenString, lookup)
+	if err != nil {
+		return nil, err
+	}
+	jwtUser := &JwtUser{Token: tokenString}
+	if err = decodeJwtClaims(claims, &jwtUser.RawJwtUser); err != nil {
+		return nil, err
+	}
+	jwtUser.Extra = extraJwtClaims(claims)
+	return jwtUser, nil
+}
@@ -1,15 +1,30 @@
 package auth
 
-import "github.com/go-logr/logr"
+import (
+	"context"
+	"github.com/go-logr/logr"
+)
 
 type LocalAuthChecker struct {
-	Config  *LocalAuthCheckerConfig
-	AesUtil *AesUtil
-	logger  logr.Logger
+	Config      *LocalAuthCheckerConfig
+	AesUtil     *AesUtil
+	logger      logr.Logger
+	jwt         JwtSessionValidator
+	accessCodes AccessCodeProvider
+	randomKeys  RandomKeyProvider
+	// randomKeyStore 未设置randomKeys时，用于创建RandomKeyValidator
+	randomKeyStore RandomKeyStore
+	clients        ClientProvider
+	clientPerms    ClientPermProvider
+	perms          PermProvider
+	custom         CustomProvider
+	public         *publicRoutes
+	// optionErrors 选项遇到的配置错误，NewLocalAuthCheckerE返回这些错误，NewLocalAuthChecker则panic
+	optionErrors configProblems
 }
 
 func (c *LocalAuthChecker) ExtractAccessCode(f GetHeaderFun) (string, error) {
-	return ExtractAccessCode(f, c.Config.LocalAccessCode.Header, c.Config.LocalAccessCode.EncryptContent, aesUtil, c.logger)
+	return ExtractAccessCode(f, c.Config.LocalAccessCode.Header, c.Config.LocalAccessCode.EncryptContent, c.AesUtil, c.logger)
 }
 
 func (c *LocalAuthChecker) ExtractRandomKey(f GetHeaderFun) (string, error) {
@@ -21,5 +36,315 @@ func (c *LocalAuthChecker) ExtractUserToken(f GetHeaderFun) (string, error) {
 }
 
 func (c *LocalAuthChecker) ExtractClientInfoAndToken(f GetHeaderFun) (string, string, string, error) {
-	return ExtractClientInfoAndToken(f, c.Config.LocalClient.Header, c.Config.LocalClient.HeaderSchema, c.Config.LocalClient.EncryptContent, aesUtil, c.logger)
+	return ExtractClientInfoAndToken(f, c.Config.LocalClient.Header, c.Config.LocalClient.HeaderSchema, c.Config.LocalClient.EncryptContent, c.AesUtil, c.logger)
+}
+
+// IsAccessCodeOk 未启用访问码时总是通过
+func (c *LocalAuthChecker) IsAccessCodeOk(ctx context.Context, code string) (bool, error) {
+	if !c.Config.LocalAccessCode.Enable {
+		return true, nil
+	}
+	if len(code) == 0 {
+		return false, ErrAccessCodeEmpty
+	}
+	if c.accessCodes == nil {
+		return false, ErrProviderNotConfigured
+	}
+	return c.accessCodes.IsAccessCodeOk(ctx, code)
+}
+
+// IsRandomKeyOk 未启用随机码时总是通过
+func (c *LocalAuthChecker) IsRandomKeyOk(ctx context.Context, key string) (bool, error) {
+	if !c.Config.LocalRandomKey.Enable {
+		return true, nil
+	}
+	if len(key) == 0 {
+		return false, ErrRandomKeyEmpty
+	}
+	if c.randomKeys == nil {
+		return false, ErrProviderNotConfigured
+	}
+	return c.randomKeys.IsRandomKeyOk(ctx, key)
+}
+
+// CheckAuth 校验令牌签名和有效期，并确认会话未被注销
+func (c *LocalAuthChecker) CheckAuth(ctx context.Context, userToken string, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	user, err := c.authenticate(ctx, userToken)
+	if err != nil {
+		return nil, err
+	}
+	result := &CheckAuthResult{User: user}
+	if fulfillCustomAuth && c.custom != nil {
+		result.CustomAuth, err = c.custom.CustomAuth(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *LocalAuthChecker) CheckPermByCode(ctx context.Context, userToken string, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.checkPerm(ctx, userToken, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm, func(user *JwtUser) (bool, error) {
+		return c.perms.HasPermByCode(ctx, user, code)
+	})
+}
+
+func (c *LocalAuthChecker) CheckPermByAction(ctx context.Context, userToken string, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (CheckPermResult, error) {
+	action := Action{Service: service, Method: method, Path: path}
+	result, err := c.checkPerm(ctx, userToken, action.Key(), fulfillJwt, fulfillCustomAuth, fulfillCustomPerm, func(user *JwtUser) (bool, error) {
+		return c.perms.HasPermByAction(ctx, user, service, method, path)
+	})
+	if err != nil {
+		return CheckPermResult{}, err
+	}
+	return *result, nil
+}
+
+// IsPublicRoute 请求是否匹配LocalPublic配置的路由，f需通过伪请求头提供请求方法、路径和来源地址
+func (c *LocalAuthChecker) IsPublicRoute(f GetHeaderFun) bool {
+	return c.public.match(f, "", "")
+}
+
+// CheckAuthByHeader 匹配公开路由时直接跳过，否则检查访问码和随机码后按请求头中的用户令牌调用CheckAuth
+func (c *LocalAuthChecker) CheckAuthByHeader(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	if c.public.match(f, "", "") {
+		return &CheckAuthResult{SkippedAuthCheck: true}, nil
+	}
+	token, err := c.extractUserToken(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckAuth(ctx, token, fulfillCustomAuth)
+}
+
+func (c *LocalAuthChecker) CheckPermByCodeByHeader(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	if c.public.match(f, "", "") {
+		return &CheckPermResult{SkippedAuthCheck: true}, nil
+	}
+	token, err := c.extractUserToken(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckPermByCode(ctx, token, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+// CheckPermByActionByHeader 以method和path判断是否为公开路由
+func (c *LocalAuthChecker) CheckPermByActionByHeader(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	if c.public.match(f, method, path) {
+		return &CheckPermResult{SkippedAuthCheck: true}, nil
+	}
+	token, err := c.extractUserToken(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.CheckPermByAction(ctx, token, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// extractUserToken 检查访问码和随机码后解析用户令牌
+func (c *LocalAuthChecker) extractUserToken(ctx context.Context, f GetHeaderFun) (string, error) {
+	if err := c.checkAccessCodeAndRandomKey(ctx, f); err != nil {
+		return "", err
+	}
+	return c.ExtractUserToken(f)
+}
+
+func (c *LocalAuthChecker) checkAccessCodeAndRandomKey(ctx context.Context, f GetHeaderFun) error {
+	if c.Config.LocalAccessCode.Enable {
+		code, err := c.ExtractAccessCode(f)
+		if err != nil {
+			return err
+		}
+		ok, err := c.IsAccessCodeOk(ctx, code)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrAccessCodeFail
+		}
+	}
+	if c.Config.LocalRandomKey.Enable {
+		key, err := c.ExtractRandomKey(f)
+		if err != nil {
+			return err
+		}
+		ok, err := c.IsRandomKeyOk(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRandomKeyFail
+		}
+	}
+	return nil
+}
+
+// CheckClientAuth 客户端id或秘钥错误时返回ClientAuthOk为false的结果，与鉴权服务的行为一致
+func (c *LocalAuthChecker) CheckClientAuth(ctx context.Context, clientId string, clientSecret string) (*CheckClientAuthResult, error) {
+	if len(clientId) == 0 || len(clientSecret) == 0 {
+		return nil, ErrClientIdOrSecretEmpty
+	}
+	if c.clients == nil {
+		return nil, ErrProviderNotConfigured
+	}
+	ok, err := c.clients.IsClientOk(ctx, clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckClientAuthResult{ClientAuthOk: ok, ClientId: clientId}, nil
+}
+
+// CheckClientAuthByHeader 从请求头解析客户端id和秘钥后调用CheckClientAuth
+func (c *LocalAuthChecker) CheckClientAuthByHeader(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error) {
+	if err := c.checkAccessCodeAndRandomKey(ctx, f); err != nil {
+		return nil, err
+	}
+	clientId, clientSecret, _, err := c.ExtractClientInfoAndToken(f)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckClientAuth(ctx, clientId, clientSecret)
+}
+
+func (c *LocalAuthChecker) CheckClientPermByCode(ctx context.Context, clientId string, clientSecret string, code string) (*CheckClientPermResult, error) {
+	authResult, err := c.CheckClientAuth(ctx, clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !authResult.ClientAuthOk {
+		return &CheckClientPermResult{ClientPermOk: false}, nil
+	}
+	if c.clientPerms == nil {
+		return nil, ErrProviderNotConfigured
+	}
+	ok, err := c.clientPerms.HasClientPermByCode(ctx, clientId, code)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckClientPermResult{ClientPermOk: ok}, nil
+}
+
+// CheckClientPermByCodeByHeader 从请求头解析客户端id和秘钥后调用CheckClientPermByCode
+func (c *LocalAuthChecker) CheckClientPermByCodeByHeader(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	if err := c.checkAccessCodeAndRandomKey(ctx, f); err != nil {
+		return nil, err
+	}
+	clientId, clientSecret, _, err := c.ExtractClientInfoAndToken(f)
+	if err != nil {
+		return nil, err
+	}
+	return c.CheckClientPermByCode(ctx, clientId, clientSecret, code)
+}
+
+// AuthClient 返回按请求头检查的IAuthClientCtx，可传给中间件，不带Ctx后缀的方法使用context.Background()
+func (c *LocalAuthChecker) AuthClient() IAuthClientCtx {
+	return localAuthClient{checker: c}
+}
+
+func (c *LocalAuthChecker) authenticate(ctx context.Context, userToken string) (*JwtUser, error) {
+	if len(userToken) == 0 {
+		return nil, ErrUserTokenEmpty
+	}
+	if c.jwt == nil {
+		return nil, ErrProviderNotConfigured
+	}
+	user, err := c.jwt.ValidateJwt(userToken)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := c.jwt.IsJwtInCache(ctx, user)
+	if err != nil {
+		c.logger.Error(err, "check jwt session failed", "id", user.Id)
+		return nil, err
+	}
+	if !exists {
+		return nil, newServiceError(CodeAuthFail, MsgAuthFail, 0, "")
+	}
+	return user, nil
+}
+
+func (c *LocalAuthChecker) checkPerm(ctx context.Context, userToken string, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool, hasPerm func(user *JwtUser) (bool, error)) (*CheckPermResult, error) {
+	user, err := c.authenticate(ctx, userToken)
+	if err != nil {
+		return nil, err
+	}
+	if c.perms == nil {
+		return nil, ErrProviderNotConfigured
+	}
+	ok, err := hasPerm(user)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, newServiceError(CodePermFail, MsgPermFail, 0, "")
+	}
+	result := &CheckPermResult{}
+	if fulfillJwt {
+		result.User = user
+	}
+	if c.custom != nil {
+		if fulfillCustomAuth {
+			if result.CustomAuth, err = c.custom.CustomAuth(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		if fulfillCustomPerm {
+			if result.CustomPerm, err = c.custom.CustomPerm(ctx, user, code); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// localAuthClient 将LocalAuthChecker的ByHeader方法适配为IAuthClientCtx
+type localAuthClient struct {
+	checker *LocalAuthChecker
+}
+
+func (c localAuthClient) IsPublicRoute(f GetHeaderFun) bool {
+	return c.checker.IsPublicRoute(f)
+}
+
+func (c localAuthClient) CheckAuth(f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.CheckAuthCtx(context.Background(), f, fulfillCustomAuth)
+}
+
+func (c localAuthClient) CheckPermByCode(f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.CheckPermByCodeCtx(context.Background(), f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c localAuthClient) CheckPermByAction(f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.CheckPermByActionCtx(context.Background(), f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c localAuthClient) CheckClientAuth(f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return c.CheckClientAuthCtx(context.Background(), f)
+}
+
+func (c localAuthClient) CheckClientPermByCode(f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return c.CheckClientPermByCodeCtx(context.Background(), f, code)
+}
+
+func (c localAuthClient) CheckAuthCtx(ctx context.Context, f GetHeaderFun, fulfillCustomAuth bool) (*CheckAuthResult, error) {
+	return c.checker.CheckAuthByHeader(ctx, f, fulfillCustomAuth)
+}
+
+func (c localAuthClient) CheckPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.checker.CheckPermByCodeByHeader(ctx, f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c localAuthClient) CheckPermByActionCtx(ctx context.Context, f GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*CheckPermResult, error) {
+	return c.checker.CheckPermByActionByHeader(ctx, f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c localAuthClient) CheckClientAuthCtx(ctx context.Context, f GetHeaderFun) (*CheckClientAuthResult, error) {
+	return c.checker.CheckClientAuthByHeader(ctx, f)
+}
+
+func (c localAuthClient) CheckClientPermByCodeCtx(ctx context.Context, f GetHeaderFun, code string) (*CheckClientPermResult, error) {
+	return c.checker.CheckClientPermByCodeByHeader(ctx, f, code)
 }
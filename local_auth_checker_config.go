@@ -1,36 +1,60 @@
 package auth
 
+import "time"
+
+type LocalService struct {
+	EncryptKey string `json:"encryptKey" yaml:"encryptKey"` // AES加密key，与NewLocalAuthChecker的aesKey参数相同
+}
+
 type LocalAccessCode struct {
-	Enable         bool
-	Header         string
-	EncryptContent bool
+	Enable         bool   `json:"enable" yaml:"enable"`
+	Header         string `json:"header" yaml:"header"`
+	EncryptContent bool   `json:"encryptContent" yaml:"encryptContent"`
 }
 
 type LocalRandomKey struct {
-	Enable bool
-	Header string
+	Enable        bool          `json:"enable" yaml:"enable"`
+	Header        string        `json:"header" yaml:"header"`
+	Window        time.Duration `json:"window" yaml:"window"`               // 同一随机码在此时间内不能重复使用
+	BindTimestamp bool          `json:"bindTimestamp" yaml:"bindTimestamp"` // 随机码需带有时间戳，且与当前时间相差不超过Window
 }
 
 type LocalUser struct {
-	Header       string
-	HeaderSchema string
+	Header       string `json:"header" yaml:"header"`
+	HeaderSchema string `json:"headerSchema" yaml:"headerSchema"`
 }
 
 type LocalClient struct {
-	EnableIdAndSecret bool
-	Header            string
-	HeaderSchema      string
-	EncryptContent    bool
+	EnableIdAndSecret bool   `json:"enableIdAndSecret" yaml:"enableIdAndSecret"`
+	Header            string `json:"header" yaml:"header"`
+	HeaderSchema      string `json:"headerSchema" yaml:"headerSchema"`
+	EncryptContent    bool   `json:"encryptContent" yaml:"encryptContent"`
+}
+
+// LocalPublic 无需鉴权的路由，匹配时不解析任何令牌，直接返回SkippedAuthCheck为true的结果
+type LocalPublic struct {
+	Routes []PublicRoute `json:"routes" yaml:"routes"`
 }
 
 type LocalAuditing struct {
-	MetaBy string
+	MetaBy string `json:"metaBy" yaml:"metaBy"`
 }
 
 type LocalAuthCheckerConfig struct {
-	LocalAccessCode
-	LocalRandomKey
-	LocalUser
-	LocalClient
-	LocalAuditing
+	LocalService    `json:"service" yaml:"service"`
+	LocalAccessCode `json:"accessCode" yaml:"accessCode"`
+	LocalRandomKey  `json:"randomKey" yaml:"randomKey"`
+	LocalUser       `json:"user" yaml:"user"`
+	LocalClient     `json:"client" yaml:"client"`
+	LocalPublic     `json:"public" yaml:"public"`
+	LocalAuditing   `json:"auditing" yaml:"auditing"`
+}
+
+// Validate 检查配置并一次返回全部问题，返回的错误为ConfigErrors
+func (c *LocalAuthCheckerConfig) Validate() error {
+	problems := configProblems{}
+	validateEncryptKey(&problems, "service.encryptKey", c.EncryptKey, c.LocalAccessCode.EncryptContent || c.LocalClient.EncryptContent)
+	validateNonNegative(&problems, "randomKey.window", int64(c.LocalRandomKey.Window))
+	validatePublicRoutes(&problems, "public.routes", c.LocalPublic.Routes)
+	return problems.err()
 }
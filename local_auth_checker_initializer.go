@@ -16,6 +16,11 @@ func WithLocalRandomKeyConfig(config LocalRandomKey) LocalCheckerOption {
 	return func(checker *LocalAuthChecker) {
 		checker.Config.LocalRandomKey.Enable = config.Enable
 		checker.Config.LocalRandomKey.Header = GetNonEmptyValueWithBackup(config.Header, DefaultHeaderRandomKey)
+		checker.Config.LocalRandomKey.Window = config.Window
+		if config.Window <= 0 {
+			checker.Config.LocalRandomKey.Window = DefaultRandomKeyWindow
+		}
+		checker.Config.LocalRandomKey.BindTimestamp = config.BindTimestamp
 	}
 }
 
@@ -35,51 +40,165 @@ func WithLocalClientConfig(config LocalClient) LocalCheckerOption {
 	}
 }
 
+// WithLocalPublicConfig 路由配置错误时记录为public.routes的配置错误
+func WithLocalPublicConfig(config LocalPublic) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		public, err := newPublicRoutes(config.Routes)
+		if err != nil {
+			checker.optionErrors.add("public.routes", "%s", err.Error())
+			return
+		}
+		checker.Config.LocalPublic.Routes = config.Routes
+		checker.public = public
+	}
+}
+
 func WithLocalAuditingConfig(config LocalAuditing) LocalCheckerOption {
 	return func(checker *LocalAuthChecker) {
 		checker.Config.LocalAuditing.MetaBy = GetNonEmptyValueWithBackup(config.MetaBy, DefaultMetaBy)
 	}
 }
 
+// WithJwtSessionValidator 通常传入RedisJwtUtil
+func WithJwtSessionValidator(validator JwtSessionValidator) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.jwt = validator
+	}
+}
+
+func WithAccessCodeProvider(provider AccessCodeProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.accessCodes = provider
+	}
+}
+
+func WithRandomKeyProvider(provider RandomKeyProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.randomKeys = provider
+	}
+}
+
+// WithRandomKeyStore 使用LocalRandomKey配置和store校验随机码，store通常为RedisJwtUtil，已设置RandomKeyProvider时忽略
+func WithRandomKeyStore(store RandomKeyStore) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.randomKeyStore = store
+	}
+}
+
+func WithClientProvider(provider ClientProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.clients = provider
+	}
+}
+
+func WithClientPermProvider(provider ClientPermProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.clientPerms = provider
+	}
+}
+
+func WithPermProvider(provider PermProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.perms = provider
+	}
+}
+
+func WithCustomProvider(provider CustomProvider) LocalCheckerOption {
+	return func(checker *LocalAuthChecker) {
+		checker.custom = provider
+	}
+}
+
 func WithAuthCheckerLogger(logger logr.Logger) LocalCheckerOption {
 	return func(checker *LocalAuthChecker) {
 		checker.logger = logger
 	}
 }
 
-func NewLocalAuthChecker(aesKey string, options ...LocalCheckerOption) *LocalAuthChecker {
-	checker := &LocalAuthChecker{
-		Config: &LocalAuthCheckerConfig{
-			LocalAccessCode: LocalAccessCode{
-				Enable:         false,
-				Header:         DefaultHeaderAccessCode,
-				EncryptContent: false,
-			},
-			LocalRandomKey: LocalRandomKey{
-				Enable: false,
-				Header: DefaultHeaderRandomKey,
-			},
-			LocalUser: LocalUser{
-				Header:       DefaultHeaderUserToken,
-				HeaderSchema: DefaultHeaderSchema,
-			},
-			LocalClient: LocalClient{
-				EnableIdAndSecret: true,
-				Header:            DefaultHeaderClientToken,
-				HeaderSchema:      DefaultHeaderSchema,
-				EncryptContent:    false,
-			},
-			LocalAuditing: LocalAuditing{
-				MetaBy: DefaultMetaBy,
-			},
+// defaultLocalAuthCheckerConfig 未通过选项设置时使用的配置
+func defaultLocalAuthCheckerConfig(aesKey string) *LocalAuthCheckerConfig {
+	return &LocalAuthCheckerConfig{
+		LocalService: LocalService{
+			EncryptKey: aesKey,
+		},
+		LocalAccessCode: LocalAccessCode{
+			Enable:         false,
+			Header:         DefaultHeaderAccessCode,
+			EncryptContent: false,
+		},
+		LocalRandomKey: LocalRandomKey{
+			Enable: false,
+			Header: DefaultHeaderRandomKey,
+			Window: DefaultRandomKeyWindow,
 		},
-		AesUtil: NewAesUtil(aesKey),
+		LocalUser: LocalUser{
+			Header:       DefaultHeaderUserToken,
+			HeaderSchema: DefaultHeaderSchema,
+		},
+		LocalClient: LocalClient{
+			EnableIdAndSecret: true,
+			Header:            DefaultHeaderClientToken,
+			HeaderSchema:      DefaultHeaderSchema,
+			EncryptContent:    false,
+		},
+		LocalAuditing: LocalAuditing{
+			MetaBy: DefaultMetaBy,
+		},
+	}
+}
+
+// NewLocalAuthChecker 配置错误时panic，错误为ConfigErrors
+func NewLocalAuthChecker(aesKey string, options ...LocalCheckerOption) *LocalAuthChecker {
+	checker, err := NewLocalAuthCheckerE(aesKey, options...)
+	if err != nil {
+		panic(err)
 	}
+	return checker
+}
+
+// NewLocalAuthCheckerE 与NewLocalAuthChecker相同，但配置错误时返回ConfigErrors而不是panic
+func NewLocalAuthCheckerE(aesKey string, options ...LocalCheckerOption) (*LocalAuthChecker, error) {
+	checker := &LocalAuthChecker{Config: defaultLocalAuthCheckerConfig(aesKey)}
 	for _, opt := range options {
 		opt(checker)
 	}
-	if checker.logger.GetSink() == nil {
-		checker.logger = logr.Discard()
+	if err := checker.optionErrors.err(); err != nil {
+		return nil, err
+	}
+	if err := checker.Config.Validate(); err != nil {
+		return nil, err
+	}
+	checker.init()
+	return checker, nil
+}
+
+// NewLocalAuthCheckerWithConfig 使用LoadLocalAuthCheckerConfig等方式得到的配置创建LocalAuthChecker，先校验配置，options在配置之后应用
+func NewLocalAuthCheckerWithConfig(config *LocalAuthCheckerConfig, options ...LocalCheckerOption) (*LocalAuthChecker, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewLocalAuthCheckerE(config.EncryptKey, append(config.options(), options...)...)
+}
+
+// init 应用选项后创建派生状态
+func (c *LocalAuthChecker) init() {
+	c.AesUtil = NewAesUtil(c.Config.EncryptKey)
+	if c.logger.GetSink() == nil {
+		c.logger = logr.Discard()
+	}
+	if c.randomKeys == nil && c.randomKeyStore != nil {
+		c.randomKeys = NewRandomKeyValidator(c.randomKeyStore, c.Config.LocalRandomKey.Window, c.Config.LocalRandomKey.BindTimestamp)
+	}
+}
+
+// options 将配置的各部分转换为对应的选项，空值按各选项的规则使用默认值
+func (c *LocalAuthCheckerConfig) options() []LocalCheckerOption {
+	return []LocalCheckerOption{
+		WithLocalAccessCodeConfig(c.LocalAccessCode),
+		WithLocalRandomKeyConfig(c.LocalRandomKey),
+		WithLocalUserConfig(c.LocalUser),
+		WithLocalClientConfig(c.LocalClient),
+		WithLocalPublicConfig(c.LocalPublic),
+		WithLocalAuditingConfig(c.LocalAuditing),
 	}
-	return checker
 }
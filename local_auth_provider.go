@@ -0,0 +1,67 @@
+package auth
+
+import "context"
+
+// JwtSessionValidator 校验令牌并确认会话仍然有效，RedisJwtUtil实现了该接口
+type JwtSessionValidator interface {
+	ValidateJwt(tokenString string) (*JwtUser, error)
+	IsJwtInCache(ctx context.Context, jwtUser *JwtUser) (bool, error)
+}
+
+var _ JwtSessionValidator = (*RedisJwtUtil)(nil)
+
+// AccessCodeProvider 校验访问码
+type AccessCodeProvider interface {
+	IsAccessCodeOk(ctx context.Context, code string) (bool, error)
+}
+
+// RandomKeyProvider 校验随机码
+type RandomKeyProvider interface {
+	IsRandomKeyOk(ctx context.Context, key string) (bool, error)
+}
+
+// ClientProvider 校验客户端id和秘钥
+type ClientProvider interface {
+	IsClientOk(ctx context.Context, clientId string, clientSecret string) (bool, error)
+}
+
+// ClientPermProvider 检查客户端是否拥有权限码
+type ClientPermProvider interface {
+	HasClientPermByCode(ctx context.Context, clientId string, code string) (bool, error)
+}
+
+// PermProvider 检查用户是否拥有权限码或操作
+type PermProvider interface {
+	HasPermByCode(ctx context.Context, user *JwtUser, code string) (bool, error)
+	HasPermByAction(ctx context.Context, user *JwtUser, service string, method string, path string) (bool, error)
+}
+
+// CustomProvider 补充用户的自定义身份和权限信息，操作的权限码为Action.Key()
+type CustomProvider interface {
+	CustomAuth(ctx context.Context, user *JwtUser) (interface{}, error)
+	CustomPerm(ctx context.Context, user *JwtUser, code string) (interface{}, error)
+}
+
+type AccessCodeProviderFunc func(ctx context.Context, code string) (bool, error)
+
+func (f AccessCodeProviderFunc) IsAccessCodeOk(ctx context.Context, code string) (bool, error) {
+	return f(ctx, code)
+}
+
+type RandomKeyProviderFunc func(ctx context.Context, key string) (bool, error)
+
+func (f RandomKeyProviderFunc) IsRandomKeyOk(ctx context.Context, key string) (bool, error) {
+	return f(ctx, key)
+}
+
+type ClientProviderFunc func(ctx context.Context, clientId string, clientSecret string) (bool, error)
+
+func (f ClientProviderFunc) IsClientOk(ctx context.Context, clientId string, clientSecret string) (bool, error) {
+	return f(ctx, clientId, clientSecret)
+}
+
+type ClientPermProviderFunc func(ctx context.Context, clientId string, code string) (bool, error)
+
+func (f ClientPermProviderFunc) HasClientPermByCode(ctx context.Context, clientId string, code string) (bool, error) {
+	return f(ctx, clientId, code)
+}
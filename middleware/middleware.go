@@ -0,0 +1,344 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/go-logr/logr"
+	"net/http"
+)
+
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+var errAccessCodeForwarded = fmt.Errorf("%w: 使用WithAccessCodeValidator在本地校验访问码时，HttpClient不能同时启用访问码", auth.ErrInvalidConfig)
+
+// Middleware 基于IAuthClient（HttpClient、HybridAuthChecker或LocalAuthChecker.AuthClient）的net/http中间件，鉴权结果保存在请求的context.Context中。
+// IAuthClient同时实现IAuthClientCtx时将请求的context.Context传给鉴权调用
+type Middleware struct {
+	client            auth.IAuthClientCtx
+	serviceName       string
+	fulfillJwt        bool
+	fulfillCustomAuth bool
+	fulfillCustomPerm bool
+	errorHandler      ErrorHandler
+	logger            logr.Logger
+	accessCode        auth.AccessCode
+	accessCodes       auth.AccessCodeProvider
+	aesUtil           *auth.AesUtil
+	// httpClient client为HttpClient或ReloadingHttpClient时返回当前的HttpClient，用于读取服务名和加密秘钥
+	httpClient func() *auth.HttpClient
+}
+
+// httpClientSource ReloadingHttpClient实现该接口，每次读取配置时使用最新的HttpClient
+type httpClientSource interface {
+	Current() *auth.HttpClient
+}
+
+func (m *Middleware) service() string {
+	if len(m.serviceName) > 0 || m.httpClient == nil {
+		return m.serviceName
+	}
+	return m.httpClient().Config.CurrentServiceName
+}
+
+func (m *Middleware) accessCodeAesUtil() *auth.AesUtil {
+	if m.aesUtil != nil || m.httpClient == nil {
+		return m.aesUtil
+	}
+	return m.httpClient().AesUtil
+}
+
+func (m *Middleware) RequireAuth() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skipped(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m.isPublicRoute(r) {
+				next.ServeHTTP(w, SkipAuthCheck(r))
+				return
+			}
+			if err := m.checkAccessCode(r); err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			result, err := m.client.CheckAuthCtx(r.Context(), headerFun(r), m.fulfillCustomAuth)
+			if err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			ctx := r.Context()
+			set := ContextSetValFunc(&ctx)
+			auth.SetSkipAuthCheck(result.SkippedAuthCheck, set)
+			auth.SetJwtUser(result.User, set)
+			auth.SetCustomAuth(result.CustomAuth, set)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (m *Middleware) RequirePerm(code string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skipped(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m.isPublicRoute(r) {
+				next.ServeHTTP(w, SkipAuthCheck(r))
+				return
+			}
+			if err := m.checkAccessCode(r); err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			result, err := m.client.CheckPermByCodeCtx(r.Context(), headerFun(r), code, m.fulfillJwt, m.fulfillCustomAuth, m.fulfillCustomPerm)
+			m.servePerm(w, r, next, result, err)
+		})
+	}
+}
+
+// RequireAction 使用当前服务名、请求方法和请求路径检查权限
+func (m *Middleware) RequireAction() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skipped(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m.isPublicRoute(r) {
+				next.ServeHTTP(w, SkipAuthCheck(r))
+				return
+			}
+			if err := m.checkAccessCode(r); err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			result, err := m.client.CheckPermByActionCtx(r.Context(), headerFun(r), m.service(), r.Method, r.URL.Path, m.fulfillJwt, m.fulfillCustomAuth, m.fulfillCustomPerm)
+			m.servePerm(w, r, next, result, err)
+		})
+	}
+}
+
+func (m *Middleware) RequireClient() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skipped(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if m.isPublicRoute(r) {
+				next.ServeHTTP(w, SkipAuthCheck(r))
+				return
+			}
+			if err := m.checkAccessCode(r); err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			result, err := m.client.CheckClientAuthCtx(r.Context(), headerFun(r))
+			if err == nil && !result.ClientAuthOk {
+				err = auth.ErrClientTokenFail
+			}
+			if err != nil {
+				m.fail(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			ctx := r.Context()
+			auth.SetClientId(result.ClientId, ContextSetValFunc(&ctx))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ctxClient 将只实现IAuthClient的客户端适配为IAuthClientCtx，忽略ctx
+type ctxClient struct {
+	auth.IAuthClient
+}
+
+func (c ctxClient) CheckAuthCtx(_ context.Context, f auth.GetHeaderFun, fulfillCustomAuth bool) (*auth.CheckAuthResult, error) {
+	return c.CheckAuth(f, fulfillCustomAuth)
+}
+
+func (c ctxClient) CheckPermByCodeCtx(_ context.Context, f auth.GetHeaderFun, code string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*auth.CheckPermResult, error) {
+	return c.CheckPermByCode(f, code, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ctxClient) CheckPermByActionCtx(_ context.Context, f auth.GetHeaderFun, service string, method string, path string, fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) (*auth.CheckPermResult, error) {
+	return c.CheckPermByAction(f, service, method, path, fulfillJwt, fulfillCustomAuth, fulfillCustomPerm)
+}
+
+func (c ctxClient) CheckClientAuthCtx(_ context.Context, f auth.GetHeaderFun) (*auth.CheckClientAuthResult, error) {
+	return c.CheckClientAuth(f)
+}
+
+func (c ctxClient) CheckClientPermByCodeCtx(_ context.Context, f auth.GetHeaderFun, code string) (*auth.CheckClientPermResult, error) {
+	return c.CheckClientPermByCode(f, code)
+}
+
+func (c ctxClient) IsPublicRoute(f auth.GetHeaderFun) bool {
+	checker, ok := c.IAuthClient.(publicRouteChecker)
+	return ok && checker.IsPublicRoute(f)
+}
+
+// publicRouteChecker HttpClient和LocalAuthChecker.AuthClient提供，用于在检查访问码之前放行公开路由
+type publicRouteChecker interface {
+	IsPublicRoute(f auth.GetHeaderFun) bool
+}
+
+func (m *Middleware) isPublicRoute(r *http.Request) bool {
+	checker, ok := m.client.(publicRouteChecker)
+	return ok && checker.IsPublicRoute(headerFun(r))
+}
+
+// checkAccessCode 启用访问码且配置了AccessCodeProvider时，先在本地校验访问码。
+// 重新加载后HttpClient启用了访问码时拒绝请求，以免一次性访问码被本地和鉴权服务重复使用
+func (m *Middleware) checkAccessCode(r *http.Request) error {
+	if m.accessCodes == nil {
+		return nil
+	}
+	if m.httpClient != nil && m.httpClient().Config.AccessCode.Enable {
+		return errAccessCodeForwarded
+	}
+	if !m.accessCode.Enable {
+		return nil
+	}
+	code, err := auth.ExtractAccessCode(r.Header.Get, m.accessCode.Header, m.accessCode.EncryptContent, m.accessCodeAesUtil(), m.logger)
+	if err != nil {
+		return err
+	}
+	ok, err := m.accessCodes.IsAccessCodeOk(r.Context(), code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return auth.ErrAccessCodeFail
+	}
+	return nil
+}
+
+func (m *Middleware) servePerm(w http.ResponseWriter, r *http.Request, next http.Handler, result *auth.CheckPermResult, err error) {
+	if err != nil {
+		m.fail(w, r, http.StatusForbidden, err)
+		return
+	}
+	ctx := r.Context()
+	set := ContextSetValFunc(&ctx)
+	auth.SetSkipAuthCheck(result.SkippedAuthCheck, set)
+	auth.SetJwtUser(result.User, set)
+	auth.SetCustomAuth(result.CustomAuth, set)
+	auth.SetCustomPerm(result.CustomPerm, set)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (m *Middleware) fail(w http.ResponseWriter, r *http.Request, deniedStatus int, err error) {
+	status := StatusCode(err, deniedStatus)
+	if status >= http.StatusInternalServerError {
+		m.logger.Error(err, "auth check failed", "method", r.Method, "path", r.URL.Path)
+	}
+	m.errorHandler(w, r, status, err)
+}
+
+// StatusCode 将鉴权错误转换为HTTP状态码，deniedStatus用于鉴权服务明确拒绝但未说明原因的情况
+func StatusCode(err error, deniedStatus int) int {
+	switch {
+	case errors.Is(err, auth.ErrAuthServiceUnavailable), errors.Is(err, auth.ErrInternalError):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, auth.ErrRateLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, auth.ErrPermFail):
+		return http.StatusForbidden
+	case errors.Is(err, auth.ErrAccessCodeEmpty),
+		errors.Is(err, auth.ErrAccessCodeFail),
+		errors.Is(err, auth.ErrRandomKeyEmpty),
+		errors.Is(err, auth.ErrRandomKeyFail),
+		errors.Is(err, auth.ErrUserTokenEmpty),
+		errors.Is(err, auth.ErrClientTokenEmpty),
+		errors.Is(err, auth.ErrClientIdOrSecretEmpty),
+		errors.Is(err, auth.ErrClientTokenFail),
+		errors.Is(err, auth.ErrJwtErrFormat),
+		errors.Is(err, auth.ErrJwtErrVersion),
+		errors.Is(err, auth.ErrRefreshTokenFail),
+		errors.Is(err, auth.ErrRefreshTokenReused),
+		errors.Is(err, auth.ErrAuthFail),
+		errors.Is(err, auth.ErrDecryptFail):
+		return http.StatusUnauthorized
+	case auth.IsDeniedError(err):
+		return deniedStatus
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// WriteError 以HttpResult格式输出错误
+func WriteError(w http.ResponseWriter, _ *http.Request, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(auth.HttpResult{
+		Code:    status,
+		Message: err.Error(),
+		Success: false,
+	})
+}
+
+// headerFun 除请求头外，还通过伪请求头提供请求方法、路径和来源地址
+func headerFun(r *http.Request) auth.GetHeaderFun {
+	return func(key string) string {
+		switch key {
+		case auth.PseudoHeaderMethod:
+			return r.Method
+		case auth.PseudoHeaderPath:
+			return r.URL.Path
+		case auth.PseudoHeaderRemoteAddr:
+			return r.RemoteAddr
+		default:
+			return r.Header.Get(key)
+		}
+	}
+}
+
+func skipped(r *http.Request) bool {
+	return auth.GetSkipAuthCheck(ContextGetValFunc(r.Context()))
+}
+
+type contextKey string
+
+// ContextSetValFunc 返回将值写入ctx的SetValFunc，可配合auth.SetJwtUser等函数使用
+func ContextSetValFunc(ctx *context.Context) auth.SetValFunc {
+	return func(key string, val interface{}) {
+		*ctx = context.WithValue(*ctx, contextKey(key), val)
+	}
+}
+
+// ContextGetValFunc 返回从ctx读取值的GetValFunc，可配合auth.GetJwtUser等函数使用
+func ContextGetValFunc(ctx context.Context) auth.GetValFunc {
+	return func(key string) interface{} {
+		return ctx.Value(contextKey(key))
+	}
+}
+
+// SkipAuthCheck 标记请求跳过鉴权，需在鉴权中间件之前调用
+func SkipAuthCheck(r *http.Request) *http.Request {
+	ctx := r.Context()
+	auth.SetSkipAuthCheck(true, ContextSetValFunc(&ctx))
+	return r.WithContext(ctx)
+}
+
+func JwtUserFromContext(ctx context.Context) *auth.JwtUser {
+	return auth.GetJwtUser(ContextGetValFunc(ctx))
+}
+
+func CustomAuthFromContext(ctx context.Context) interface{} {
+	return auth.GetCustomAuth(ContextGetValFunc(ctx))
+}
+
+func CustomPermFromContext(ctx context.Context) interface{} {
+	return auth.GetCustomPerm(ContextGetValFunc(ctx))
+}
+
+func ClientIdFromContext(ctx context.Context) string {
+	clientId, _ := auth.GetClientId(ContextGetValFunc(ctx)).(string)
+	return clientId
+}
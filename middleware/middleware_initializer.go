@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/go-logr/logr"
+)
+
+type Option func(*Middleware)
+
+// WithServiceName 设置RequireAction使用的服务名，默认使用HttpClient或ReloadingHttpClient当前配置的CurrentServiceName
+func WithServiceName(serviceName string) Option {
+	return func(m *Middleware) {
+		m.serviceName = serviceName
+	}
+}
+
+func WithFulfill(fulfillJwt bool, fulfillCustomAuth bool, fulfillCustomPerm bool) Option {
+	return func(m *Middleware) {
+		m.fulfillJwt = fulfillJwt
+		m.fulfillCustomAuth = fulfillCustomAuth
+		m.fulfillCustomPerm = fulfillCustomPerm
+	}
+}
+
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.errorHandler = handler
+	}
+}
+
+// WithAccessCodeValidator 在调用鉴权服务前使用provider校验访问码，仅在访问码启用时生效。
+// 本地校验与鉴权服务校验互斥：一次性访问码在本地已被使用，不能再由HttpClient转发给鉴权服务，
+// 因此HttpClient需关闭访问码，并通过WithAccessCodeConfig设置本地校验的请求头和加密方式
+func WithAccessCodeValidator(provider auth.AccessCodeProvider) Option {
+	return func(m *Middleware) {
+		m.accessCodes = provider
+	}
+}
+
+// WithAccessCodeConfig 设置本地校验访问码的请求头和加密方式，aesUtil为nil时使用HttpClient或ReloadingHttpClient当前的AesUtil
+func WithAccessCodeConfig(config auth.AccessCode, aesUtil *auth.AesUtil) Option {
+	return func(m *Middleware) {
+		m.accessCode = config
+		m.accessCode.Header = auth.GetNonEmptyValueWithBackup(config.Header, auth.DefaultHeaderAccessCode)
+		m.aesUtil = aesUtil
+	}
+}
+
+func WithLogger(logger logr.Logger) Option {
+	return func(m *Middleware) {
+		m.logger = logger
+	}
+}
+
+// New client未实现auth.IAuthClientCtx时，调用鉴权时不传递请求的context.Context
+func New(client auth.IAuthClient, options ...Option) *Middleware {
+	m := &Middleware{
+		fulfillJwt:   true,
+		errorHandler: WriteError,
+	}
+	if withCtx, ok := client.(auth.IAuthClientCtx); ok {
+		m.client = withCtx
+	} else {
+		m.client = ctxClient{IAuthClient: client}
+	}
+	switch c := client.(type) {
+	case *auth.HttpClient:
+		m.httpClient = func() *auth.HttpClient { return c }
+	case httpClientSource:
+		m.httpClient = c.Current
+	}
+	for _, opt := range options {
+		opt(m)
+	}
+	if m.accessCodes != nil && m.httpClient != nil && m.httpClient().Config.AccessCode.Enable {
+		panic(errAccessCodeForwarded)
+	}
+	if m.logger.GetSink() == nil {
+		m.logger = logr.Discard()
+	}
+	return m
+}
+
+// NewLocal 使用LocalAuthChecker在本地完成鉴权，访问码和随机码由LocalAuthChecker按其配置检查，
+// RequireAction使用的服务名需通过WithServiceName设置
+func NewLocal(checker *auth.LocalAuthChecker, options ...Option) *Middleware {
+	return New(checker.AuthClient(), options...)
+}
@@ -0,0 +1,346 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	auth "github.com/Macrow/auth-go-sdk"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type fakeClient struct {
+	auth.IAuthClientCtx
+	err error
+}
+
+func (c *fakeClient) CheckAuthCtx(_ context.Context, f auth.GetHeaderFun, _ bool) (*auth.CheckAuthResult, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if f("X-Skipped") != "" {
+		return &auth.CheckAuthResult{SkippedAuthCheck: true}, nil
+	}
+	if f(auth.DefaultHeaderUserToken) == "" {
+		return nil, auth.ErrUserTokenEmpty
+	}
+	return &auth.CheckAuthResult{User: &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "1"}}}, nil
+}
+
+func (c *fakeClient) CheckPermByActionCtx(_ context.Context, _ auth.GetHeaderFun, service string, method string, path string, _ bool, _ bool, _ bool) (*auth.CheckPermResult, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if service != "orders" || method != http.MethodGet || path != "/orders/1" {
+		return nil, auth.ErrPermFail
+	}
+	return &auth.CheckPermResult{User: &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "1"}}, CustomPerm: "perm"}, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	client := &fakeClient{}
+	m := New(client, WithServiceName("orders"))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := JwtUserFromContext(r.Context()); user != nil {
+			_, _ = w.Write([]byte(user.Id))
+		}
+	})
+
+	cases := []struct {
+		name   string
+		h      http.Handler
+		method string
+		token  string
+		skip   bool
+		err    error
+		status int
+		body   string
+	}{
+		{"auth ok", m.RequireAuth()(handler), http.MethodGet, "Bearer t", false, nil, http.StatusOK, "1"},
+		{"no token", m.RequireAuth()(handler), http.MethodGet, "", false, nil, http.StatusUnauthorized, ""},
+		{"skip", m.RequireAuth()(handler), http.MethodGet, "", true, nil, http.StatusOK, ""},
+		{"action ok", m.RequireAction()(handler), http.MethodGet, "Bearer t", false, nil, http.StatusOK, "1"},
+		{"action denied", m.RequireAction()(handler), http.MethodDelete, "Bearer t", false, nil, http.StatusForbidden, ""},
+		{"rate limit", m.RequireAuth()(handler), http.MethodGet, "Bearer t", false, auth.ErrRateLimit, http.StatusTooManyRequests, ""},
+		{"unavailable", m.RequireAuth()(handler), http.MethodGet, "Bearer t", false, auth.ErrAuthServiceUnavailable, http.StatusServiceUnavailable, ""},
+	}
+	for _, c := range cases {
+		client.err = c.err
+		r := httptest.NewRequest(c.method, "/orders/1", nil)
+		if c.token != "" {
+			r.Header.Set(auth.DefaultHeaderUserToken, c.token)
+		}
+		if c.skip {
+			r = SkipAuthCheck(r)
+		}
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Fatal(c.name, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			var result auth.HttpResult
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil || result.Success || result.Code != c.status {
+				t.Fatal(c.name, w.Body.String())
+			}
+		} else if w.Body.String() != c.body {
+			t.Fatal(c.name, w.Body.String())
+		}
+	}
+}
+
+func TestMiddlewareAuthSkipped(t *testing.T) {
+	h := New(&fakeClient{}).RequireAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth.GetSkipAuthCheck(ContextGetValFunc(r.Context())) {
+			_, _ = w.Write([]byte("skipped"))
+		}
+	}))
+	for _, c := range []struct {
+		header, value string
+		body          string
+	}{
+		{"X-Skipped", "1", "skipped"},
+		{auth.DefaultHeaderUserToken, "Bearer t", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(c.header, c.value)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Body.String() != c.body {
+			t.Fatal(c.header, w.Code, w.Body.String())
+		}
+	}
+}
+
+// legacyClient 只实现IAuthClient，不支持context.Context
+type legacyClient struct {
+	auth.IAuthClient
+}
+
+func (legacyClient) CheckAuth(f auth.GetHeaderFun, _ bool) (*auth.CheckAuthResult, error) {
+	if f(auth.DefaultHeaderUserToken) == "" {
+		return nil, auth.ErrUserTokenEmpty
+	}
+	return &auth.CheckAuthResult{User: &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "1"}}}, nil
+}
+
+func TestMiddlewareLegacyClient(t *testing.T) {
+	h := New(legacyClient{}).RequireAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(JwtUserFromContext(r.Context()).Id))
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(auth.DefaultHeaderUserToken, "Bearer t")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "1" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestMiddlewareAccessCode(t *testing.T) {
+	ctx := context.Background()
+	store := auth.NewMemoryAccessCodeStore()
+	record, err := store.IssueAccessCode(ctx, auth.AccessCodeSpec{ClientId: "web", MaxUses: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New(&fakeClient{},
+		WithAccessCodeConfig(auth.AccessCode{Enable: true}, nil),
+		WithAccessCodeValidator(auth.NewAccessCodeValidator(store)),
+	)
+	h := m.RequireAuth()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	for _, c := range []struct {
+		code   string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{record.Code, http.StatusOK},
+		{record.Code, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(auth.DefaultHeaderUserToken, "Bearer t")
+		r.Header.Set(auth.DefaultHeaderAccessCode, c.code)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Fatal(c.code, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestMiddlewareAccessCodeExclusive(t *testing.T) {
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, auth.ErrInvalidConfig) {
+			t.Fatal(err)
+		}
+	}()
+	// HttpClient会把访问码转发给鉴权服务，与本地校验同时使用时一次性访问码会被重复使用
+	client := auth.NewHttpClient("http://127.0.0.1", "orders", "", auth.WithAccessCodeConfig(auth.AccessCode{Enable: true}))
+	New(client, WithAccessCodeValidator(auth.NewAccessCodeValidator(auth.NewMemoryAccessCodeStore())))
+	t.Fatal("local and remote access code validation should be exclusive")
+}
+
+func TestMiddlewareReloadingClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("service") != "billing" {
+			_, _ = w.Write([]byte(`{"code":403,"message":"权限验证失败","success":false}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"success":true,"result":{"user":{"id":"1"}}}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "auth.yaml")
+	write := func(service string) {
+		content := "service: {authServiceBaseUrl: \"" + server.URL + "\", currentServiceName: " + service + "}\n"
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("orders")
+	ctx := context.Background()
+	reloader, err := auth.NewReloader(ctx, &auth.FileConfigSource{Path: path}, auth.HttpClientBuilder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 服务名来自ReloadingHttpClient当前的配置，重新加载后立即生效
+	h := New(auth.ReloadingHttpClient{Reloader: reloader}).RequireAction()(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/invoices", nil)
+		r.Header.Set(auth.DefaultHeaderUserToken, "Bearer t")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if status := serve(); status != http.StatusForbidden {
+		t.Fatal(status)
+	}
+	write("billing")
+	if err = reloader.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := serve(); status != http.StatusOK {
+		t.Fatal(status)
+	}
+}
+
+func TestMiddlewarePublicRoute(t *testing.T) {
+	client := auth.NewHttpClient("http://127.0.0.1:1", "orders", "",
+		auth.WithPublicConfig(auth.Public{Routes: []auth.PublicRoute{{Methods: []string{http.MethodGet}, Path: "/health", CIDRs: []string{"192.0.2.0/24"}}}}),
+	)
+	m := New(client,
+		WithAccessCodeConfig(auth.AccessCode{Enable: true}, nil),
+		WithAccessCodeValidator(auth.NewAccessCodeValidator(auth.NewMemoryAccessCodeStore())),
+	)
+	h := m.RequireAuth()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auth.GetSkipAuthCheck(ContextGetValFunc(r.Context())) {
+			t.Error("skip auth check not set")
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/health", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+type fakeSession struct{}
+
+func (fakeSession) ValidateJwt(tokenString string) (*auth.JwtUser, error) {
+	if tokenString != "t" {
+		return nil, auth.ErrJwtErrFormat
+	}
+	return &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "1"}, Token: tokenString}, nil
+}
+
+func (fakeSession) IsJwtInCache(context.Context, *auth.JwtUser) (bool, error) {
+	return true, nil
+}
+
+type fakePerms struct{}
+
+func (fakePerms) HasPermByCode(_ context.Context, _ *auth.JwtUser, code string) (bool, error) {
+	return code == "orders:read", nil
+}
+
+func (fakePerms) HasPermByAction(_ context.Context, _ *auth.JwtUser, service string, method string, path string) (bool, error) {
+	return service == "orders" && method == http.MethodGet && path == "/orders/1", nil
+}
+
+func TestMiddlewareLocal(t *testing.T) {
+	checker := auth.NewLocalAuthChecker("1234567890123456",
+		auth.WithJwtSessionValidator(fakeSession{}),
+		auth.WithPermProvider(fakePerms{}),
+		auth.WithLocalPublicConfig(auth.LocalPublic{Routes: []auth.PublicRoute{{Methods: []string{http.MethodGet}, Path: "/health"}}}),
+	)
+	m := NewLocal(checker, WithServiceName("orders"))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := JwtUserFromContext(r.Context()); user != nil {
+			_, _ = w.Write([]byte(user.Id))
+		}
+	})
+	for _, c := range []struct {
+		name   string
+		h      http.Handler
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"auth ok", m.RequireAuth()(handler), http.MethodGet, "/orders/1", "Bearer t", http.StatusOK},
+		{"no token", m.RequireAuth()(handler), http.MethodGet, "/orders/1", "", http.StatusUnauthorized},
+		{"bad token", m.RequireAuth()(handler), http.MethodGet, "/orders/1", "Bearer x", http.StatusUnauthorized},
+		{"public", m.RequireAuth()(handler), http.MethodGet, "/health", "", http.StatusOK},
+		{"perm ok", m.RequirePerm("orders:read")(handler), http.MethodGet, "/orders/1", "Bearer t", http.StatusOK},
+		{"perm denied", m.RequirePerm("orders:write")(handler), http.MethodGet, "/orders/1", "Bearer t", http.StatusForbidden},
+		{"action ok", m.RequireAction()(handler), http.MethodGet, "/orders/1", "Bearer t", http.StatusOK},
+		{"action denied", m.RequireAction()(handler), http.MethodDelete, "/orders/1", "Bearer t", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.token != "" {
+			r.Header.Set(auth.DefaultHeaderUserToken, c.token)
+		}
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Fatal(c.name, w.Code, w.Body.String())
+		}
+	}
+
+	// 客户端令牌不是合法的base64时返回401，而不是panic
+	r := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	r.Header.Set(auth.DefaultHeaderClientToken, auth.DefaultHeaderSchema+" !!!")
+	w := httptest.NewRecorder()
+	m.RequireClient()(handler).ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{auth.ErrAuthFail, http.StatusUnauthorized},
+		{auth.ErrPermFail, http.StatusForbidden},
+		{auth.ErrRateLimit, http.StatusTooManyRequests},
+		{auth.ErrInternalError, http.StatusServiceUnavailable},
+		{auth.ErrAuthServiceUnavailable, http.StatusServiceUnavailable},
+		{&auth.AuthError{Code: auth.CodeInternalError, Err: auth.ErrInternalError}, http.StatusServiceUnavailable},
+		{&auth.AuthError{Code: auth.CodeServiceUnavailable, Err: auth.ErrAuthServiceUnavailable}, http.StatusServiceUnavailable},
+		{&auth.AuthError{Code: 4001, Err: auth.ErrPermFail}, http.StatusForbidden},
+	} {
+		if status := StatusCode(c.err, http.StatusForbidden); status != c.status {
+			t.Fatal(c.err, status)
+		}
+	}
+}
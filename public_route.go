@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// 伪请求头，GetHeaderFun通过这些键提供请求方法、路径和来源地址，供公开路由判断使用
+const (
+	PseudoHeaderMethod     = ":method"
+	PseudoHeaderPath       = ":path"
+	PseudoHeaderRemoteAddr = ":remote-addr"
+)
+
+// PublicRoute 无需鉴权的路由，Path的写法与ActionRoute相同，Methods为空时匹配任意方法，
+// CIDRs不为空时来源地址需在其中之一，Headers中的请求头需等于指定值，值为*时只要求请求头存在
+type PublicRoute struct {
+	Methods []string          `json:"methods,omitempty" yaml:"methods,omitempty"`
+	Path    string            `json:"path" yaml:"path"`
+	CIDRs   []string          `json:"cidrs,omitempty" yaml:"cidrs,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+}
+
+// ParsePublicRoutes 解析JSON或YAML格式的公开路由列表
+func ParsePublicRoutes(data []byte, format string) ([]PublicRoute, error) {
+	var routes []PublicRoute
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		err = json.Unmarshal(data, &routes)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &routes)
+	default:
+		return nil, fmt.Errorf("不支持的公开路由格式: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = newPublicRoutes(routes); err != nil {
+		return nil, err
+	}
+	return routes, nil
+}
+
+// LoadPublicRoutesFile 根据文件扩展名解析JSON或YAML公开路由文件
+func LoadPublicRoutesFile(path string) ([]PublicRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicRoutes(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// publicRoutes 编译后的公开路由，同一请求匹配多条路由时只以最具体的路由的条件为准
+type publicRoutes struct {
+	matcher    *ActionMatcher
+	conditions map[string]*publicCondition // 方法和路径 -> 条件
+}
+
+type publicCondition struct {
+	networks []*net.IPNet
+	headers  map[string]string
+}
+
+func newPublicRoutes(routes []PublicRoute) (*publicRoutes, error) {
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	actionRoutes := make([]ActionRoute, 0, len(routes))
+	conditions := make([]*publicCondition, 0, len(routes))
+	for _, route := range routes {
+		condition := &publicCondition{headers: route.Headers}
+		for _, cidr := range route.CIDRs {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidActionRoute, err.Error())
+			}
+			condition.networks = append(condition.networks, network)
+		}
+		methods := route.Methods
+		if len(methods) == 0 {
+			methods = []string{ActionWildcard}
+		}
+		for _, method := range methods {
+			actionRoutes = append(actionRoutes, ActionRoute{Method: method, Path: route.Path})
+			conditions = append(conditions, condition)
+		}
+	}
+	matcher, err := NewActionMatcher(actionRoutes)
+	if err != nil {
+		return nil, err
+	}
+	p := &publicRoutes{matcher: matcher, conditions: make(map[string]*publicCondition, len(actionRoutes))}
+	for i, route := range actionRoutes {
+		p.conditions[publicRouteKey(strings.ToUpper(route.Method), route.Path)] = conditions[i]
+	}
+	return p, nil
+}
+
+// match method和requestPath为空时从伪请求头读取，路径规范化后再匹配
+func (p *publicRoutes) match(f GetHeaderFun, method string, requestPath string) bool {
+	if p == nil || f == nil {
+		return false
+	}
+	if len(method) == 0 {
+		method = f(PseudoHeaderMethod)
+	}
+	if len(requestPath) == 0 {
+		requestPath = f(PseudoHeaderPath)
+	}
+	requestPath, ok := cleanPublicPath(requestPath)
+	if len(method) == 0 || !ok {
+		return false
+	}
+	match, ok := p.matcher.Match(ActionWildcard, method, requestPath)
+	if !ok {
+		return false
+	}
+	return p.conditions[publicRouteKey(match.Route.Method, match.Route.Path)].allows(f)
+}
+
+// cleanPublicPath 包含..段或//的路径不视为公开路由，路由器未重定向到规范路径时可能将其解析为其他路由
+func cleanPublicPath(requestPath string) (string, bool) {
+	if !strings.HasPrefix(requestPath, "/") || strings.Contains(requestPath, "//") {
+		return "", false
+	}
+	for _, segment := range strings.Split(requestPath, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	return path.Clean(requestPath), true
+}
+
+func publicRouteKey(method string, path string) string {
+	return method + " " + path
+}
+
+func (c *publicCondition) allows(f GetHeaderFun) bool {
+	for header, want := range c.headers {
+		got := f(header)
+		if len(got) == 0 || (want != ActionWildcard && got != want) {
+			return false
+		}
+	}
+	if len(c.networks) == 0 {
+		return true
+	}
+	addr := f(PseudoHeaderRemoteAddr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return false
+	}
+	for _, network := range c.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultRandomKeyWindow = 5 * time.Minute
+
+// RandomKeyStore 记录已使用的随机码，RememberRandomKey在随机码未使用过时记录并返回true，ttl内重复使用返回false
+type RandomKeyStore interface {
+	RememberRandomKey(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
+var (
+	_ RandomKeyStore    = (*RedisJwtUtil)(nil)
+	_ RandomKeyStore    = (*MemoryRandomKeyStore)(nil)
+	_ RandomKeyProvider = (*RandomKeyValidator)(nil)
+)
+
+// RandomKeyValidator 拒绝在window内重复使用的随机码，bindTimestamp为true时还要求随机码的时间戳与当前时间相差不超过window
+type RandomKeyValidator struct {
+	store         RandomKeyStore
+	window        time.Duration
+	bindTimestamp bool
+	now           func() time.Time
+}
+
+func NewRandomKeyValidator(store RandomKeyStore, window time.Duration, bindTimestamp bool) *RandomKeyValidator {
+	if window <= 0 {
+		window = DefaultRandomKeyWindow
+	}
+	return &RandomKeyValidator{store: store, window: window, bindTimestamp: bindTimestamp, now: time.Now}
+}
+
+func (v *RandomKeyValidator) IsRandomKeyOk(ctx context.Context, key string) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrRandomKeyEmpty
+	}
+	if len(key) > RandomKeyMaxLength {
+		return false, nil
+	}
+	ttl := v.window
+	if v.bindTimestamp {
+		ts, _, found := strings.Cut(key, RandomKeyTimestampJoiner)
+		if !found {
+			return false, nil
+		}
+		sec, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return false, nil
+		}
+		age := v.now().Sub(time.Unix(sec, 0))
+		if age > v.window || age < -v.window {
+			return false, nil
+		}
+		// 时间戳过期后随机码本身就会被拒绝，只需记录到过期为止
+		ttl = v.window - age
+		if ttl < time.Second {
+			ttl = time.Second
+		}
+	}
+	return v.store.RememberRandomKey(ctx, key, ttl)
+}
+
+// MemoryRandomKeyStore 进程内的随机码记录，仅适用于单实例部署和测试
+type MemoryRandomKeyStore struct {
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewMemoryRandomKeyStore() *MemoryRandomKeyStore {
+	return &MemoryRandomKeyStore{keys: make(map[string]time.Time)}
+}
+
+func (s *MemoryRandomKeyStore) RememberRandomKey(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.After(s.nextSweep) {
+		for k, expireAt := range s.keys {
+			if now.After(expireAt) {
+				delete(s.keys, k)
+			}
+		}
+		s.nextSweep = now.Add(time.Minute)
+	}
+	if expireAt, ok := s.keys[key]; ok && now.Before(expireAt) {
+		return false, nil
+	}
+	s.keys[key] = now.Add(ttl)
+	return true, nil
+}
+
+// RememberRandomKey 使用SET NX EX记录随机码，键为Prefix::RandomKey::随机码
+func (j *RedisJwtUtil) RememberRandomKey(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return j.UniversalClient().SetNX(ctx, j.GetRandomKeyCacheKey(key), 1, ttl).Result()
+}
+
+func (j *RedisJwtUtil) GetRandomKeyCacheKey(key string) string {
+	return strings.Join([]string{j.Config.Prefix, RandomKeyCachePrefix, key}, j.Config.CacheSplitter)
+}
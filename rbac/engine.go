@@ -0,0 +1,95 @@
+package rbac
+
+import (
+	"context"
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/go-logr/logr"
+	"sync/atomic"
+)
+
+const DefaultRedisKey = "Rbac::Policy"
+
+// Engine 基于角色的权限判断，实现auth.PermProvider，可通过auth.WithPermProvider用于LocalAuthChecker
+// 用户的角色为Policy.Users中该用户id的角色与Policy.Kinds中该用户类型的角色之和
+type Engine struct {
+	store    Store
+	compiled atomic.Pointer[compiled]
+	logger   logr.Logger
+}
+
+var _ auth.PermProvider = (*Engine)(nil)
+
+// NewEngine 从store加载策略，策略无效时返回错误
+func NewEngine(ctx context.Context, store Store, options ...Option) (*Engine, error) {
+	e := &Engine{store: store}
+	for _, opt := range options {
+		opt(e)
+	}
+	if e.logger.GetSink() == nil {
+		e.logger = logr.Discard()
+	}
+	if err := e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload 重新从store加载策略，新策略无效时保留原策略并返回错误
+func (e *Engine) Reload(ctx context.Context) error {
+	policy, err := e.store.Load(ctx)
+	if err != nil {
+		e.logger.Error(err, "load rbac policy failed")
+		return err
+	}
+	return e.Update(policy)
+}
+
+// Update 直接替换为新的策略，新策略无效时保留原策略并返回错误
+func (e *Engine) Update(policy *Policy) error {
+	c, err := policy.compile()
+	if err != nil {
+		e.logger.Error(err, "compile rbac policy failed")
+		return err
+	}
+	e.compiled.Store(c)
+	return nil
+}
+
+func (e *Engine) HasPermByCode(_ context.Context, user *auth.JwtUser, code string) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	return e.compiled.Load().has(user.Id, user.Kind, code), nil
+}
+
+// HasPermByAction 按Policy.Actions查找操作需要的权限码，用户需拥有全部权限码；
+// 没有匹配的路由时，将操作视为权限码Action.Key()进行判断
+func (e *Engine) HasPermByAction(_ context.Context, user *auth.JwtUser, service string, method string, path string) (bool, error) {
+	if user == nil {
+		return false, nil
+	}
+	c := e.compiled.Load()
+	match, ok := c.actions.Match(service, method, path)
+	if !ok {
+		return c.has(user.Id, user.Kind, auth.Action{Service: service, Method: method, Path: path}.Key()), nil
+	}
+	for _, code := range match.Route.Codes {
+		if !c.has(user.Id, user.Kind, code) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// MatchAction 查找操作对应的路由
+func (e *Engine) MatchAction(service string, method string, path string) (*auth.ActionMatch, bool) {
+	return e.compiled.Load().actions.Match(service, method, path)
+}
+
+// Roles 返回用户拥有的角色
+func (e *Engine) Roles(user *auth.JwtUser) []string {
+	if user == nil {
+		return nil
+	}
+	return e.compiled.Load().roles(user.Id, user.Kind)
+}
@@ -0,0 +1,11 @@
+package rbac
+
+import "github.com/go-logr/logr"
+
+type Option func(*Engine)
+
+func WithLogger(logger logr.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
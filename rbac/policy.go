@@ -0,0 +1,152 @@
+package rbac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	auth "github.com/Macrow/auth-go-sdk"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrUnknownRole = errors.New("角色不存在")
+	ErrRoleCycle   = errors.New("角色继承存在循环")
+	ErrPolicyEmpty = errors.New("权限策略为空")
+)
+
+// Role 角色拥有的权限码，以及继承的角色
+type Role struct {
+	Inherits []string `json:"inherits,omitempty" yaml:"inherits,omitempty"`
+	Perms    []string `json:"perms,omitempty" yaml:"perms,omitempty"`
+}
+
+// Policy 角色定义，用户id、用户类型与角色的对应关系，以及操作需要的权限码
+type Policy struct {
+	Roles   map[string]Role     `json:"roles" yaml:"roles"`
+	Users   map[string][]string `json:"users,omitempty" yaml:"users,omitempty"` // 用户id -> 角色
+	Kinds   map[string][]string `json:"kinds,omitempty" yaml:"kinds,omitempty"` // 用户类型 -> 角色
+	Actions []auth.ActionRoute  `json:"actions,omitempty" yaml:"actions,omitempty"`
+}
+
+// ParsePolicy 按格式解析策略，format为json或yaml
+func ParsePolicy(data []byte, format string) (*Policy, error) {
+	policy := &Policy{}
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		err = json.Unmarshal(data, policy)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, policy)
+	default:
+		return nil, fmt.Errorf("不支持的策略格式: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// LoadPolicyFile 根据文件扩展名解析JSON或YAML策略文件
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// compile 展开角色继承，得到每个角色的全部权限码
+func (p *Policy) compile() (*compiled, error) {
+	if p == nil {
+		return nil, ErrPolicyEmpty
+	}
+	c := &compiled{
+		perms: make(map[string]map[string]struct{}, len(p.Roles)),
+		users: p.Users,
+		kinds: p.Kinds,
+	}
+	visiting := make(map[string]bool)
+	var expand func(name string) (map[string]struct{}, error)
+	expand = func(name string) (map[string]struct{}, error) {
+		if perms, ok := c.perms[name]; ok {
+			return perms, nil
+		}
+		role, ok := p.Roles[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRole, name)
+		}
+		if visiting[name] {
+			return nil, fmt.Errorf("%w: %s", ErrRoleCycle, name)
+		}
+		visiting[name] = true
+		perms := make(map[string]struct{}, len(role.Perms))
+		for _, code := range role.Perms {
+			perms[code] = struct{}{}
+		}
+		for _, parent := range role.Inherits {
+			inherited, err := expand(parent)
+			if err != nil {
+				return nil, err
+			}
+			for code := range inherited {
+				perms[code] = struct{}{}
+			}
+		}
+		visiting[name] = false
+		c.perms[name] = perms
+		return perms, nil
+	}
+	for name := range p.Roles {
+		if _, err := expand(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, bindings := range []map[string][]string{p.Users, p.Kinds} {
+		for subject, roles := range bindings {
+			for _, role := range roles {
+				if _, ok := c.perms[role]; !ok {
+					return nil, fmt.Errorf("%w: %s (%s)", ErrUnknownRole, role, subject)
+				}
+			}
+		}
+	}
+	actions, err := auth.NewActionMatcher(p.Actions)
+	if err != nil {
+		return nil, err
+	}
+	c.actions = actions
+	return c, nil
+}
+
+type compiled struct {
+	perms   map[string]map[string]struct{} // 角色 -> 展开继承后的权限码
+	users   map[string][]string
+	kinds   map[string][]string
+	actions *auth.ActionMatcher
+}
+
+func (c *compiled) has(id string, kind string, code string) bool {
+	for _, roles := range [][]string{c.users[id], c.kinds[kind]} {
+		for _, role := range roles {
+			if _, ok := c.perms[role][code]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compiled) roles(id string, kind string) []string {
+	roles := make([]string, 0, len(c.users[id])+len(c.kinds[kind]))
+	seen := make(map[string]struct{})
+	for _, role := range append(append([]string{}, c.users[id]...), c.kinds[kind]...) {
+		if _, ok := seen[role]; !ok {
+			seen[role] = struct{}{}
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
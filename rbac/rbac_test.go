@@ -0,0 +1,109 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	auth "github.com/Macrow/auth-go-sdk"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEngine(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "policy.yaml")
+	err := os.WriteFile(yamlPath, []byte(`
+roles:
+  viewer:
+    perms: [order:read]
+  editor:
+    inherits: [viewer]
+    perms: [order:write]
+  admin:
+    inherits: [editor]
+    perms: ["shop GET /admin"]
+users:
+  alice: [admin]
+kinds:
+  staff: [viewer]
+actions:
+  - service: shop
+    method: GET
+    path: /orders/:id
+    codes: [order:read]
+  - service: shop
+    path: /orders/**
+    codes: [order:read, order:write]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := NewEngine(ctx, NewFileStore(yamlPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "alice", Kind: "user"}}
+	bob := &auth.JwtUser{RawJwtUser: auth.RawJwtUser{Id: "bob", Kind: "staff"}}
+	for _, c := range []struct {
+		user *auth.JwtUser
+		code string
+		want bool
+	}{
+		{alice, "order:read", true},
+		{alice, "order:write", true},
+		{bob, "order:read", true},
+		{bob, "order:write", false},
+		{nil, "order:read", false},
+	} {
+		if ok, _ := engine.HasPermByCode(ctx, c.user, c.code); ok != c.want {
+			t.Fatal(c.user, c.code, ok)
+		}
+	}
+	if ok, _ := engine.HasPermByAction(ctx, alice, "shop", "GET", "/admin"); !ok {
+		t.Fatal("alice should be able to access admin")
+	}
+	for _, c := range []struct {
+		user   *auth.JwtUser
+		method string
+		want   bool
+	}{
+		{bob, "GET", true},
+		{bob, "DELETE", false},
+		{alice, "DELETE", true},
+	} {
+		if ok, _ := engine.HasPermByAction(ctx, c.user, "shop", c.method, "/orders/1"); ok != c.want {
+			t.Fatal(c.user.Id, c.method, ok)
+		}
+	}
+	if roles := engine.Roles(bob); !reflect.DeepEqual(roles, []string{"viewer"}) {
+		t.Fatal(roles)
+	}
+
+	// 无效的策略不会替换当前策略
+	err = engine.Update(&Policy{Roles: map[string]Role{"a": {Inherits: []string{"b"}}, "b": {Inherits: []string{"a"}}}})
+	if !errors.Is(err, ErrRoleCycle) {
+		t.Fatal(err)
+	}
+	err = engine.Update(&Policy{Roles: map[string]Role{}, Users: map[string][]string{"alice": {"root"}}})
+	if !errors.Is(err, ErrUnknownRole) {
+		t.Fatal(err)
+	}
+	if ok, _ := engine.HasPermByCode(ctx, alice, "order:write"); !ok {
+		t.Fatal("policy should be kept after invalid update")
+	}
+
+	jsonPath := filepath.Join(dir, "policy.json")
+	if err = os.WriteFile(jsonPath, []byte(`{"roles":{"viewer":{"perms":["order:read"]}},"users":{"alice":["viewer"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	engine.store = NewFileStore(jsonPath)
+	if err = engine.Reload(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := engine.HasPermByCode(ctx, alice, "order:write"); ok {
+		t.Fatal("reloaded policy should revoke order:write")
+	}
+}
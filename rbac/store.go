@@ -0,0 +1,77 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+)
+
+// Store 权限策略的数据源
+type Store interface {
+	Load(ctx context.Context) (*Policy, error)
+}
+
+// FileStore 从JSON或YAML文件读取策略，格式由扩展名决定
+type FileStore struct {
+	Path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (s *FileStore) Load(_ context.Context) (*Policy, error) {
+	return LoadPolicyFile(s.Path)
+}
+
+// MemoryStore 直接使用内存中的策略
+type MemoryStore struct {
+	Policy *Policy
+}
+
+func NewMemoryStore(policy *Policy) *MemoryStore {
+	return &MemoryStore{Policy: policy}
+}
+
+func (s *MemoryStore) Load(_ context.Context) (*Policy, error) {
+	if s.Policy == nil {
+		return nil, ErrPolicyEmpty
+	}
+	return s.Policy, nil
+}
+
+// RedisStore 以JSON格式将策略保存在redis的一个键中，可配合auth.RedisJwtUtil.UniversalClient使用
+type RedisStore struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+func NewRedisStore(client redis.UniversalClient, key string) *RedisStore {
+	if len(key) == 0 {
+		key = DefaultRedisKey
+	}
+	return &RedisStore{Client: client, Key: key}
+}
+
+func (s *RedisStore) Load(ctx context.Context) (*Policy, error) {
+	data, err := s.Client.Get(ctx, s.Key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrPolicyEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParsePolicy(data, "json")
+}
+
+// Save 校验并保存策略，之后需调用Engine.Reload使其生效
+func (s *RedisStore) Save(ctx context.Context, policy *Policy) error {
+	if _, err := policy.compile(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(ctx, s.Key, data, 0).Err()
+}
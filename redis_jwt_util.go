@@ -2,7 +2,7 @@ package auth
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"encoding/json"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redis_rate/v9"
@@ -17,15 +17,30 @@ type RedisJwtUtil struct {
 	Config             JwtUtilConfig
 	RedisClient        *redis.Client
 	RedisClusterClient *redis.ClusterClient
-	PublicKey          *rsa.PublicKey
-	PrivateKey         *rsa.PrivateKey
+	PublicKey          crypto.PublicKey
+	PrivateKey         crypto.PrivateKey
+	Keyring            *JwtKeyring // 签名和校验使用的秘钥环，为nil时使用PublicKey和PrivateKey
 	RateLimiter        *redis_rate.Limiter
+	// sharedRedis redis连接复用自重新加载前的实例，创建失败时不能关闭
+	sharedRedis bool
+	// redisRefs 由RedisJwtUtilBuilder创建，复用连接的实例共用同一个计数
+	redisRefs *redisRefs
+	// optionErrors 选项遇到的配置错误，NewRedisJwtUtilE返回这些错误，NewRedisJwtUtil则panic
+	optionErrors configProblems
 }
 
 func (j *RedisJwtUtil) IsRedisCluster() bool {
 	return strings.Contains(j.Config.Address, ",")
 }
 
+// UniversalClient 返回当前使用的redis客户端，单机和集群模式通用
+func (j *RedisJwtUtil) UniversalClient() redis.UniversalClient {
+	if j.IsRedisCluster() {
+		return j.RedisClusterClient
+	}
+	return j.RedisClient
+}
+
 func (j *RedisJwtUtil) GetUserJwtCacheKey(id, did string, iat float64) string {
 	return strings.Join([]string{j.Config.Prefix, id, did + DidAndIatJoiner + strconv.Itoa(int(iat))}, j.Config.CacheSplitter)
 }
@@ -39,24 +54,31 @@ func (j *RedisJwtUtil) GetUserJwtCacheKeyPrefix(id string) string {
 }
 
 func (j *RedisJwtUtil) GenerateJwt(id, username, kind, deviceId string, issueAt float64, expireAt float64) (jwtUser *JwtUser, err error) {
+	return j.generateJwt(id, username, kind, deviceId, issueAt, expireAt, nil)
+}
+
+// generateJwt extra为标准声明以外的声明，签发后保存在JwtUser.Extra中
+func (j *RedisJwtUtil) generateJwt(id, username, kind, deviceId string, issueAt float64, expireAt float64, extra map[string]any) (*JwtUser, error) {
 	if len(j.Config.Issuer) == 0 {
 		j.Config.Issuer = DefaultIssuer
 	}
-	rawToken := jwt.New(jwt.SigningMethodRS256)
-	claims := rawToken.Claims.(jwt.MapClaims)
-	claims[JwtTokenClaimsId] = id
-	claims[JwtTokenClaimsName] = username
-	claims[JwtTokenClaimsKind] = kind
-	claims[JwtTokenClaimsDeviceId] = deviceId
-	claims[JwtTokenClaimsIssuer] = j.Config.Issuer
-	claims[JwtTokenClaimsIssueAt] = issueAt
-	claims[JwtTokenClaimsExpireAt] = expireAt
-
-	token, err := rawToken.SignedString(j.PrivateKey)
+	claims := jwt.MapClaims{
+		JwtTokenClaimsId:       id,
+		JwtTokenClaimsName:     username,
+		JwtTokenClaimsKind:     kind,
+		JwtTokenClaimsDeviceId: deviceId,
+		JwtTokenClaimsIssuer:   j.Config.Issuer,
+		JwtTokenClaimsIssueAt:  issueAt,
+		JwtTokenClaimsExpireAt: expireAt,
+	}
+	for name, val := range extra {
+		claims[name] = val
+	}
+	token, err := j.signJwt(claims)
 	if err != nil {
 		return nil, err
 	}
-	jwtUser = &JwtUser{
+	jwtUser := &JwtUser{
 		RawJwtUser: RawJwtUser{
 			Id:   id,
 			Name: username,
@@ -67,51 +89,55 @@ func (j *RedisJwtUtil) GenerateJwt(id, username, kind, deviceId string, issueAt
 			Exp:  expireAt,
 		},
 		Token: token,
+		Extra: extra,
 	}
-
 	return jwtUser, nil
 }
 
 func (j *RedisJwtUtil) ValidateJwt(tokenString string) (*JwtUser, error) {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if method, ok := t.Method.(*jwt.SigningMethodRSA); !ok || method.Alg() != "RS256" {
-			return nil, ErrJwtErrFormat
-		}
-		return j.PublicKey, nil
-	})
-	if err != nil || token == nil || !token.Valid {
-		return nil, ErrJwtErrFormat
-	}
-	// 解析令牌并存储为JwtUser格式
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, ErrJwtErrFormat
+	keyring, err := j.keyring()
+	if err != nil {
+		return nil, err
 	}
+	return parseJwtUser(tokenString, keyring.lookup)
+}
 
-	if claims[JwtTokenClaimsId] == nil ||
-		claims[JwtTokenClaimsName] == nil ||
-		claims[JwtTokenClaimsKind] == nil ||
-		claims[JwtTokenClaimsDeviceId] == nil ||
-		claims[JwtTokenClaimsIssuer] == nil ||
-		claims[JwtTokenClaimsIssueAt] == nil ||
-		claims[JwtTokenClaimsExpireAt] == nil {
-		return nil, ErrJwtErrVersion
+func (j *RedisJwtUtil) ValidateJwtClaims(tokenString string) (map[string]any, error) {
+	keyring, err := j.keyring()
+	if err != nil {
+		return nil, err
 	}
+	return parseJwtAccessClaims(tokenString, keyring.lookup)
+}
 
-	jwtUser := &JwtUser{
-		RawJwtUser: RawJwtUser{
-			Id:   claims[JwtTokenClaimsId].(string),
-			Name: claims[JwtTokenClaimsName].(string),
-			Kind: claims[JwtTokenClaimsKind].(string),
-			Did:  claims[JwtTokenClaimsDeviceId].(string),
-			Iss:  claims[JwtTokenClaimsIssuer].(string),
-			Iat:  claims[JwtTokenClaimsIssueAt].(float64),
-			Exp:  claims[JwtTokenClaimsExpireAt].(float64),
-		},
-		Token: tokenString,
+// signJwt 使用秘钥环当前的签名秘钥签名，秘钥有kid时写入令牌头
+func (j *RedisJwtUtil) signJwt(claims jwt.MapClaims) (string, error) {
+	keyring, err := j.keyring()
+	if err != nil {
+		return "", err
+	}
+	key, err := keyring.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+	rawToken := jwt.NewWithClaims(jwtSigningMethods[key.Algorithm], claims)
+	if len(key.Kid) > 0 {
+		rawToken.Header[JwtTokenHeaderKeyId] = key.Kid
 	}
+	return rawToken.SignedString(key.PrivateKey)
+}
 
-	return jwtUser, nil
+// keyring 未配置Keyring时，使用PublicKey和PrivateKey组成只有一个秘钥的秘钥环
+func (j *RedisJwtUtil) keyring() (*JwtKeyring, error) {
+	if j.Keyring != nil {
+		return j.Keyring, nil
+	}
+	return NewJwtKeyringWithEntries(&JwtKeyringEntry{
+		Algorithm:         j.Config.Algorithm,
+		AllowedAlgorithms: j.Config.AllowedAlgorithms,
+		PublicKey:         j.PublicKey,
+		PrivateKey:        j.PrivateKey,
+	})
 }
 
 func (j *RedisJwtUtil) SignJwtAndSaveToCache(id, name, kind, did string) *JwtUser {
@@ -135,23 +161,41 @@ func (j *RedisJwtUtil) SignJwtAndSaveToCache(id, name, kind, did string) *JwtUse
 }
 
 func (j *RedisJwtUtil) CheckJwtIsInCache(jwtUser *JwtUser) bool {
+	exists, err := j.IsJwtInCache(j.Ctx, jwtUser)
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
+// IsJwtInCache 与CheckJwtIsInCache相同，但返回redis错误而不是panic。
+// 令牌带jti时（RefreshJwt签发的令牌）还要求与会话中保存的jti一致，同一秒内刷新后原令牌即失效
+func (j *RedisJwtUtil) IsJwtInCache(ctx context.Context, jwtUser *JwtUser) (bool, error) {
 	if jwtUser == nil {
-		return false
+		return false, nil
 	}
 	key := j.GetUserJwtCacheKey(jwtUser.Id, jwtUser.Did, jwtUser.Iat)
-	if j.IsRedisCluster() {
-		exists, err := j.RedisClusterClient.Do(j.Ctx, "EXISTS", key).Bool()
-		if err != nil {
-			panic(err)
-		}
-		return exists
-	} else {
-		exists, err := j.RedisClient.Do(j.Ctx, "EXISTS", key).Bool()
-		if err != nil {
-			panic(err)
-		}
-		return exists
+	jti := jwtTokenId(jwtUser)
+	if len(jti) == 0 {
+		return j.UniversalClient().Do(ctx, "EXISTS", key).Bool()
 	}
+	data, err := j.UniversalClient().Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	saved := &JwtUser{}
+	if err = json.Unmarshal(data, saved); err != nil {
+		return false, nil
+	}
+	return jwtTokenId(saved) == jti, nil
+}
+
+func jwtTokenId(jwtUser *JwtUser) string {
+	jti, _ := jwtUser.Extra[JwtTokenClaimsTokenId].(string)
+	return jti
 }
 
 func (j *RedisJwtUtil) DelJwtByUserId(id string) {
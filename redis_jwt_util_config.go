@@ -1,21 +1,95 @@
 package auth
 
+import (
+	"fmt"
+	"time"
+)
+
 type Redis struct {
-	Address  string
-	Db       int
-	Password string
+	Address  string `json:"address" yaml:"address"`
+	Db       int    `json:"db" yaml:"db"`
+	Password string `json:"password" yaml:"password"`
 }
 
 type Jwt struct {
-	Prefix          string
-	CacheSplitter   string
-	Issuer          string
-	ExpireInMinutes int
-	PublicKey       []byte
-	PrivateKey      []byte
+	Prefix          string   `json:"prefix" yaml:"prefix"`
+	CacheSplitter   string   `json:"cacheSplitter" yaml:"cacheSplitter"`
+	Issuer          string   `json:"issuer" yaml:"issuer"`
+	ExpireInMinutes int      `json:"expireInMinutes" yaml:"expireInMinutes"`
+	PublicKey       PemBytes `json:"publicKey" yaml:"publicKey"`
+	PrivateKey      PemBytes `json:"privateKey" yaml:"privateKey"`
+	// Algorithm 签名算法，如RS256、PS256、ES256、EdDSA，为空时按私钥类型选择
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+	// AllowedAlgorithms 校验令牌时允许的算法，为空时只允许Algorithm，切换算法期间可同时列出新旧算法
+	AllowedAlgorithms []string `json:"allowedAlgorithms" yaml:"allowedAlgorithms"`
+	// Kid PublicKey和PrivateKey的kid，为空时令牌头不带kid
+	Kid string `json:"kid" yaml:"kid"`
+	// Keys 用于轮换的其他秘钥，与PublicKey和PrivateKey组成秘钥环，配置Keys时PublicKey和PrivateKey可以为空
+	Keys []JwtKey `json:"keys" yaml:"keys"`
+	// RefreshExpireInMinutes 刷新令牌的有效期，每次刷新后重新计算，为0时使用DefaultRefreshExpireInMinutes
+	RefreshExpireInMinutes int `json:"refreshExpireInMinutes" yaml:"refreshExpireInMinutes"`
+}
+
+// keyring 由PublicKey、PrivateKey和Keys组成的秘钥环，问题记录到problems
+func (c *Jwt) keyring(problems *configProblems) *JwtKeyring {
+	keyring := &JwtKeyring{}
+	if len(c.PublicKey) > 0 || len(c.PrivateKey) > 0 {
+		keyring.add(problems, "jwt", JwtKey{
+			Kid:               c.Kid,
+			Algorithm:         c.Algorithm,
+			AllowedAlgorithms: c.AllowedAlgorithms,
+			PublicKey:         c.PublicKey,
+			PrivateKey:        c.PrivateKey,
+		})
+	}
+	for i, key := range c.Keys {
+		keyring.add(problems, fmt.Sprintf("jwt.keys[%d]", i), key)
+	}
+	return keyring
+}
+
+// PemBytes PEM格式的秘钥，在JSON和YAML中以字符串表示
+type PemBytes []byte
+
+func (p PemBytes) MarshalText() ([]byte, error) {
+	return p, nil
+}
+
+func (p *PemBytes) UnmarshalText(text []byte) error {
+	*p = append((*p)[:0], text...)
+	return nil
 }
 
 type JwtUtilConfig struct {
-	Redis
-	Jwt
+	Redis `json:"redis" yaml:"redis"`
+	Jwt   `json:"jwt" yaml:"jwt"`
+}
+
+// Validate 检查配置并一次返回全部问题，返回的错误为ConfigErrors
+func (c *JwtUtilConfig) Validate() error {
+	problems := configProblems{}
+	if len(c.Redis.Address) == 0 {
+		problems.add("redis.address", "不能为空")
+	}
+	validateNonNegative(&problems, "redis.db", int64(c.Redis.Db))
+	validateNonNegative(&problems, "jwt.refreshExpireInMinutes", int64(c.Jwt.RefreshExpireInMinutes))
+	if len(c.Jwt.Keys) == 0 {
+		if len(c.Jwt.PrivateKey) == 0 {
+			problems.add("jwt.privateKey", "不能为空")
+		}
+		if len(c.Jwt.PublicKey) == 0 {
+			problems.add("jwt.publicKey", "不能为空")
+		}
+		if len(c.Jwt.PrivateKey) == 0 || len(c.Jwt.PublicKey) == 0 {
+			return problems.err()
+		}
+	}
+	before := len(problems)
+	keyring := c.Jwt.keyring(&problems)
+	if len(problems) == before {
+		if _, err := keyring.SigningKey(time.Now()); err != nil {
+			problems.add("jwt.keys", "当前没有可用于签名的私钥")
+		}
+	}
+	return problems.err()
 }
@@ -4,17 +4,18 @@ import (
 	"context"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redis_rate/v9"
-	"github.com/golang-jwt/jwt/v4"
 	"strings"
 )
 
 type JwtUtilOption func(util *RedisJwtUtil)
 
+// WithRedisConfig 地址为空时记录为redis.address的配置错误
 func WithRedisConfig(config Redis) JwtUtilOption {
-	if len(config.Address) == 0 {
-		panic("redis地址配置错误")
-	}
 	return func(util *RedisJwtUtil) {
+		if len(config.Address) == 0 {
+			util.optionErrors.add("redis.address", "不能为空")
+			return
+		}
 		util.Config.Redis.Address = config.Address
 		util.Config.Redis.Db = config.Db
 		util.Config.Redis.Password = config.Password
@@ -37,51 +38,115 @@ func WithRedisConfig(config Redis) JwtUtilOption {
 	}
 }
 
+// WithJwtConfig 秘钥配置错误时记录为jwt下对应字段的配置错误
 func WithJwtConfig(config Jwt) JwtUtilOption {
-	if len(config.PublicKey) == 0 || len(config.PrivateKey) == 0 {
-		panic("RSA秘钥对配置错误")
-	}
-	if len(config.Prefix) == 0 {
-		config.Prefix = DefaultCachePrefix
-	}
-	if len(config.CacheSplitter) == 0 {
-		config.CacheSplitter = DefaultCacheSplitter
-	}
-	if len(config.Issuer) == 0 {
-		config.Issuer = DefaultIssuer
-	}
-	if config.ExpireInMinutes <= 0 {
-		config.ExpireInMinutes = -1
-	}
 	return func(util *RedisJwtUtil) {
-		util.Config.Jwt.Prefix = config.Prefix
-		util.Config.Jwt.CacheSplitter = config.CacheSplitter
-		util.Config.Jwt.Issuer = config.Issuer
-		util.Config.Jwt.ExpireInMinutes = config.ExpireInMinutes
-		util.Config.Jwt.PublicKey = config.PublicKey
-		util.Config.Jwt.PrivateKey = config.PrivateKey
-
-		PrivateKey, err := jwt.ParseRSAPrivateKeyFromPEM(config.PrivateKey)
-		if err != nil {
-			panic(err)
+		if len(config.Keys) == 0 && (len(config.PublicKey) == 0 || len(config.PrivateKey) == 0) {
+			util.optionErrors.add("jwt.keys", "需要配置秘钥对或秘钥环")
+			return
 		}
-		util.PrivateKey = PrivateKey
-
-		PublicKey, err := jwt.ParseRSAPublicKeyFromPEM(config.PublicKey)
-		if err != nil {
-			panic(err)
+		if len(config.Prefix) == 0 {
+			config.Prefix = DefaultCachePrefix
+		}
+		if len(config.CacheSplitter) == 0 {
+			config.CacheSplitter = DefaultCacheSplitter
+		}
+		if len(config.Issuer) == 0 {
+			config.Issuer = DefaultIssuer
+		}
+		if config.ExpireInMinutes <= 0 {
+			config.ExpireInMinutes = -1
+		}
+		if config.RefreshExpireInMinutes <= 0 {
+			config.RefreshExpireInMinutes = DefaultRefreshExpireInMinutes
+		}
+		problems := configProblems{}
+		keyring := config.keyring(&problems)
+		if len(problems) > 0 {
+			util.optionErrors = append(util.optionErrors, problems...)
+			return
+		}
+		util.Config.Jwt = config
+		util.Keyring = keyring
+		// PublicKey和PrivateKey保留为未轮换前的秘钥对，签名和校验使用Keyring
+		if len(config.PublicKey) > 0 || len(config.PrivateKey) > 0 {
+			entry := keyring.Entries()[0]
+			util.Config.Jwt.Algorithm = entry.Algorithm
+			util.PublicKey = entry.PublicKey
+			util.PrivateKey = entry.PrivateKey
 		}
-		util.PublicKey = PublicKey
 	}
 }
 
+// defaultJwtUtilConfig LoadJwtUtilConfig使用的默认配置
+func defaultJwtUtilConfig() *JwtUtilConfig {
+	return &JwtUtilConfig{
+		Jwt: Jwt{
+			Prefix:                 DefaultCachePrefix,
+			CacheSplitter:          DefaultCacheSplitter,
+			Issuer:                 DefaultIssuer,
+			ExpireInMinutes:        -1,
+			RefreshExpireInMinutes: DefaultRefreshExpireInMinutes,
+		},
+	}
+}
+
+// NewRedisJwtUtil 配置错误时panic，错误为ConfigErrors
 func NewRedisJwtUtil(ctx context.Context, options ...JwtUtilOption) *RedisJwtUtil {
-	util := &RedisJwtUtil{Ctx: ctx}
-	for _, opt := range options {
-		opt(util)
+	util, err := NewRedisJwtUtilE(ctx, options...)
+	if err != nil {
+		panic(err)
 	}
 	if (util.RedisClient == nil && util.RedisClusterClient == nil) || util.RateLimiter == nil {
 		panic("请配置redis参数")
 	}
 	return util
 }
+
+// NewRedisJwtUtilE 与NewRedisJwtUtil相同，但配置错误时返回ConfigErrors而不是panic
+func NewRedisJwtUtilE(ctx context.Context, options ...JwtUtilOption) (*RedisJwtUtil, error) {
+	util := &RedisJwtUtil{Ctx: ctx}
+	for _, opt := range options {
+		opt(util)
+	}
+	err := util.optionErrors.err()
+	if err == nil {
+		err = util.Config.Validate()
+	}
+	if err != nil {
+		if !util.sharedRedis {
+			util.closeRedis()
+		}
+		return nil, err
+	}
+	return util, nil
+}
+
+// NewRedisJwtUtilWithConfig 使用LoadJwtUtilConfig等方式得到的配置创建RedisJwtUtil，先校验配置，校验通过后才创建redis连接，options在配置之后应用
+func NewRedisJwtUtilWithConfig(ctx context.Context, config *JwtUtilConfig, options ...JwtUtilOption) (*RedisJwtUtil, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewRedisJwtUtilE(ctx, append([]JwtUtilOption{WithRedisConfig(config.Redis), WithJwtConfig(config.Jwt)}, options...)...)
+}
+
+// withRedisClientsFrom 复用util的redis连接，用于重新加载配置时redis配置未变化的情况
+func withRedisClientsFrom(util *RedisJwtUtil) JwtUtilOption {
+	return func(next *RedisJwtUtil) {
+		next.Config.Redis = util.Config.Redis
+		next.RedisClient = util.RedisClient
+		next.RedisClusterClient = util.RedisClusterClient
+		next.RateLimiter = util.RateLimiter
+		next.sharedRedis = true
+		next.redisRefs = util.redisRefs
+	}
+}
+
+func (j *RedisJwtUtil) closeRedis() {
+	if j.RedisClient != nil {
+		_ = j.RedisClient.Close()
+	}
+	if j.RedisClusterClient != nil {
+		_ = j.RedisClusterClient.Close()
+	}
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"time"
+)
+
+// JwtTokenPair 访问令牌和对应的刷新令牌
+type JwtTokenPair struct {
+	*JwtUser
+	RefreshToken    string  `json:"refreshToken"`
+	RefreshExpireAt float64 `json:"refreshExpireAt"`
+}
+
+// refreshTokenFamily 同一次登录后不断轮换的刷新令牌，只有Jti对应的刷新令牌可以使用
+type refreshTokenFamily struct {
+	Jti  string  `json:"jti"`
+	Name string  `json:"name"`
+	Kind string  `json:"kind"`
+	Iat  float64 `json:"iat"` // 当前访问令牌的签发时间，用于注销访问令牌
+}
+
+// refreshTokenClaims 刷新令牌中的声明，刷新令牌也是JWT，使用与访问令牌相同的秘钥环签名
+type refreshTokenClaims struct {
+	id, did, fid, jti string
+}
+
+// rotateRefreshTokenScript 当前令牌为ARGV[1]时替换为ARGV[2]并返回1，令牌族不存在时返回0，
+// 当前令牌不是ARGV[1]说明旧令牌被重复使用，删除令牌族并返回2
+var rotateRefreshTokenScript = redis.NewScript(`
+local data = redis.call('GET', KEYS[1])
+if not data then
+	return 0
+end
+if cjson.decode(data)['jti'] ~= ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 2
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// GetRefreshTokenCacheKey 令牌族保存在Prefix::用户id::设备id::RefreshToken::令牌族id，
+// DelJwtByUserId和DelJwtByUserIdAndDeviceId会同时删除刷新令牌
+func (j *RedisJwtUtil) GetRefreshTokenCacheKey(id, did, fid string) string {
+	return strings.Join([]string{j.Config.Prefix, id, did, RefreshTokenCachePrefix, fid}, j.Config.CacheSplitter)
+}
+
+// SignJwtWithRefreshToken 与SignJwtAndSaveToCache相同，同时签发新令牌族的刷新令牌
+func (j *RedisJwtUtil) SignJwtWithRefreshToken(id, name, kind, did string) (*JwtTokenPair, error) {
+	family := &refreshTokenFamily{Name: name, Kind: kind}
+	return j.issueJwtTokenPair(j.Ctx, refreshTokenClaims{id: id, did: did, fid: GenerateRandomKey()}, family, "")
+}
+
+// RefreshJwt 使用刷新令牌签发新的访问令牌和刷新令牌，原访问令牌和刷新令牌随即失效。
+// 刷新令牌无效或已过期时返回ErrRefreshTokenFail，已经使用过的刷新令牌再次使用时注销整个令牌族并返回ErrRefreshTokenReused
+func (j *RedisJwtUtil) RefreshJwt(refreshToken string) (*JwtTokenPair, error) {
+	ctx := j.Ctx
+	claims, err := j.parseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	key := j.GetRefreshTokenCacheKey(claims.id, claims.did, claims.fid)
+	family, err := j.getRefreshTokenFamily(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if family.Jti != claims.jti {
+		return nil, j.revokeReusedRefreshToken(ctx, claims, family)
+	}
+	return j.issueJwtTokenPair(ctx, claims, family, claims.jti)
+}
+
+// RevokeRefreshToken 注销刷新令牌所在的令牌族和当前的访问令牌，用于退出登录
+func (j *RedisJwtUtil) RevokeRefreshToken(refreshToken string) error {
+	ctx := j.Ctx
+	claims, err := j.parseRefreshToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	key := j.GetRefreshTokenCacheKey(claims.id, claims.did, claims.fid)
+	family, err := j.getRefreshTokenFamily(ctx, key)
+	if err == ErrRefreshTokenFail {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return j.UniversalClient().Del(ctx, key, j.GetUserJwtCacheKey(claims.id, claims.did, family.Iat)).Err()
+}
+
+// issueJwtTokenPair 签发访问令牌和刷新令牌，previousJti为空时创建令牌族，否则从previousJti轮换
+func (j *RedisJwtUtil) issueJwtTokenPair(ctx context.Context, claims refreshTokenClaims, family *refreshTokenFamily, previousJti string) (*JwtTokenPair, error) {
+	now := time.Now()
+	var exp int64
+	if j.Config.ExpireInMinutes > 0 {
+		exp = now.Add(time.Duration(j.Config.ExpireInMinutes) * time.Minute).Unix()
+	}
+	// 访问令牌带jti，同一秒内刷新时缓存键不变，会话中的jti更新后原访问令牌不再有效
+	jwtUser, err := j.generateJwt(claims.id, family.Name, family.Kind, claims.did, float64(now.Unix()), float64(exp),
+		map[string]any{JwtTokenClaimsTokenId: GenerateRandomKey()})
+	if err != nil {
+		return nil, err
+	}
+	refreshTtl := time.Duration(DefaultRefreshExpireInMinutes) * time.Minute
+	if j.Config.RefreshExpireInMinutes > 0 {
+		refreshTtl = time.Duration(j.Config.RefreshExpireInMinutes) * time.Minute
+	}
+	refreshExp := now.Add(refreshTtl).Unix()
+	next := &refreshTokenFamily{Jti: GenerateRandomKey(), Name: family.Name, Kind: family.Kind, Iat: jwtUser.Iat}
+	refreshToken, err := j.signJwt(jwt.MapClaims{
+		JwtTokenClaimsType:     JwtTokenTypeRefresh,
+		JwtTokenClaimsId:       claims.id,
+		JwtTokenClaimsDeviceId: claims.did,
+		JwtTokenClaimsFamily:   claims.fid,
+		JwtTokenClaimsTokenId:  next.Jti,
+		JwtTokenClaimsIssuer:   j.Config.Issuer,
+		JwtTokenClaimsIssueAt:  float64(now.Unix()),
+		JwtTokenClaimsExpireAt: float64(refreshExp),
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(next)
+	if err != nil {
+		return nil, err
+	}
+
+	client := j.UniversalClient()
+	key := j.GetRefreshTokenCacheKey(claims.id, claims.did, claims.fid)
+	if len(previousJti) == 0 {
+		err = client.Set(ctx, key, data, refreshTtl).Err()
+	} else {
+		var status int
+		status, err = rotateRefreshTokenScript.Run(ctx, client, []string{key}, previousJti, data, refreshTtl.Milliseconds()).Int()
+		if err == nil && status == 0 {
+			return nil, ErrRefreshTokenFail
+		}
+		if err == nil && status == 2 {
+			// 并发使用了同一个刷新令牌，令牌族已被删除
+			_ = client.Del(ctx, j.GetUserJwtCacheKey(claims.id, claims.did, family.Iat)).Err()
+			return nil, ErrRefreshTokenReused
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(previousJti) > 0 && family.Iat != jwtUser.Iat {
+		if err = client.Del(ctx, j.GetUserJwtCacheKey(claims.id, claims.did, family.Iat)).Err(); err != nil {
+			return nil, err
+		}
+	}
+	if err = j.saveJwtUser(ctx, jwtUser); err != nil {
+		return nil, err
+	}
+	return &JwtTokenPair{JwtUser: jwtUser, RefreshToken: refreshToken, RefreshExpireAt: float64(refreshExp)}, nil
+}
+
+// revokeReusedRefreshToken 删除令牌族和当前的访问令牌，返回ErrRefreshTokenReused
+func (j *RedisJwtUtil) revokeReusedRefreshToken(ctx context.Context, claims refreshTokenClaims, family *refreshTokenFamily) error {
+	err := j.UniversalClient().Del(ctx,
+		j.GetRefreshTokenCacheKey(claims.id, claims.did, claims.fid),
+		j.GetUserJwtCacheKey(claims.id, claims.did, family.Iat),
+	).Err()
+	if err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func (j *RedisJwtUtil) getRefreshTokenFamily(ctx context.Context, key string) (*refreshTokenFamily, error) {
+	data, err := j.UniversalClient().Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrRefreshTokenFail
+	}
+	if err != nil {
+		return nil, err
+	}
+	family := &refreshTokenFamily{}
+	if err = json.Unmarshal(data, family); err != nil {
+		return nil, err
+	}
+	return family, nil
+}
+
+// parseRefreshToken 校验刷新令牌的签名和有效期，访问令牌不能作为刷新令牌使用
+func (j *RedisJwtUtil) parseRefreshToken(refreshToken string) (refreshTokenClaims, error) {
+	keyring, err := j.keyring()
+	if err != nil {
+		return refreshTokenClaims{}, err
+	}
+	claims, err := parseJwtClaims(refreshToken, keyring.lookup)
+	if err != nil || claims[JwtTokenClaimsType] != JwtTokenTypeRefresh {
+		return refreshTokenClaims{}, ErrRefreshTokenFail
+	}
+	parsed := refreshTokenClaims{}
+	for name, val := range map[string]*string{
+		JwtTokenClaimsId:       &parsed.id,
+		JwtTokenClaimsDeviceId: &parsed.did,
+		JwtTokenClaimsFamily:   &parsed.fid,
+		JwtTokenClaimsTokenId:  &parsed.jti,
+	} {
+		s, ok := claims[name].(string)
+		if !ok || (len(s) == 0 && name != JwtTokenClaimsDeviceId) {
+			return refreshTokenClaims{}, ErrRefreshTokenFail
+		}
+		*val = s
+	}
+	return parsed, nil
+}
+
+// saveJwtUser 与SetJwtUser相同，但返回redis错误而不是panic
+func (j *RedisJwtUtil) saveJwtUser(ctx context.Context, jwtUser *JwtUser) error {
+	data, err := json.Marshal(jwtUser)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if j.Config.ExpireInMinutes > 0 {
+		ttl = time.Duration(j.Config.ExpireInMinutes) * time.Minute
+	}
+	return j.UniversalClient().Set(ctx, j.GetUserJwtCacheKey(jwtUser.Id, jwtUser.Did, jwtUser.Iat), data, ttl).Err()
+}
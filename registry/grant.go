@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Grant 授予客户端的权限码，Code支持*通配任意字符，如order:*或*，ExpireAt为零值表示不过期
+type Grant struct {
+	Code     string    `json:"code" yaml:"code"`
+	ExpireAt time.Time `json:"expireAt,omitempty" yaml:"expireAt,omitempty"`
+}
+
+func (g Grant) expired(now time.Time) bool {
+	return !g.ExpireAt.IsZero() && now.After(g.ExpireAt)
+}
+
+func (g Grant) allows(code string, now time.Time) bool {
+	return !g.expired(now) && matchWildcard(g.Code, code)
+}
+
+// Grant 授予客户端权限码，ttl为0表示不过期，已有相同权限码时更新其过期时间
+func (r *Registry) Grant(ctx context.Context, id string, code string, ttl time.Duration) error {
+	if len(code) == 0 {
+		return ErrInvalidGrant
+	}
+	grant := Grant{Code: code}
+	if ttl > 0 {
+		grant.ExpireAt = time.Now().Add(ttl)
+	}
+	return r.update(ctx, id, func(client *Client) {
+		client.Grants = append(pruneGrants(client.Grants, code), grant)
+	})
+}
+
+// Revoke 撤销客户端的权限码，需与授予时的Code完全一致
+func (r *Registry) Revoke(ctx context.Context, id string, code string) error {
+	return r.update(ctx, id, func(client *Client) {
+		client.Grants = pruneGrants(client.Grants, code)
+	})
+}
+
+// Grants 返回客户端未过期的权限码
+func (r *Registry) Grants(ctx context.Context, id string) ([]Grant, error) {
+	client, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return pruneGrants(client.Grants, ""), nil
+}
+
+// HasClientPermByCode 客户端已启用且拥有未过期的匹配权限码时返回true
+func (r *Registry) HasClientPermByCode(ctx context.Context, clientId string, code string) (bool, error) {
+	client, err := r.store.Get(ctx, clientId)
+	if err != nil {
+		r.logger.Error(err, "load client failed", "clientId", clientId)
+		return false, err
+	}
+	if client == nil || !client.Enabled {
+		return false, nil
+	}
+	now := time.Now()
+	for _, grant := range client.Grants {
+		if grant.allows(code, now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// pruneGrants 去掉已过期的权限码和与code相同的权限码
+func pruneGrants(grants []Grant, code string) []Grant {
+	now := time.Now()
+	pruned := make([]Grant, 0, len(grants))
+	for _, grant := range grants {
+		if grant.expired(now) || grant.Code == code {
+			continue
+		}
+		pruned = append(pruned, grant)
+	}
+	return pruned
+}
+
+// matchWildcard pattern中的*匹配任意长度的任意字符
+func matchWildcard(pattern string, code string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == code
+	}
+	if !strings.HasPrefix(code, parts[0]) {
+		return false
+	}
+	code = code[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(code, part)
+		if i < 0 {
+			return false
+		}
+		code = code[i+len(part):]
+	}
+	return len(code) >= len(last) && strings.HasSuffix(code, last)
+}
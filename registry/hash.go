@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var ErrUnknownHashFormat = errors.New("无法识别的秘钥哈希格式")
+
+// Hasher 生成秘钥的加盐哈希，哈希字符串自带算法和参数，VerifySecret可据此校验
+type Hasher interface {
+	Hash(secret string) (string, error)
+}
+
+// BcryptHasher 注意bcrypt只使用秘钥的前72个字节
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(secret string) (string, error) {
+	cost := h.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Argon2idHasher 输出格式为$argon2id$v=19$m=内存KiB,t=迭代次数,p=并行度$盐$哈希，盐和哈希使用无填充的Base64
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  int
+	KeyLength   uint32
+}
+
+// DefaultArgon2idHasher 采用OWASP推荐的最低参数，兼顾每次请求校验客户端的开销
+var DefaultArgon2idHasher = Argon2idHasher{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func (h Argon2idHasher) Hash(secret string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(secret), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifySecret 按哈希字符串的格式校验秘钥，比较过程为常量时间
+func VerifySecret(hash string, secret string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, secret)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+// 校验时接受的argon2id参数范围，超出范围的哈希视为无法识别，避免参数为0时panic、哈希为空时任意秘钥都能通过或参数过大耗尽资源
+const (
+	argon2MaxMemory     = 1024 * 1024 // KiB
+	argon2MaxIterations = 32
+	argon2MinSaltLength = 8
+	argon2MinKeyLength  = 16
+	argon2MaxKeyLength  = 1024
+)
+
+func verifyArgon2id(hash string, secret string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnknownHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnknownHashFormat
+	}
+	var memory, iterations uint32
+	var parallelism uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+		return false, ErrUnknownHashFormat
+	}
+	if parallelism == 0 || iterations == 0 || iterations > argon2MaxIterations ||
+		memory < 8*uint32(parallelism) || memory > argon2MaxMemory {
+		return false, ErrUnknownHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2MinSaltLength {
+		return false, ErrUnknownHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2MinKeyLength || len(key) > argon2MaxKeyLength {
+		return false, ErrUnknownHashFormat
+	}
+	actual := argon2.IDKey([]byte(secret), salt, iterations, memory, parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, actual) == 1, nil
+}
@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/go-logr/logr"
+	"sync"
+	"time"
+)
+
+const DefaultRedisKey = "Registry::Clients"
+
+var (
+	ErrClientExists   = errors.New("客户端已存在")
+	ErrClientNotFound = errors.New("客户端不存在")
+	ErrInvalidClient  = errors.New("客户端id和秘钥不能为空")
+	ErrInvalidGrant   = errors.New("权限码不能为空")
+)
+
+// Client 注册的客户端，只保存秘钥的加盐哈希
+type Client struct {
+	Id         string    `json:"id" yaml:"id"`
+	Name       string    `json:"name,omitempty" yaml:"name,omitempty"`
+	SecretHash string    `json:"secretHash" yaml:"secretHash"`
+	Enabled    bool      `json:"enabled" yaml:"enabled"`
+	Grants     []Grant   `json:"grants,omitempty" yaml:"grants,omitempty"`
+	CreatedAt  time.Time `json:"createdAt" yaml:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt" yaml:"updatedAt"`
+}
+
+func (c *Client) clone() *Client {
+	copied := *c
+	copied.Grants = append([]Grant(nil), c.Grants...)
+	return &copied
+}
+
+// Registry 客户端注册表，实现auth.ClientProvider和auth.ClientPermProvider，
+// 可通过auth.WithClientProvider和auth.WithClientPermProvider用于LocalAuthChecker
+type Registry struct {
+	store     Store
+	hasher    Hasher
+	logger    logr.Logger
+	dummyHash string // 客户端不存在时用于校验的哈希，保证两种情况耗时相同
+	// updateMu Store未实现Updater或Creator时串行执行修改和注册
+	updateMu sync.Mutex
+}
+
+var (
+	_ auth.ClientProvider     = (*Registry)(nil)
+	_ auth.ClientPermProvider = (*Registry)(nil)
+)
+
+func NewRegistry(store Store, options ...Option) *Registry {
+	r, err := NewRegistryE(store, options...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// NewRegistryE 与NewRegistry相同，但哈希算法不可用时返回错误而不是panic
+func NewRegistryE(store Store, options ...Option) (*Registry, error) {
+	r := &Registry{store: store, hasher: DefaultArgon2idHasher}
+	for _, opt := range options {
+		opt(r)
+	}
+	if r.logger.GetSink() == nil {
+		r.logger = logr.Discard()
+	}
+	dummyHash, err := r.hasher.Hash(auth.GenerateRandomKey())
+	if err != nil {
+		return nil, err
+	}
+	r.dummyHash = dummyHash
+	return r, nil
+}
+
+// Register 注册新的客户端，默认启用
+func (r *Registry) Register(ctx context.Context, id string, name string, secret string) (*Client, error) {
+	if len(id) == 0 || len(secret) == 0 {
+		return nil, ErrInvalidClient
+	}
+	hash, err := r.hasher.Hash(secret)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	client := &Client{Id: id, Name: name, SecretHash: hash, Enabled: true, CreatedAt: now, UpdatedAt: now}
+	if err = r.create(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// create Store实现Creator时由Store原子地完成检查和保存，否则在进程内加锁后检查并保存
+func (r *Registry) create(ctx context.Context, client *Client) error {
+	if creator, ok := r.store.(Creator); ok {
+		return creator.Create(ctx, client)
+	}
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+	existing, err := r.store.Get(ctx, client.Id)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrClientExists
+	}
+	return r.store.Put(ctx, client)
+}
+
+// SetSecret 更换客户端秘钥，旧秘钥立即失效
+func (r *Registry) SetSecret(ctx context.Context, id string, secret string) error {
+	if len(secret) == 0 {
+		return ErrInvalidClient
+	}
+	hash, err := r.hasher.Hash(secret)
+	if err != nil {
+		return err
+	}
+	return r.update(ctx, id, func(client *Client) {
+		client.SecretHash = hash
+	})
+}
+
+func (r *Registry) SetEnabled(ctx context.Context, id string, enabled bool) error {
+	return r.update(ctx, id, func(client *Client) {
+		client.Enabled = enabled
+	})
+}
+
+func (r *Registry) Remove(ctx context.Context, id string) error {
+	return r.store.Delete(ctx, id)
+}
+
+func (r *Registry) Get(ctx context.Context, id string) (*Client, error) {
+	client, err := r.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
+
+func (r *Registry) List(ctx context.Context) ([]*Client, error) {
+	return r.store.List(ctx)
+}
+
+// IsClientOk 客户端不存在或已禁用时同样计算一次哈希，避免通过响应时间判断客户端是否存在
+func (r *Registry) IsClientOk(ctx context.Context, clientId string, clientSecret string) (bool, error) {
+	client, err := r.store.Get(ctx, clientId)
+	if err != nil {
+		r.logger.Error(err, "load client failed", "clientId", clientId)
+		return false, err
+	}
+	if client == nil || !client.Enabled {
+		_, _ = VerifySecret(r.dummyHash, clientSecret)
+		return false, nil
+	}
+	ok, err := VerifySecret(client.SecretHash, clientSecret)
+	if err != nil {
+		r.logger.Error(err, "verify client secret failed", "clientId", clientId)
+		return false, err
+	}
+	return ok, nil
+}
+
+// update Store实现Updater时由Store原子地完成修改，否则在进程内加锁后读取、修改并写回
+func (r *Registry) update(ctx context.Context, id string, f func(client *Client)) error {
+	modify := func(client *Client) error {
+		f(client)
+		client.UpdatedAt = time.Now()
+		return nil
+	}
+	if updater, ok := r.store.(Updater); ok {
+		return updater.Update(ctx, id, modify)
+	}
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+	client, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	_ = modify(client)
+	return r.store.Put(ctx, client)
+}
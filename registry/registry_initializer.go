@@ -0,0 +1,18 @@
+package registry
+
+import "github.com/go-logr/logr"
+
+type Option func(*Registry)
+
+// WithHasher 设置新秘钥使用的哈希算法，默认为DefaultArgon2idHasher，已保存的哈希仍按各自的格式校验
+func WithHasher(hasher Hasher) Option {
+	return func(r *Registry) {
+		r.hasher = hasher
+	}
+}
+
+func WithLogger(logger logr.Logger) Option {
+	return func(r *Registry) {
+		r.logger = logger
+	}
+}
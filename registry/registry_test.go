@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	auth "github.com/Macrow/auth-go-sdk"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clients.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(store)
+	client, err := registry.Register(ctx, "orders", "订单服务", "s3cret")
+	if err != nil || !strings.HasPrefix(client.SecretHash, "$argon2id$") || strings.Contains(client.SecretHash, "s3cret") {
+		t.Fatal(client, err)
+	}
+	if _, err = registry.Register(ctx, "orders", "", "other"); !errors.Is(err, ErrClientExists) {
+		t.Fatal(err)
+	}
+	if _, err = NewRegistry(store, WithHasher(BcryptHasher{Cost: 4})).Register(ctx, "billing", "", "b1lling"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开文件，确认数据已持久化
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry = NewRegistry(store)
+	for _, c := range []struct {
+		id, secret string
+		want       bool
+	}{
+		{"orders", "s3cret", true},
+		{"orders", "wrong", false},
+		{"billing", "b1lling", true},
+		{"unknown", "s3cret", false},
+	} {
+		if ok, err := registry.IsClientOk(ctx, c.id, c.secret); ok != c.want || err != nil {
+			t.Fatal(c, ok, err)
+		}
+	}
+
+	checker := auth.NewLocalAuthChecker("", auth.WithClientProvider(registry))
+	if result, err := checker.CheckClientAuth(ctx, "orders", "s3cret"); err != nil || !result.ClientAuthOk {
+		t.Fatal(result, err)
+	}
+	token, _ := auth.GenerateClientToken("orders", "s3cret", nil)
+	header := func(string) string { return auth.DefaultHeaderSchema + " " + token }
+	if result, err := checker.CheckClientAuthByHeader(ctx, header); err != nil || result.ClientId != "orders" || !result.ClientAuthOk {
+		t.Fatal(result, err)
+	}
+	if err = registry.SetEnabled(ctx, "orders", false); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := checker.CheckClientAuth(ctx, "orders", "s3cret"); err != nil || result.ClientAuthOk {
+		t.Fatal("disabled client should be rejected", result, err)
+	}
+	if err = registry.SetEnabled(ctx, "orders", true); err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.SetSecret(ctx, "orders", "n3w"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := registry.IsClientOk(ctx, "orders", "s3cret"); ok {
+		t.Fatal("old secret should be rejected")
+	}
+	if err = registry.Remove(ctx, "billing"); err != nil {
+		t.Fatal(err)
+	}
+	if clients, _ := registry.List(ctx); len(clients) != 1 || clients[0].Id != "orders" {
+		t.Fatal(clients)
+	}
+	if err = registry.SetEnabled(ctx, "billing", true); !errors.Is(err, ErrClientNotFound) {
+		t.Fatal(err)
+	}
+	if _, err = VerifySecret("plain", "plain"); !errors.Is(err, ErrUnknownHashFormat) {
+		t.Fatal(err)
+	}
+
+	// 哈希算法不可用时创建失败，不会以空哈希跳过不存在客户端的校验
+	if _, err = NewRegistryE(NewMemoryStore(), WithHasher(BcryptHasher{Cost: 100})); err == nil {
+		t.Fatal("invalid hasher should be rejected")
+	}
+
+	// 写入文件失败时不保留内存中的修改
+	store, err = NewFileStore(filepath.Join(t.TempDir(), "missing", "clients.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry = NewRegistry(store, WithHasher(BcryptHasher{Cost: 4}))
+	if _, err = registry.Register(ctx, "orders", "", "s3cret"); err == nil {
+		t.Fatal("register should fail when the file cannot be written")
+	}
+	if _, err = registry.Get(ctx, "orders"); !errors.Is(err, ErrClientNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryGrants(t *testing.T) {
+	ctx := context.Background()
+	registry := NewRegistry(NewMemoryStore(), WithHasher(BcryptHasher{Cost: 4}))
+	if _, err := registry.Register(ctx, "orders", "", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []struct {
+		code string
+		ttl  time.Duration
+	}{
+		{"stock:*", 0},
+		{"user:read", 0},
+		{"billing:*:read", 0},
+		{"report:export", time.Nanosecond},
+	} {
+		if err := registry.Grant(ctx, "orders", g.code, g.ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+
+	checker := auth.NewLocalAuthChecker("", auth.WithClientProvider(registry), auth.WithClientPermProvider(registry))
+	for _, c := range []struct {
+		code string
+		want bool
+	}{
+		{"stock:read", true},
+		{"stock:", true},
+		{"user:read", true},
+		{"user:write", false},
+		{"billing:invoice:read", true},
+		{"billing:invoice:write", false},
+		{"report:export", false},
+	} {
+		result, err := checker.CheckClientPermByCode(ctx, "orders", "s3cret", c.code)
+		if err != nil || result.ClientPermOk != c.want {
+			t.Fatal(c.code, result, err)
+		}
+	}
+	if result, _ := checker.CheckClientPermByCode(ctx, "orders", "wrong", "user:read"); result.ClientPermOk {
+		t.Fatal("wrong secret should not pass permission check")
+	}
+
+	if err := registry.Revoke(ctx, "orders", "stock:*"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := registry.HasClientPermByCode(ctx, "orders", "stock:read"); ok {
+		t.Fatal("revoked grant should not match")
+	}
+	grants, err := registry.Grants(ctx, "orders")
+	if err != nil || len(grants) != 2 || grants[0].Code != "user:read" {
+		t.Fatal(grants, err)
+	}
+	if err = registry.Grant(ctx, "unknown", "user:read", 0); !errors.Is(err, ErrClientNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisStoreConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer func() { _ = client.Close() }()
+	// 两个Registry模拟两个实例，同时修改同一个客户端
+	first := NewRegistry(NewRedisStore(client, ""), WithHasher(BcryptHasher{Cost: 4}))
+	second := NewRegistry(NewRedisStore(client, ""), WithHasher(BcryptHasher{Cost: 4}))
+	if _, err := first.Register(ctx, "orders", "", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		registry := first
+		if i%2 == 1 {
+			registry = second
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- registry.Grant(ctx, "orders", fmt.Sprintf("code:%d", i), 0)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if grants, err := second.Grants(ctx, "orders"); err != nil || len(grants) != 8 {
+		t.Fatal(grants, err)
+	}
+	if err := second.SetEnabled(ctx, "unknown", true); !errors.Is(err, ErrClientNotFound) {
+		t.Fatal(err)
+	}
+
+	// 同时注册同一个客户端，只有一个成功，其余返回ErrClientExists，秘钥不会被覆盖
+	secrets := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		registry := first
+		if i%2 == 1 {
+			registry = second
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			secret := fmt.Sprintf("secret:%d", i)
+			if _, err := registry.Register(ctx, "billing", "", secret); err == nil {
+				secrets <- secret
+			} else if !errors.Is(err, ErrClientExists) {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(secrets)
+	if len(secrets) != 1 {
+		t.Fatal(len(secrets))
+	}
+	if ok, err := first.IsClientOk(ctx, "billing", <-secrets); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestVerifyArgon2idParams(t *testing.T) {
+	hash, err := DefaultArgon2idHasher.Hash("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifySecret(hash, "s3cret"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	parts := strings.Split(hash, "$")
+	for _, c := range []struct {
+		name   string
+		params string
+		salt   string
+		key    string
+	}{
+		{"zero parallelism", "m=19456,t=2,p=0", parts[4], parts[5]},
+		{"zero iterations", "m=19456,t=0,p=1", parts[4], parts[5]},
+		{"too many iterations", "m=19456,t=1000,p=1", parts[4], parts[5]},
+		{"memory below 8*p", "m=8,t=2,p=2", parts[4], parts[5]},
+		{"too much memory", "m=4294967295,t=2,p=1", parts[4], parts[5]},
+		{"parallelism overflow", "m=19456,t=2,p=256", parts[4], parts[5]},
+		{"empty key", "m=19456,t=2,p=1", parts[4], ""},
+		{"short salt", "m=19456,t=2,p=1", "c2FsdA", parts[5]},
+	} {
+		forged := strings.Join([]string{"", parts[1], parts[2], c.params, c.salt, c.key}, "$")
+		if ok, err := VerifySecret(forged, "anything"); ok || !errors.Is(err, ErrUnknownHashFormat) {
+			t.Fatal(c.name, ok, err)
+		}
+	}
+}
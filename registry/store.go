@@ -0,0 +1,337 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store 客户端数据源，Get在客户端不存在时返回nil
+type Store interface {
+	Get(ctx context.Context, id string) (*Client, error)
+	Put(ctx context.Context, client *Client) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*Client, error)
+}
+
+// Updater Store可以实现该接口，原子地读取、修改并写回一个客户端，f返回错误时不写回，客户端不存在时返回ErrClientNotFound。
+// Store未实现该接口时，Registry在进程内串行执行修改，无法避免多个实例同时修改造成的更新丢失
+type Updater interface {
+	Update(ctx context.Context, id string, f func(client *Client) error) error
+}
+
+// Creator Store可以实现该接口，在客户端不存在时原子地保存，客户端已存在时返回ErrClientExists。
+// Store未实现该接口时，Registry在进程内串行执行注册
+type Creator interface {
+	Create(ctx context.Context, client *Client) error
+}
+
+var (
+	_ Updater = (*MemoryStore)(nil)
+	_ Updater = (*FileStore)(nil)
+	_ Updater = (*RedisStore)(nil)
+	_ Creator = (*MemoryStore)(nil)
+	_ Creator = (*FileStore)(nil)
+	_ Creator = (*RedisStore)(nil)
+)
+
+// MemoryStore 进程内的客户端存储
+type MemoryStore struct {
+	mu      sync.RWMutex
+	clients map[string]*Client
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{clients: make(map[string]*Client)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) (*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if client, ok := s.clients[id]; ok {
+		return client.clone(), nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) Put(_ context.Context, client *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client.Id] = client.clone()
+	return nil
+}
+
+func (s *MemoryStore) Create(_ context.Context, client *Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.clients[client.Id]; ok {
+		return ErrClientExists
+	}
+	s.clients[client.Id] = client.clone()
+	return nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, id)
+	return nil
+}
+
+func (s *MemoryStore) Update(_ context.Context, id string, f func(client *Client) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.clients[id]
+	if !ok {
+		return ErrClientNotFound
+	}
+	client = client.clone()
+	if err := f(client); err != nil {
+		return err
+	}
+	s.clients[id] = client
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context) ([]*Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client.clone())
+	}
+	sortClients(clients)
+	return clients, nil
+}
+
+// FileStore 将全部客户端保存在一个JSON或YAML文件中，格式由扩展名决定，每次修改都会重写整个文件
+type FileStore struct {
+	mu     sync.Mutex
+	path   string
+	memory *MemoryStore
+}
+
+// NewFileStore 读取已有的文件，文件不存在时在第一次修改时创建
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, memory: NewMemoryStore()}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var clients []*Client
+	if s.isYaml() {
+		err = yaml.Unmarshal(data, &clients)
+	} else {
+		err = json.Unmarshal(data, &clients)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		s.memory.clients[client.Id] = client
+	}
+	return s, nil
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (*Client, error) {
+	return s.memory.Get(ctx, id)
+}
+
+func (s *FileStore) Put(ctx context.Context, client *Client) error {
+	return s.commit(ctx, func() error { return s.memory.Put(ctx, client) })
+}
+
+func (s *FileStore) Create(ctx context.Context, client *Client) error {
+	return s.commit(ctx, func() error { return s.memory.Create(ctx, client) })
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	return s.commit(ctx, func() error { return s.memory.Delete(ctx, id) })
+}
+
+func (s *FileStore) Update(ctx context.Context, id string, f func(client *Client) error) error {
+	return s.commit(ctx, func() error { return s.memory.Update(ctx, id, f) })
+}
+
+// commit 修改内存中的客户端后写入文件，写入失败时恢复修改前的客户端，保持内存与文件一致
+func (s *FileStore) commit(ctx context.Context, change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory.mu.RLock()
+	snapshot := make(map[string]*Client, len(s.memory.clients))
+	for id, client := range s.memory.clients {
+		snapshot[id] = client
+	}
+	s.memory.mu.RUnlock()
+	if err := change(); err != nil {
+		return err
+	}
+	if err := s.save(ctx); err != nil {
+		s.memory.mu.Lock()
+		s.memory.clients = snapshot
+		s.memory.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) List(ctx context.Context) ([]*Client, error) {
+	return s.memory.List(ctx)
+}
+
+// save 先写入临时文件再重命名，避免写入中途失败损坏原文件
+func (s *FileStore) save(ctx context.Context) error {
+	clients, _ := s.memory.List(ctx)
+	var data []byte
+	var err error
+	if s.isYaml() {
+		data, err = yaml.Marshal(clients)
+	} else {
+		data, err = json.MarshalIndent(clients, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) isYaml() bool {
+	ext := strings.ToLower(filepath.Ext(s.path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// redisUpdateAttempts RedisStore.Update因并发修改失败时的最大尝试次数
+const redisUpdateAttempts = 10
+
+// RedisStore 将客户端以JSON格式保存在redis的一个hash中，可配合auth.RedisJwtUtil.UniversalClient使用
+type RedisStore struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+func NewRedisStore(client redis.UniversalClient, key string) *RedisStore {
+	if len(key) == 0 {
+		key = DefaultRedisKey
+	}
+	return &RedisStore{Client: client, Key: key}
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) (*Client, error) {
+	data, err := s.Client.HGet(ctx, s.Key, id).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{}
+	if err = json.Unmarshal(data, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *RedisStore) Put(ctx context.Context, client *Client) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+	return s.Client.HSet(ctx, s.Key, client.Id, data).Err()
+}
+
+// Create 使用HSETNX保存客户端
+func (s *RedisStore) Create(ctx context.Context, client *Client) error {
+	data, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+	created, err := s.Client.HSetNX(ctx, s.Key, client.Id, data).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrClientExists
+	}
+	return nil
+}
+
+// Update 使用WATCH和MULTI修改客户端，期间hash被其他实例修改时重新读取后再试，最多尝试redisUpdateAttempts次
+func (s *RedisStore) Update(ctx context.Context, id string, f func(client *Client) error) error {
+	update := func(tx *redis.Tx) error {
+		data, err := tx.HGet(ctx, s.Key, id).Bytes()
+		if err == redis.Nil {
+			return ErrClientNotFound
+		}
+		if err != nil {
+			return err
+		}
+		client := &Client{}
+		if err = json.Unmarshal(data, client); err != nil {
+			return err
+		}
+		if err = f(client); err != nil {
+			return err
+		}
+		if data, err = json.Marshal(client); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, s.Key, id, data)
+			return nil
+		})
+		return err
+	}
+	var err error
+	for i := 0; i < redisUpdateAttempts; i++ {
+		if err = s.Client.Watch(ctx, update, s.Key); err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.Client.HDel(ctx, s.Key, id).Err()
+}
+
+func (s *RedisStore) List(ctx context.Context) ([]*Client, error) {
+	values, err := s.Client.HGetAll(ctx, s.Key).Result()
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]*Client, 0, len(values))
+	for _, data := range values {
+		client := &Client{}
+		if err = json.Unmarshal([]byte(data), client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	sortClients(clients)
+	return clients, nil
+}
+
+func sortClients(clients []*Client) {
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Id < clients[j].Id })
+}
@@ -1,9 +1,10 @@
 package auth
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"github.com/go-logr/logr"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -26,13 +27,18 @@ func GetNonEmptyValueWithBackup(val string, backup string) string {
 	return backup
 }
 
+// GenerateRandomKey 生成128位的密码学安全随机码，以十六进制输出
 func GenerateRandomKey() string {
-	rand.Seed(time.Now().UnixNano())
-	output := ""
-	for i := 0; i < 6; i++ {
-		output += strconv.Itoa(rand.Intn(10))
+	b := make([]byte, RandomKeyBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
-	return output
+	return hex.EncodeToString(b)
+}
+
+// GenerateTimestampedRandomKey 生成绑定当前时间的随机码，格式为"秒级时间戳.随机码"
+func GenerateTimestampedRandomKey() string {
+	return strconv.FormatInt(time.Now().Unix(), 10) + RandomKeyTimestampJoiner + GenerateRandomKey()
 }
 
 func ParseClientToken(clientToken string, encryptContent bool, aesUtil *AesUtil, logger logr.Logger) (clientId string, clientSecret string, err error) {
@@ -46,7 +52,8 @@ func ParseClientToken(clientToken string, encryptContent bool, aesUtil *AesUtil,
 	} else {
 		bytes, err := base64.StdEncoding.DecodeString(clientToken)
 		if err != nil {
-			panic(err)
+			logger.Error(err, err.Error())
+			return "", "", ErrClientTokenFail
 		}
 		idAndSecret = string(bytes)
 	}